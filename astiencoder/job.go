@@ -4,6 +4,8 @@ import "github.com/asticode/go-astikit"
 
 // Job represents a job
 type Job struct {
+	// Options applied to all inputs and outputs unless overridden by their own dict
+	Dict       string                  `json:"dict,omitempty"`
 	Inputs     map[string]JobInput     `json:"inputs"`
 	Operations map[string]JobOperation `json:"operations"`
 	Outputs    map[string]JobOutput    `json:"outputs"`
@@ -24,6 +26,7 @@ const (
 
 // JobOutput represents a job output
 type JobOutput struct {
+	Dict   string `json:"dict,omitempty"`
 	Format string `json:"format,omitempty"`
 	// Possible values are "default" and "pkt_dump"
 	Type string `json:"type,omitempty"`
@@ -39,17 +42,23 @@ const (
 // This can usually be compared to an encoding
 // Refrain from indicating all options in the dict and use other attributes instead
 type JobOperation struct {
+	BFrames *int `json:"b_frames,omitempty"`
 	BitRate *int `json:"bit_rate,omitempty"`
 	// Possible values are "copy" and all libav codec names.
 	Codec string `json:"codec,omitempty"`
 	Dict  string `json:"dict,omitempty"`
 	// Frame rate is a per-operation value since we may have different frame rate operations for a similar output
-	FrameRate   *astikit.Rational    `json:"frame_rate,omitempty"`
-	GopSize     *int                 `json:"gop_size,omitempty"`
-	Height      *int                 `json:"height,omitempty"`
-	Inputs      []JobOperationInput  `json:"inputs"`
+	FrameRate *astikit.Rational   `json:"frame_rate,omitempty"`
+	GopSize   *int                `json:"gop_size,omitempty"`
+	Height    *int                `json:"height,omitempty"`
+	Inputs    []JobOperationInput `json:"inputs"`
+	// If true, codec-appropriate low latency settings are applied. Explicit options take precedence
+	LowLatency bool `json:"low_latency,omitempty"`
+	// Number of frames the rate control looks ahead. Only supported by some encoders
+	Lookahead   *int                 `json:"lookahead,omitempty"`
 	Outputs     []JobOperationOutput `json:"outputs"`
 	PixelFormat string               `json:"pixel_format,omitempty"`
+	RefFrames   *int                 `json:"ref_frames,omitempty"`
 	ThreadCount *int                 `json:"thread_count,omitempty"`
 	// Since frame rate is a per-operation value, time base is as well
 	TimeBase *astikit.Rational `json:"time_base,omitempty"`
@@ -67,6 +76,11 @@ type JobOperationInput struct {
 
 // JobOperationOutput represents a job operation output
 type JobOperationOutput struct {
-	Name string `json:"name"`
-	PID  *int   `json:"pid,omitempty"`
+	// If true, the output stream is flagged as the default track
+	Default bool `json:"default,omitempty"`
+	// ISO 639-2 language code of the output stream
+	Language string `json:"language,omitempty"`
+	Name     string `json:"name"`
+	PID      *int   `json:"pid,omitempty"`
+	Title    string `json:"title,omitempty"`
 }
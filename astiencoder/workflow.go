@@ -113,6 +113,18 @@ func (b *builder) buildWorkflow(j Job, w *astiencoder.Workflow, eh *astiencoder.
 	return
 }
 
+// dict returns the node dict with the job dict as defaults
+func (b *builder) dict(j Job, d string) *astilibav.Dict {
+	var defaults, nd *astilibav.Dict
+	if j.Dict != "" {
+		defaults = astilibav.NewDefaultDict(j.Dict)
+	}
+	if d != "" {
+		nd = astilibav.NewDefaultDict(d)
+	}
+	return astilibav.NewDictWithDefaults(defaults, nd)
+}
+
 func (b *builder) openInputs(j Job, bd *buildData) (is map[string]openedInput, err error) {
 	// Loop through inputs
 	is = make(map[string]openedInput)
@@ -120,7 +132,7 @@ func (b *builder) openInputs(j Job, bd *buildData) (is map[string]openedInput, e
 		// Create demuxer
 		var d *astilibav.Demuxer
 		if d, err = astilibav.NewDemuxer(astilibav.DemuxerOptions{
-			Dict:        astilibav.NewDefaultDict(cfg.Dict),
+			Dict:        b.dict(j, cfg.Dict),
 			EmulateRate: cfg.EmulateRate,
 			URL:         cfg.URL,
 		}, bd.eh, bd.c, bd.s); err != nil {
@@ -154,6 +166,7 @@ func (b *builder) openOutputs(j Job, bd *buildData) (os map[string]openedOutput,
 		default:
 			// Create muxer
 			if oo.m, err = astilibav.NewMuxer(astilibav.MuxerOptions{
+				Dict:       b.dict(j, cfg.Dict),
 				FormatName: cfg.Format,
 				URL:        cfg.URL,
 			}, bd.eh, bd.c, bd.s); err != nil {
@@ -178,6 +191,14 @@ type operationOutput struct {
 	o openedOutput
 }
 
+func (o operationOutput) streamOptions() astilibav.StreamOptions {
+	return astilibav.StreamOptions{
+		Default:  o.c.Default,
+		Language: o.c.Language,
+		Title:    o.c.Title,
+	}
+}
+
 func (b *builder) addOperationToWorkflow(name string, o JobOperation, bd *buildData) (err error) {
 	// Get operation inputs and outputs
 	var ois []operationInput
@@ -215,8 +236,18 @@ func (b *builder) addOperationToWorkflow(name string, o JobOperation, bd *buildD
 						return
 					}
 
+					// Set stream options
+					if err = astilibav.SetStreamOptions(os, o.streamOptions()); err != nil {
+						err = fmt.Errorf("main: setting stream options of stream 0x%x(%d) of %s failed: %w", is.Id(), is.Id(), i.c.Name, err)
+						return
+					}
+
 					// Create muxer handler
-					h := o.o.m.NewPktHandler(os)
+					var h *astilibav.MuxerPktHandler
+					if h, err = o.o.m.NewPktHandler(os); err != nil {
+						err = fmt.Errorf("main: creating muxer handler for stream 0x%x(%d) of %s failed: %w", is.Id(), is.Id(), i.c.Name, err)
+						return
+					}
 
 					// Connect demuxer to handler
 					i.o.d.ConnectForStream(h, is)
@@ -243,7 +274,13 @@ func (b *builder) addOperationToWorkflow(name string, o JobOperation, bd *buildD
 
 			// Create encoder
 			var e *astilibav.Encoder
-			if e, err = astilibav.NewEncoder(astilibav.EncoderOptions{Ctx: outCtx}, bd.eh, bd.c, bd.s); err != nil {
+			if e, err = astilibav.NewEncoder(astilibav.EncoderOptions{
+				BFrames:    o.BFrames,
+				Ctx:        outCtx,
+				Lookahead:  o.Lookahead,
+				LowLatency: o.LowLatency,
+				RefFrames:  o.RefFrames,
+			}, bd.eh, bd.c, bd.s); err != nil {
 				err = fmt.Errorf("main: creating encoder for stream 0x%x(%d) of input %s failed: %w", is.Id(), is.Id(), i.c.Name, err)
 				return
 			}
@@ -279,8 +316,17 @@ func (b *builder) addOperationToWorkflow(name string, o JobOperation, bd *buildD
 						return
 					}
 
+					// Set stream options
+					if err = astilibav.SetStreamOptions(os, o.streamOptions()); err != nil {
+						err = fmt.Errorf("main: setting stream options for stream 0x%x(%d) of %s and output %s failed: %w", is.Id(), is.Id(), i.c.Name, o.c.Name, err)
+						return
+					}
+
 					// Create muxer handler
-					h = o.o.m.NewPktHandler(os)
+					if h, err = o.o.m.NewPktHandler(os); err != nil {
+						err = fmt.Errorf("main: creating muxer handler for stream 0x%x(%d) of %s and output %s failed: %w", is.Id(), is.Id(), i.c.Name, o.c.Name, err)
+						return
+					}
 				}
 
 				// Connect encoder to handler
@@ -393,8 +439,12 @@ func (b *builder) operationOutputCtx(o JobOperation, inCtx astilibav.Context, oo
 	// TODO Add audio options
 
 	// Set global header
-	if oos[0].o.m != nil {
-		outCtx.GlobalHeader = oos[0].o.m.CtxFormat().Oformat().Flags()&avformat.AVFMT_GLOBALHEADER > 0
+	// If one of the outputs requires global headers, the encoder needs to set them
+	for _, o := range oos {
+		if o.o.m != nil && o.o.m.GlobalHeader() {
+			outCtx.GlobalHeader = true
+			break
+		}
 	}
 	return
 }
@@ -17,13 +17,15 @@ var (
 	EventNameNodeStopped       = "astiencoder.node.stopped"
 	EventNameStats             = "astiencoder.stats"
 	EventNameWorkflowContinued = "astiencoder.workflow.continued"
-	EventNameWorkflowPaused    = "astiencoder.workflow.paused"
-	EventNameWorkflowStarted   = "astiencoder.workflow.started"
-	EventNameWorkflowStopped   = "astiencoder.workflow.stopped"
-	EventTypeContinued         = "continued"
-	EventTypePaused            = "paused"
-	EventTypeStarted           = "started"
-	EventTypeStopped           = "stopped"
+	// Payload is the node being started
+	EventNameWorkflowNodeStarting = "astiencoder.workflow.node.starting"
+	EventNameWorkflowPaused       = "astiencoder.workflow.paused"
+	EventNameWorkflowStarted      = "astiencoder.workflow.started"
+	EventNameWorkflowStopped      = "astiencoder.workflow.stopped"
+	EventTypeContinued            = "continued"
+	EventTypePaused               = "paused"
+	EventTypeStarted              = "started"
+	EventTypeStopped              = "stopped"
 )
 
 // Event is an event coming out of the encoder
@@ -64,6 +66,17 @@ func NewEventHandler() *EventHandler {
 
 // Add adds a new callback for a specific target and event name
 func (h *EventHandler) Add(target interface{}, eventName string, c EventCallback) {
+	h.add(target, eventName, c)
+}
+
+// AddDeletable adds a new callback for a specific target and event name and returns a func deleting it
+// It's useful when the callback must be deleted before it's called again, e.g. when its owner is closed
+func (h *EventHandler) AddDeletable(target interface{}, eventName string, c EventCallback) (del func()) {
+	idx := h.add(target, eventName, c)
+	return func() { h.del(target, eventName, idx) }
+}
+
+func (h *EventHandler) add(target interface{}, eventName string, c EventCallback) int {
 	h.m.Lock()
 	defer h.m.Unlock()
 	if _, ok := h.cs[target]; !ok {
@@ -74,6 +87,7 @@ func (h *EventHandler) Add(target interface{}, eventName string, c EventCallback
 	}
 	h.idx++
 	h.cs[target][eventName][h.idx] = c
+	return h.idx
 }
 
 // AddForEventName adds a new callback for a specific event name
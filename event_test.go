@@ -48,3 +48,17 @@ func TestEvent(t *testing.T) {
 	})
 	assert.Equal(t, []string{"2", "4", "5"}, es)
 }
+
+func TestEventHandlerAddDeletable(t *testing.T) {
+	eh := NewEventHandler()
+	var count int
+	del := eh.AddDeletable("test", "test", func(evt Event) bool {
+		count++
+		return false
+	})
+	eh.Emit(Event{Name: "test", Target: "test"})
+	assert.Equal(t, 1, count)
+	del()
+	eh.Emit(Event{Name: "test", Target: "test"})
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,305 @@
+package astilibav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avutil"
+)
+
+var countAudioFifo uint64
+
+// AudioFifo represents an object capable of buffering audio samples and outputting frames with a fixed
+// number of samples, which is required by encoders such as AAC
+type AudioFifo struct {
+	*astiencoder.BaseNode
+	c                  *astikit.Chan
+	d                  *frameDispatcher
+	eh                 *astiencoder.EventHandler
+	f                  *audioFifo
+	frameSize          int
+	m                  *sync.Mutex // Locks frameSize
+	nbSamples          int64       // Number of samples output since startPts
+	outputCtx          Context
+	p                  *framePool
+	padLastFrame       bool
+	previousDescriptor Descriptor
+	startPts           int64
+	statIncomingRate   *astikit.CounterRateStat
+	statProcessedRate  *astikit.CounterRateStat
+}
+
+// AudioFifoOptions represents audio fifo options
+type AudioFifoOptions struct {
+	// Number of samples of output frames. If 0, it is detected from the first connected handler
+	// having a FrameSize() method such as an Encoder
+	FrameSize int
+	// Context of the frames coming in
+	InputCtx Context
+	Node     astiencoder.NodeOptions
+	// If true, the last frame flushed at EOF is padded with silence so that every output frame has exactly
+	// frame size samples, like ffmpeg's asetnsamples filter
+	PadLastFrame bool
+}
+
+// NewAudioFifo creates a new audio fifo
+func NewAudioFifo(o AudioFifoOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (a *AudioFifo, err error) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countAudioFifo, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("audio_fifo_%d", count), fmt.Sprintf("Audio Fifo #%d", count), "Buffers audio samples", "audio fifo")
+
+	// Create audio fifo
+	a = &AudioFifo{
+		c:                 astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
+		eh:                eh,
+		m:                 &sync.Mutex{},
+		outputCtx:         o.InputCtx,
+		p:                 newFramePool(c),
+		padLastFrame:      o.PadLastFrame,
+		statIncomingRate:  astikit.NewCounterRateStat(),
+		statProcessedRate: astikit.NewCounterRateStat(),
+	}
+
+	// Create base node
+	a.BaseNode = astiencoder.NewBaseNode(o.Node, eh, s, a, astiencoder.EventTypeToNodeEventName)
+
+	// Create frame dispatcher
+	a.d = newFrameDispatcher(a, eh, a.p)
+
+	// Add stats
+	a.addStats()
+
+	// Check input ctx
+	if o.InputCtx.CodecType != avutil.AVMEDIA_TYPE_AUDIO {
+		err = errors.New("astilibav: input ctx is not audio")
+		return
+	}
+
+	// Set frame size
+	if o.FrameSize > 0 {
+		a.setFrameSize(o.FrameSize)
+	}
+
+	// Alloc fifo
+	if a.f = newAudioFifo(o.InputCtx.SampleFmt, o.InputCtx.Channels, 1); a.f == nil {
+		err = errors.New("astilibav: av_audio_fifo_alloc failed")
+		return
+	}
+
+	// Make sure the fifo is freed
+	c.Add(func() error {
+		a.f.free()
+		return nil
+	})
+	return
+}
+
+func (a *AudioFifo) addStats() {
+	// Get stats
+	ss := a.c.Stats()
+	ss = append(ss, a.d.stats()...)
+	ss = append(ss,
+		astikit.StatOptions{
+			Handler: a.statIncomingRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames coming in per second",
+				Label:       "Incoming rate",
+				Name:        StatNameIncomingRate,
+				Unit:        "fps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: a.statProcessedRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames processed per second",
+				Label:       "Processed rate",
+				Name:        StatNameProcessedRate,
+				Unit:        "fps",
+			},
+		},
+	)
+
+	// Add stats
+	a.BaseNode.AddStats(ss...)
+}
+
+func (a *AudioFifo) setFrameSize(s int) {
+	// Lock
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	// Update
+	a.frameSize = s
+	a.outputCtx.FrameSize = s
+	a.p.setBufferCtx(a.outputCtx)
+}
+
+// FrameSize returns the number of samples of output frames
+func (a *AudioFifo) FrameSize() int {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.frameSize
+}
+
+// OutputCtx returns the output ctx
+func (a *AudioFifo) OutputCtx() Context {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.outputCtx
+}
+
+// Connect implements the FrameHandlerConnector interface
+func (a *AudioFifo) Connect(h FrameHandler) {
+	// Detect frame size
+	if v, ok := h.(interface{ FrameSize() int }); ok && v.FrameSize() > 0 && a.FrameSize() == 0 {
+		a.setFrameSize(v.FrameSize())
+	}
+
+	// Add handler
+	a.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(a, h)
+}
+
+// Disconnect implements the FrameHandlerConnector interface
+func (a *AudioFifo) Disconnect(h FrameHandler) {
+	// Delete handler
+	a.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(a, h)
+}
+
+// Drain implements the Drainer interface
+func (a *AudioFifo) Drain(ctx context.Context) error {
+	return drainChan(ctx, a.BaseNode, a.c)
+}
+
+// Start starts the audio fifo
+func (a *AudioFifo) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	a.BaseNode.Start(ctx, t, func(t *astikit.Task) {
+		// Make sure to flush remaining samples
+		defer a.flush()
+
+		// Make sure to stop the chan properly
+		defer a.c.Stop()
+
+		// Start chan
+		a.c.Start(a.Context())
+	})
+}
+
+// HandleFrame implements the FrameHandler interface
+func (a *AudioFifo) HandleFrame(p FrameHandlerPayload) {
+	// Increment incoming rate
+	a.statIncomingRate.Add(1)
+
+	// Copy frame
+	f := a.p.get()
+	if ret := avutil.AvFrameRef(f, p.Frame); ret < 0 {
+		a.p.put(f)
+		emitAvError(a, a.eh, ret, "avutil.AvFrameRef failed")
+		return
+	}
+
+	// Add to chan
+	a.c.Add(func() {
+		// Handle pause
+		defer a.HandlePause()
+
+		// Make sure to close frame
+		defer a.p.put(f)
+
+		// Increment processed rate
+		a.statProcessedRate.Add(1)
+
+		// Get frame size
+		s := a.FrameSize()
+		if s <= 0 {
+			a.eh.Emit(astiencoder.EventError(a, errors.New("astilibav: frame size is unknown")))
+			return
+		}
+
+		// Fifo is empty, output pts starts from the incoming frame
+		if a.f.size() == 0 {
+			a.nbSamples = 0
+			a.startPts = f.Pts()
+		}
+
+		// Store descriptor
+		a.previousDescriptor = p.Descriptor
+
+		// Write samples
+		if ret := a.f.write(f); ret < 0 {
+			emitAvError(a, a.eh, ret, "av_audio_fifo_write failed")
+			return
+		}
+
+		// Output as many frames as possible
+		for a.f.size() >= s {
+			if stop := a.output(s); stop {
+				return
+			}
+		}
+	})
+}
+
+func (a *AudioFifo) flush() {
+	// Nothing to flush
+	if a.previousDescriptor == nil {
+		return
+	}
+
+	// Output remaining samples
+	if n := a.f.size(); n > 0 {
+		a.output(n)
+	}
+}
+
+// output reads nbSamples samples out of the fifo and dispatches them. If padding is enabled, frames
+// with less samples than the frame size are padded with silence
+func (a *AudioFifo) output(nbSamples int) (stop bool) {
+	// Get frame
+	f, err := a.p.getWithBuffer()
+	if err != nil {
+		a.eh.Emit(astiencoder.EventError(a, fmt.Errorf("astilibav: getting frame failed: %w", err)))
+		stop = true
+		return
+	}
+
+	// Make sure to close frame
+	defer a.p.put(f)
+
+	// Read samples
+	ret := a.f.read(f, nbSamples)
+	if ret < 0 {
+		emitAvError(a, a.eh, ret, "av_audio_fifo_read failed")
+		stop = true
+		return
+	}
+	f.SetNbSamples(ret)
+
+	// Pad with silence
+	if s := a.FrameSize(); a.padLastFrame && ret < s {
+		samplesSetSilence(f, ret, s-ret, a.outputCtx.Channels, a.outputCtx.SampleFmt)
+		f.SetNbSamples(s)
+	}
+
+	// Set pts based on the number of samples output so far so that rounding errors don't add up
+	if a.startPts == avutil.AV_NOPTS_VALUE {
+		f.SetPts(avutil.AV_NOPTS_VALUE)
+	} else {
+		f.SetPts(a.startPts + avutil.AvRescaleQ(a.nbSamples, avutil.NewRational(1, a.outputCtx.SampleRate), a.previousDescriptor.TimeBase()))
+	}
+	a.nbSamples += int64(ret)
+
+	// Dispatch frame
+	a.d.dispatch(f, a.previousDescriptor)
+	return
+}
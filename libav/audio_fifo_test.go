@@ -0,0 +1,88 @@
+package astilibav
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testDescriptor struct {
+	timeBase avutil.Rational
+}
+
+func (d testDescriptor) TimeBase() avutil.Rational {
+	return d.timeBase
+}
+
+func TestAudioFifo(t *testing.T) {
+	// Create
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	ctx := NewAudioContext(48000, avcodec.AvSampleFormat(avutil.AV_SAMPLE_FMT_FLTP), avutil.AV_CH_LAYOUT_STEREO, avutil.NewRational(1, 1000))
+	a, err := NewAudioFifo(AudioFifoOptions{
+		FrameSize:    1024,
+		InputCtx:     ctx,
+		PadLastFrame: true,
+	}, eh, c, nil)
+	require.NoError(t, err)
+
+	// Connect handler
+	p := newFramePool(c)
+	h := newTestFrameHandler("test", eh, p)
+	var nbSamples []int
+	var ptss []int64
+	h.fn = func(p FrameHandlerPayload) {
+		nbSamples = append(nbSamples, p.Frame.NbSamples())
+		ptss = append(ptss, p.Frame.Pts())
+	}
+	a.Connect(h)
+
+	// Start
+	w := astikit.NewWorker(astikit.WorkerOptions{})
+	defer w.Stop()
+	wctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.Start(wctx, w.NewTask)
+
+	// Handle frames
+	for _, v := range []struct {
+		nbSamples int
+		pts       int64
+	}{
+		{nbSamples: 1536, pts: 0},
+		{nbSamples: 1536, pts: 32},
+		{nbSamples: 1536, pts: 64},
+		{nbSamples: 1536, pts: 96},
+		{nbSamples: 100, pts: 128},
+	} {
+		ctx.FrameSize = v.nbSamples
+		p.setBufferCtx(ctx)
+		f, err := p.getWithBuffer()
+		require.NoError(t, err)
+		f.SetPts(v.pts)
+		a.HandleFrame(FrameHandlerPayload{
+			Descriptor: testDescriptor{timeBase: ctx.TimeBase},
+			Frame:      f,
+		})
+		p.put(f)
+	}
+	require.NoError(t, a.Drain(context.Background()))
+
+	// Remaining samples are flushed when stopping
+	a.Stop()
+	for a.Status() != astiencoder.StatusStopped {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Pts are derived from the number of samples output since the fifo was last empty
+	assert.Equal(t, []int{1024, 1024, 1024, 1024, 1024, 1024, 1024}, nbSamples)
+	assert.Equal(t, []int64{0, 21, 43, 64, 85, 107, 128}, ptss)
+}
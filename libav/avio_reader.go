@@ -0,0 +1,160 @@
+package astilibav
+
+import (
+	"io"
+	"sync"
+	"syscall"
+
+	"github.com/asticode/goav/avformat"
+	"github.com/asticode/goav/avutil"
+)
+
+// C can't store Go pointers, therefore readers are indexed by an id stored in C memory
+var avIOReaders = &avIOReaderRegistry{
+	m:  &sync.Mutex{},
+	rs: make(map[int]*avIOReaderItem),
+}
+
+type avIOReaderRegistry struct {
+	id int
+	m  *sync.Mutex // Locks id and rs
+	rs map[int]*avIOReaderItem
+}
+
+type avIOReaderItem struct {
+	interruptRet *int
+	r            io.Reader
+}
+
+func (r *avIOReaderRegistry) add(i *avIOReaderItem) int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.id++
+	r.rs[r.id] = i
+	return r.id
+}
+
+func (r *avIOReaderRegistry) del(id int) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	delete(r.rs, id)
+}
+
+func (r *avIOReaderRegistry) get(id int) (i *avIOReaderItem, ok bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	i, ok = r.rs[id]
+	return
+}
+
+// avIOReaderRead is called by the avio context of the reader with the id
+func avIOReaderRead(id int, b []byte) int {
+	// Get reader
+	i, ok := avIOReaders.get(id)
+	if !ok {
+		return -int(syscall.EIO)
+	}
+
+	// Loop since readers are allowed to return 0 bytes without error, which libav would interpret as EOF
+	for {
+		// Input has been interrupted
+		if i.interruptRet != nil && *i.interruptRet != 0 {
+			return avErrorExit
+		}
+
+		// Read
+		n, err := i.r.Read(b)
+		if n > 0 {
+			return n
+		}
+		if err == io.EOF {
+			return avutil.AVERROR_EOF
+		} else if err != nil {
+			return -int(syscall.EIO)
+		}
+	}
+}
+
+// avIOReaderSeek is called by the avio context of the reader with the id
+func avIOReaderSeek(id int, offset int64, whence int) int64 {
+	// Get seeker
+	i, ok := avIOReaders.get(id)
+	if !ok {
+		return -int64(syscall.EIO)
+	}
+	s, ok := i.r.(io.Seeker)
+	if !ok {
+		return -int64(syscall.ESPIPE)
+	}
+	return avIOSeek(s, offset, whence)
+}
+
+// avIOSeek implements avio seek callbacks on top of an io.Seeker
+func avIOSeek(s io.Seeker, offset int64, whence int) int64 {
+	// Size has been requested
+	if whence&avSeekSize > 0 {
+		cur, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -int64(syscall.EIO)
+		}
+		size, err := s.Seek(0, io.SeekEnd)
+		if err != nil {
+			return -int64(syscall.EIO)
+		}
+		if _, err = s.Seek(cur, io.SeekStart); err != nil {
+			return -int64(syscall.EIO)
+		}
+		return size
+	}
+
+	// Seek
+	var w int
+	switch whence &^ avSeekForce {
+	case seekCur:
+		w = io.SeekCurrent
+	case seekEnd:
+		w = io.SeekEnd
+	default:
+		w = io.SeekStart
+	}
+	p, err := s.Seek(offset, w)
+	if err != nil {
+		return -int64(syscall.EIO)
+	}
+	return p
+}
+
+// avIOReader is an avio context reading from an io.Reader
+// The input is seekable only if the reader implements io.Seeker
+type avIOReader struct {
+	ctx *avformat.AvIOContext
+	id  int
+}
+
+func newAvIOReader(r io.Reader, bufferSize int, interruptRet *int) (a *avIOReader, err error) {
+	// Create avio reader
+	a = &avIOReader{id: avIOReaders.add(&avIOReaderItem{
+		interruptRet: interruptRet,
+		r:            r,
+	})}
+
+	// Alloc context
+	_, seekable := r.(io.Seeker)
+	if a.ctx, err = avIOAllocContext(a.id, bufferSize, false, seekable); err != nil {
+		a.close()
+		return
+	}
+	return
+}
+
+func (a *avIOReader) avIOContext() *avformat.AvIOContext {
+	return a.ctx
+}
+
+func (a *avIOReader) close() {
+	// Free context
+	avIOFreeContext(&a.ctx)
+
+	// Delete reader
+	avIOReaders.del(a.id)
+}
@@ -0,0 +1,168 @@
+package astilibav
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/asticode/goav/avformat"
+	"github.com/asticode/goav/avutil"
+)
+
+// Output formats seeking back into the output to write their index, unless they're fragmented
+var avIOSeekingFormatNames = map[string]bool{
+	"3g2":  true,
+	"3gp":  true,
+	"ipod": true,
+	"mov":  true,
+	"mp4":  true,
+	"psp":  true,
+}
+
+// C can't store Go pointers, therefore writers are indexed by an id stored in C memory
+var avIOWriters = &avIOWriterRegistry{
+	m:  &sync.Mutex{},
+	ws: make(map[int]io.Writer),
+}
+
+type avIOWriterRegistry struct {
+	id int
+	m  *sync.Mutex // Locks id and ws
+	ws map[int]io.Writer
+}
+
+func (r *avIOWriterRegistry) add(w io.Writer) int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.id++
+	r.ws[r.id] = w
+	return r.id
+}
+
+func (r *avIOWriterRegistry) del(id int) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	delete(r.ws, id)
+}
+
+func (r *avIOWriterRegistry) get(id int) (w io.Writer, ok bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	w, ok = r.ws[id]
+	return
+}
+
+// avIOWriterWrite is called by the avio context of the writer with the id
+func avIOWriterWrite(id int, b []byte) int {
+	// Get writer
+	w, ok := avIOWriters.get(id)
+	if !ok {
+		return -int(syscall.EIO)
+	}
+
+	// Write
+	if _, err := w.Write(b); err != nil {
+		return -int(syscall.EIO)
+	}
+	return len(b)
+}
+
+// avIOWriterSeek is called by the avio context of the writer with the id
+func avIOWriterSeek(id int, offset int64, whence int) int64 {
+	// Get seeker
+	w, ok := avIOWriters.get(id)
+	if !ok {
+		return -int64(syscall.EIO)
+	}
+	s, ok := w.(io.Seeker)
+	if !ok {
+		return -int64(syscall.ESPIPE)
+	}
+	return avIOSeek(s, offset, whence)
+}
+
+// avIOWriterRequiresSeek checks whether the output format needs to seek into the output given the muxer dict
+func avIOWriterRequiresSeek(ctxFormat *avformat.Context, d *Dict) (bool, error) {
+	// Check format name
+	var found bool
+	for _, n := range strings.Split(outputFormatName(ctxFormat), ",") {
+		if avIOSeekingFormatNames[n] {
+			found = true
+			break
+		}
+	}
+	if !found || d == nil {
+		return found, nil
+	}
+
+	// Parse dict
+	var dict *avutil.Dictionary
+	defer avutil.AvDictFree(&dict)
+	if err := d.Parse(&dict); err != nil {
+		return false, fmt.Errorf("astilibav: parsing dict failed: %w", err)
+	}
+
+	// Fragmented outputs don't seek
+	return !strings.Contains(dictionaryToMap(dict)["movflags"], "frag_"), nil
+}
+
+// avIOWriter is an avio context writing into an io.Writer
+// The output is seekable only if the writer implements io.Seeker
+type avIOWriter struct {
+	ctx *avformat.AvIOContext
+	id  int
+	w   io.Writer
+}
+
+func newAvIOWriter(w io.Writer, bufferSize int) (a *avIOWriter, err error) {
+	// Create avio writer
+	a = &avIOWriter{
+		id: avIOWriters.add(w),
+		w:  w,
+	}
+
+	// Alloc context
+	_, seekable := w.(io.Seeker)
+	if a.ctx, err = avIOAllocContext(a.id, bufferSize, true, seekable); err != nil {
+		a.close()
+		return
+	}
+	return
+}
+
+func (a *avIOWriter) avIOContext() *avformat.AvIOContext {
+	return a.ctx
+}
+
+// flush writes the avio buffer into the writer and flushes the writer if it's buffered (e.g. a *bufio.Writer
+// or an http.ResponseWriter)
+func (a *avIOWriter) flush() error {
+	// Flush avio
+	if ret := avIOFlush(a.ctx); ret < 0 {
+		return fmt.Errorf("astilibav: avio_flush failed: %w", NewAvError(ret))
+	}
+
+	// Flush writer
+	switch f := a.w.(type) {
+	case interface{ Flush() error }:
+		if err := f.Flush(); err != nil {
+			return fmt.Errorf("astilibav: flushing writer failed: %w", err)
+		}
+	case interface{ Flush() }:
+		f.Flush()
+	}
+	return nil
+}
+
+func (a *avIOWriter) close() {
+	// Free context
+	if a.ctx != nil {
+		avIOFlush(a.ctx)
+		avIOFreeContext(&a.ctx)
+	}
+
+	// Delete writer
+	avIOWriters.del(a.id)
+}
@@ -0,0 +1,130 @@
+package astilibav
+
+import (
+	"fmt"
+
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+)
+
+// bitstreamFilter applies a bitstream filter chain to pkts. It's used by the bitstream filterer and by muxer
+// pkt handlers
+type bitstreamFilter struct {
+	ctx *bsfContext
+	p   *pktPool
+}
+
+type bitstreamFilterOptions struct {
+	// If provided, they're copied as input codec parameters. Otherwise input codec parameters are created
+	// from InputCtx
+	CodecParams *avcodec.CodecParameters
+	// Filter chain as you would use in ffmpeg (e.g. "h264_mp4toannexb" or "h264_metadata=level=4.1,dump_extra")
+	Filters  string
+	InputCtx Context
+	// Time base of incoming pkts
+	TimeBase avutil.Rational
+}
+
+func newBitstreamFilter(o bitstreamFilterOptions, p *pktPool, c *astikit.Closer) (b *bitstreamFilter, err error) {
+	// Check time base
+	if o.TimeBase.Num() <= 0 || o.TimeBase.Den() <= 0 {
+		err = fmt.Errorf("astilibav: invalid time base %s", o.TimeBase)
+		return
+	}
+
+	// Create bsf
+	b = &bitstreamFilter{p: p}
+
+	// Parse filters
+	if ret := bsfListParseStr(o.Filters, &b.ctx); ret < 0 {
+		err = fmt.Errorf("astilibav: av_bsf_list_parse_str on %s failed: %w", o.Filters, NewAvError(ret))
+		return
+	}
+
+	// Make sure the context is freed
+	c.Add(func() error {
+		bsfFree(&b.ctx)
+		return nil
+	})
+
+	// Set input codec parameters
+	if o.CodecParams != nil {
+		if ret := avcodecParametersCopy(b.ctx.parIn(), o.CodecParams); ret < 0 {
+			err = fmt.Errorf("astilibav: avcodec_parameters_copy failed: %w", NewAvError(ret))
+			return
+		}
+	} else {
+		setCodecParametersFromContext(b.ctx.parIn(), o.InputCtx)
+	}
+
+	// Set input time base
+	b.ctx.setTimeBaseIn(o.TimeBase)
+
+	// Init
+	if ret := b.ctx.init(); ret < 0 {
+		err = fmt.Errorf("astilibav: av_bsf_init failed: %w", NewAvError(ret))
+		return
+	}
+	return
+}
+
+// outputTimeBase returns the time base of filtered pkts
+func (b *bitstreamFilter) outputTimeBase() avutil.Rational {
+	return b.ctx.timeBaseOut()
+}
+
+// copyOutputCodecParameters copies the codec parameters of filtered pkts, which may differ from the input ones
+// when filters rewrite extradata
+func (b *bitstreamFilter) copyOutputCodecParameters(cp *avcodec.CodecParameters) error {
+	if ret := avcodecParametersCopy(cp, b.ctx.parOut()); ret < 0 {
+		return fmt.Errorf("astilibav: avcodec_parameters_copy failed: %w", NewAvError(ret))
+	}
+	cp.SetCodecTag(0)
+	return nil
+}
+
+// filter sends a pkt in inTimeBase to the filters and executes fn on each output pkt, rescaled to outTimeBase.
+// A nil pkt flushes the filters
+func (b *bitstreamFilter) filter(pkt *avcodec.Packet, inTimeBase, outTimeBase avutil.Rational, fn func(pkt *avcodec.Packet)) error {
+	// Rescale timestamps
+	if pkt != nil {
+		pkt.AvPacketRescaleTs(inTimeBase, b.ctx.timeBaseIn())
+	}
+
+	// Send pkt
+	if ret := b.ctx.sendPacket(pkt); ret < 0 {
+		return fmt.Errorf("astilibav: av_bsf_send_packet failed: %w", NewAvError(ret))
+	}
+
+	// One input pkt may produce zero or several output pkts
+	for {
+		if stop, err := b.receivePkt(outTimeBase, fn); err != nil {
+			return err
+		} else if stop {
+			return nil
+		}
+	}
+}
+
+func (b *bitstreamFilter) receivePkt(timeBase avutil.Rational, fn func(pkt *avcodec.Packet)) (stop bool, err error) {
+	// Get pkt from pool
+	pkt := b.p.get()
+	defer b.p.put(pkt)
+
+	// Receive pkt
+	if ret := b.ctx.receivePacket(pkt); ret < 0 {
+		if ret != avutil.AVERROR_EOF && ret != avutil.AVERROR_EAGAIN {
+			err = fmt.Errorf("astilibav: av_bsf_receive_packet failed: %w", NewAvError(ret))
+		}
+		stop = true
+		return
+	}
+
+	// Rescale timestamps
+	pkt.AvPacketRescaleTs(b.ctx.timeBaseOut(), timeBase)
+
+	// Execute func
+	fn(pkt)
+	return
+}
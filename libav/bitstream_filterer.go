@@ -0,0 +1,214 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avformat"
+	"github.com/asticode/goav/avutil"
+)
+
+var countBitstreamFilterer uint64
+
+// BitstreamFilterer represents an object capable of applying a bitstream filter (e.g. h264_mp4toannexb)
+// to packets
+type BitstreamFilterer struct {
+	*astiencoder.BaseNode
+	bsf               *bitstreamFilter
+	c                 *astikit.Chan
+	d                 *pktDispatcher
+	eh                *astiencoder.EventHandler
+	p                 *pktPool
+	statIncomingRate  *astikit.CounterRateStat
+	statProcessedRate *astikit.CounterRateStat
+}
+
+// BitstreamFiltererOptions represents bitstream filterer options
+type BitstreamFiltererOptions struct {
+	// If provided, they're copied as input codec parameters of the filter which is required by filters relying
+	// on extradata. Otherwise input codec parameters are created from InputCtx
+	CodecParams *avcodec.CodecParameters
+	InputCtx    Context
+	// Bitstream filter chain as you would use in ffmpeg (e.g. "h264_mp4toannexb")
+	Name string
+	Node astiencoder.NodeOptions
+}
+
+// NewBitstreamFilterer creates a new bitstream filterer
+func NewBitstreamFilterer(o BitstreamFiltererOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (f *BitstreamFilterer, err error) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countBitstreamFilterer, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("bitstream_filterer_%d", count), fmt.Sprintf("Bitstream Filterer #%d", count), fmt.Sprintf("Applies %s", o.Name), "bitstream filterer")
+
+	// Create bitstream filterer
+	f = &BitstreamFilterer{
+		c:                 astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
+		eh:                eh,
+		p:                 newPktPool(c),
+		statIncomingRate:  astikit.NewCounterRateStat(),
+		statProcessedRate: astikit.NewCounterRateStat(),
+	}
+
+	// Create base node
+	f.BaseNode = astiencoder.NewBaseNode(o.Node, eh, s, f, astiencoder.EventTypeToNodeEventName)
+
+	// Create pkt dispatcher
+	f.d = newPktDispatcher(f, eh, f.p)
+
+	// Add stats
+	f.addStats()
+
+	// Create bitstream filter
+	if f.bsf, err = newBitstreamFilter(bitstreamFilterOptions{
+		CodecParams: o.CodecParams,
+		Filters:     o.Name,
+		InputCtx:    o.InputCtx,
+		TimeBase:    o.InputCtx.TimeBase,
+	}, f.p, c); err != nil {
+		err = fmt.Errorf("astilibav: creating bitstream filter failed: %w", err)
+		return
+	}
+	return
+}
+
+func (f *BitstreamFilterer) addStats() {
+	// Get stats
+	ss := f.c.Stats()
+	ss = append(ss, f.d.stats()...)
+	ss = append(ss,
+		astikit.StatOptions{
+			Handler: f.statIncomingRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of packets coming in per second",
+				Label:       "Incoming rate",
+				Name:        StatNameIncomingRate,
+				Unit:        "pps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: f.statProcessedRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of packets processed per second",
+				Label:       "Processed rate",
+				Name:        StatNameProcessedRate,
+				Unit:        "pps",
+			},
+		},
+	)
+
+	// Add stats
+	f.BaseNode.AddStats(ss...)
+}
+
+// OutputTimeBase returns the time base of filtered packets
+func (f *BitstreamFilterer) OutputTimeBase() avutil.Rational {
+	return f.bsf.outputTimeBase()
+}
+
+// AddStream adds a stream to the format ctx with the codec parameters and time base of filtered packets
+func (f *BitstreamFilterer) AddStream(ctxFormat *avformat.Context) (o *avformat.Stream, err error) {
+	// Add stream
+	o = AddStream(ctxFormat)
+
+	// Set codec parameters
+	if err = f.bsf.copyOutputCodecParameters(o.CodecParameters()); err != nil {
+		err = fmt.Errorf("astilibav: copying output codec parameters failed: %w", err)
+		return
+	}
+
+	// Set time base
+	o.SetTimeBase(f.OutputTimeBase())
+	return
+}
+
+// Connect implements the PktHandlerConnector interface
+func (f *BitstreamFilterer) Connect(h PktHandler) {
+	// Add handler
+	f.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(f, h)
+}
+
+// Disconnect implements the PktHandlerConnector interface
+func (f *BitstreamFilterer) Disconnect(h PktHandler) {
+	// Delete handler
+	f.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(f, h)
+}
+
+// Drain implements the Drainer interface
+func (f *BitstreamFilterer) Drain(ctx context.Context) error {
+	return drainChan(ctx, f.BaseNode, f.c)
+}
+
+// Start starts the bitstream filterer
+func (f *BitstreamFilterer) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	f.BaseNode.Start(ctx, t, func(t *astikit.Task) {
+		// Make sure to flush the filter
+		defer f.flush()
+
+		// Make sure to stop the chan properly
+		defer f.c.Stop()
+
+		// Start chan
+		f.c.Start(f.Context())
+	})
+}
+
+func (f *BitstreamFilterer) flush() {
+	// A nil pkt signals the end of the stream
+	f.filter(nil, f.OutputTimeBase())
+}
+
+// HandlePkt implements the PktHandler interface
+func (f *BitstreamFilterer) HandlePkt(p PktHandlerPayload) {
+	// Increment incoming rate
+	f.statIncomingRate.Add(1)
+
+	// Copy pkt
+	pkt := f.p.get()
+	if ret := pkt.AvPacketRef(p.Pkt); ret < 0 {
+		f.p.put(pkt)
+		emitAvError(f, f.eh, ret, "AvPacketRef failed")
+		return
+	}
+
+	// Add to chan
+	f.c.Add(func() {
+		// Handle pause
+		defer f.HandlePause()
+
+		// Make sure to close pkt
+		defer f.p.put(pkt)
+
+		// Increment processed rate
+		f.statProcessedRate.Add(1)
+
+		// Filter
+		f.filter(pkt, p.Descriptor.TimeBase())
+	})
+}
+
+func (f *BitstreamFilterer) filter(pkt *avcodec.Packet, timeBase avutil.Rational) {
+	// Filtered pkts are dispatched in the output time base
+	d := bitstreamFiltererDescriptor{timeBase: f.OutputTimeBase()}
+	if err := f.bsf.filter(pkt, timeBase, d.timeBase, func(pkt *avcodec.Packet) { f.d.dispatch(pkt, d) }); err != nil {
+		f.eh.Emit(astiencoder.EventError(f, fmt.Errorf("astilibav: filtering failed: %w", err)))
+	}
+}
+
+type bitstreamFiltererDescriptor struct {
+	timeBase avutil.Rational
+}
+
+// TimeBase implements the Descriptor interface
+func (d bitstreamFiltererDescriptor) TimeBase() avutil.Rational {
+	return d.timeBase
+}
@@ -0,0 +1,66 @@
+package astilibav
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitstreamFilterer(t *testing.T) {
+	// Create demuxer
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	d, err := NewDemuxer(DemuxerOptions{URL: "../examples/sample.mp4"}, eh, c, nil)
+	require.NoError(t, err)
+	ss := d.StreamsByCodecType(avutil.AVMEDIA_TYPE_VIDEO)
+	require.Len(t, ss, 1)
+	s := ss[0]
+
+	// Invalid filter
+	_, err = NewBitstreamFilterer(BitstreamFiltererOptions{
+		CodecParams: s.CodecParameters(),
+		InputCtx:    NewContextFromStream(s),
+		Name:        "invalid",
+	}, eh, c, nil)
+	assert.Error(t, err)
+
+	// Create bitstream filterer
+	f, err := NewBitstreamFilterer(BitstreamFiltererOptions{
+		CodecParams: s.CodecParameters(),
+		InputCtx:    NewContextFromStream(s),
+		Name:        "h264_mp4toannexb",
+	}, eh, c, nil)
+	require.NoError(t, err)
+	assert.Equal(t, s.TimeBase(), f.OutputTimeBase())
+
+	// Get first video pkt
+	pkt := avcodec.AvPacketAlloc()
+	defer avcodec.AvPacketFree(pkt)
+	for {
+		require.GreaterOrEqual(t, d.CtxFormat().AvReadFrame(pkt), 0)
+		if pkt.StreamIndex() == s.Index() {
+			break
+		}
+		pkt.AvPacketUnref()
+	}
+	pts := pkt.Pts()
+
+	// Filter
+	var ps [][]byte
+	var ptss []int64
+	require.NoError(t, f.bsf.filter(pkt, s.TimeBase(), f.OutputTimeBase(), func(pkt *avcodec.Packet) {
+		ps = append(ps, append([]byte{}, cBytes(unsafe.Pointer(pkt.Data()), pkt.Size())...))
+		ptss = append(ptss, pkt.Pts())
+	}))
+	require.Len(t, ps, 1)
+	assert.True(t, bytes.HasPrefix(ps[0], []byte{0, 0, 0, 1}))
+	assert.Equal(t, []int64{pts}, ptss)
+}
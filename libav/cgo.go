@@ -0,0 +1,668 @@
+package astilibav
+
+// This file gathers every direct call to libav's C API that goav doesn't expose, so that nodes only
+// manipulate goav types
+
+//#cgo pkg-config: libavcodec libavformat libavutil
+//#include <stdint.h>
+//#include <stdio.h>
+//#include <stdlib.h>
+//#include <libavcodec/avcodec.h>
+//#include <libavformat/avformat.h>
+//#include <libavformat/avio.h>
+//#include <libavutil/audio_fifo.h>
+//#include <libavutil/dict.h>
+//#include <libavutil/display.h>
+//#include <libavutil/error.h>
+//#include <libavutil/frame.h>
+//#include <libavutil/log.h>
+//#include <libavutil/mastering_display_metadata.h>
+//#include <libavutil/mem.h>
+//#include <libavutil/pixdesc.h>
+//#include <libavutil/samplefmt.h>
+//extern int goAstilibavAvIORead(void* opaque, uint8_t* buf, int buf_size);
+//extern int64_t goAstilibavAvIOSeek(void* opaque, int64_t offset, int whence);
+//extern int goAstilibavAvIOWrite(void* opaque, uint8_t* buf, int buf_size);
+//extern int64_t goAstilibavAvIOWriteSeek(void* opaque, int64_t offset, int whence);
+import "C"
+import (
+	"errors"
+	"unsafe"
+
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avformat"
+	"github.com/asticode/goav/avutil"
+)
+
+// libav constants goav doesn't expose
+const (
+	avCodecCapDelay             = C.AV_CODEC_CAP_DELAY
+	avCodecCapExperimental      = C.AV_CODEC_CAP_EXPERIMENTAL
+	avCodecCapFrameThreads      = C.AV_CODEC_CAP_FRAME_THREADS
+	avCodecCapHardware          = C.AV_CODEC_CAP_HARDWARE
+	avCodecCapSliceThreads      = C.AV_CODEC_CAP_SLICE_THREADS
+	avCodecCapVariableFrameSize = C.AV_CODEC_CAP_VARIABLE_FRAME_SIZE
+	avCodecFlagLowDelay         = C.AV_CODEC_FLAG_LOW_DELAY
+	avDictIgnoreSuffix          = C.AV_DICT_IGNORE_SUFFIX
+	avDispositionDefault        = C.AV_DISPOSITION_DEFAULT
+	avErrorExit                 = C.AVERROR_EXIT
+	avFmtFlagBitexact           = C.AVFMT_FLAG_BITEXACT
+	avFmtFlagFlushPackets       = C.AVFMT_FLAG_FLUSH_PACKETS
+	avLogTrace                  = C.AV_LOG_TRACE
+	avPixFmtFlagBE              = C.AV_PIX_FMT_FLAG_BE
+	avPixFmtFlagBitstream       = C.AV_PIX_FMT_FLAG_BITSTREAM
+	avPixFmtFlagHWAccel         = C.AV_PIX_FMT_FLAG_HWACCEL
+	avPixFmtFlagPal             = C.AV_PIX_FMT_FLAG_PAL
+	avPixFmtFlagPlanar          = C.AV_PIX_FMT_FLAG_PLANAR
+	avPixFmtFlagRGB             = C.AV_PIX_FMT_FLAG_RGB
+	avPktDataQualityStats       = C.AV_PKT_DATA_QUALITY_STATS
+	avSeekForce                 = C.AVSEEK_FORCE
+	avSeekSize                  = C.AVSEEK_SIZE
+	ffQP2Lambda                 = C.FF_QP2LAMBDA
+	seekCur                     = C.SEEK_CUR
+	seekEnd                     = C.SEEK_END
+)
+
+// cBytes returns a slice backed by C memory. It must not be used once the C memory has been freed
+func cBytes(p unsafe.Pointer, size int) []byte {
+	if p == nil || size <= 0 {
+		return nil
+	}
+	return (*[1 << 30]byte)(p)[:size:size]
+}
+
+func newRationalFromC(r C.AVRational) avutil.Rational {
+	return avutil.NewRational(int(r.num), int(r.den))
+}
+
+func newCRational(r avutil.Rational) C.AVRational {
+	return C.AVRational{
+		den: C.int(r.Den()),
+		num: C.int(r.Num()),
+	}
+}
+
+// Dictionaries
+
+func frameMetadataDict(f *avutil.Frame) **avutil.Dictionary {
+	return (**avutil.Dictionary)(unsafe.Pointer(&(*C.struct_AVFrame)(unsafe.Pointer(f)).metadata))
+}
+
+func streamMetadataDict(s *avformat.Stream) **avutil.Dictionary {
+	return (**avutil.Dictionary)(unsafe.Pointer(&(*C.struct_AVStream)(unsafe.Pointer(s)).metadata))
+}
+
+func formatMetadataDict(ctxFormat *avformat.Context) **avutil.Dictionary {
+	return (**avutil.Dictionary)(unsafe.Pointer(&(*C.struct_AVFormatContext)(unsafe.Pointer(ctxFormat)).metadata))
+}
+
+// Streams
+
+func setStreamDiscard(s *avformat.Stream, d Discard) {
+	(*C.struct_AVStream)(unsafe.Pointer(s)).discard = C.enum_AVDiscard(d)
+}
+
+func setStreamDisposition(s *avformat.Stream, d int) {
+	(*C.struct_AVStream)(unsafe.Pointer(s)).disposition = C.int(d)
+}
+
+// streamSideData returns the side data of the stream with the type or nil if there's none
+func streamSideData(s *avformat.Stream, t avcodec.AvPacketSideDataType) unsafe.Pointer {
+	return unsafe.Pointer(C.av_stream_get_side_data((*C.struct_AVStream)(unsafe.Pointer(s)), C.enum_AVPacketSideDataType(t), nil))
+}
+
+// streamNewSideData allocates side data of the stream with the type or returns nil if it failed
+func streamNewSideData(s *avformat.Stream, t avcodec.AvPacketSideDataType, size int) unsafe.Pointer {
+	return unsafe.Pointer(C.av_stream_new_side_data((*C.struct_AVStream)(unsafe.Pointer(s)), C.enum_AVPacketSideDataType(t), C.int(size)))
+}
+
+func displayRotationGet(matrix unsafe.Pointer) float64 {
+	return float64(C.av_display_rotation_get((*C.int32_t)(matrix)))
+}
+
+func displayRotationSet(matrix unsafe.Pointer, rotation float64) {
+	C.av_display_rotation_set((*C.int32_t)(matrix), C.double(rotation))
+}
+
+// Formats
+
+func setFormatContextFlags(ctxFormat *avformat.Context, flags int) {
+	(*C.struct_AVFormatContext)(unsafe.Pointer(ctxFormat)).flags = C.int(flags)
+}
+
+func outputFormatName(ctxFormat *avformat.Context) string {
+	return C.GoString((*C.struct_AVFormatContext)(unsafe.Pointer(ctxFormat)).oformat.name)
+}
+
+// Codecs
+
+func codecName(cdc *avcodec.Codec) string {
+	return C.GoString((*C.struct_AVCodec)(unsafe.Pointer(cdc)).name)
+}
+
+func codecID(cdc *avcodec.Codec) avcodec.CodecId {
+	return avcodec.CodecId((*C.struct_AVCodec)(unsafe.Pointer(cdc)).id)
+}
+
+func newCodecCapabilities(cdc *avcodec.Codec) (cc CodecCapabilities) {
+	// Get C codec
+	c := (*C.struct_AVCodec)(unsafe.Pointer(cdc))
+
+	// Create capabilities
+	cc = CodecCapabilities{
+		Flags:     int(c.capabilities),
+		ID:        avcodec.CodecId(c.id),
+		MediaType: avcodec.MediaType(c._type),
+		Name:      C.GoString(c.name),
+	}
+	if c.long_name != nil {
+		cc.LongName = C.GoString(c.long_name)
+	}
+
+	// Lists are terminated by a sentinel value
+	for p := c.channel_layouts; p != nil && *p != 0; p = (*C.uint64_t)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + unsafe.Sizeof(*p))) {
+		cc.ChannelLayouts = append(cc.ChannelLayouts, uint64(*p))
+	}
+	for p := c.supported_framerates; p != nil && (p.num != 0 || p.den != 0); p = (*C.AVRational)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + unsafe.Sizeof(*p))) {
+		cc.FrameRates = append(cc.FrameRates, avutil.NewRational(int(p.num), int(p.den)))
+	}
+	for p := c.pix_fmts; p != nil && *p != C.AV_PIX_FMT_NONE; p = (*C.enum_AVPixelFormat)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + unsafe.Sizeof(*p))) {
+		cc.PixelFormats = append(cc.PixelFormats, avutil.PixelFormat(*p))
+	}
+	for p := c.sample_fmts; p != nil && *p != C.AV_SAMPLE_FMT_NONE; p = (*C.enum_AVSampleFormat)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + unsafe.Sizeof(*p))) {
+		cc.SampleFmts = append(cc.SampleFmts, avcodec.AvSampleFormat(*p))
+	}
+	for p := c.supported_samplerates; p != nil && *p != 0; p = (*C.int)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + unsafe.Sizeof(*p))) {
+		cc.SampleRates = append(cc.SampleRates, int(*p))
+	}
+	return
+}
+
+func setCodecContextRefs(ctxCodec *avcodec.Context, refs int) {
+	(*C.struct_AVCodecContext)(unsafe.Pointer(ctxCodec)).refs = C.int(refs)
+}
+
+func setCodecContextColorProperties(ctxCodec *avcodec.Context, p ColorProperties) {
+	c := (*C.struct_AVCodecContext)(unsafe.Pointer(ctxCodec))
+	c.color_primaries = C.enum_AVColorPrimaries(p.Primaries)
+	c.color_range = C.enum_AVColorRange(p.Range)
+	c.colorspace = C.enum_AVColorSpace(p.Space)
+	c.color_trc = C.enum_AVColorTransferCharacteristic(p.TRC)
+}
+
+// codecParametersColorProperties returns the color properties and whether at least one of them is specified
+func codecParametersColorProperties(cp *avcodec.CodecParameters) (p ColorProperties, specified bool) {
+	c := (*C.struct_AVCodecParameters)(unsafe.Pointer(cp))
+	p = ColorProperties{
+		Primaries: avcodec.AvColorPrimaries(c.color_primaries),
+		Range:     avcodec.AvColorRange(c.color_range),
+		Space:     avcodec.AvColorSpace(c.color_space),
+		TRC:       avcodec.AvColorTransferCharacteristic(c.color_trc),
+	}
+	specified = c.color_primaries != C.AVCOL_PRI_UNSPECIFIED || c.color_range != C.AVCOL_RANGE_UNSPECIFIED ||
+		c.color_space != C.AVCOL_SPC_UNSPECIFIED || c.color_trc != C.AVCOL_TRC_UNSPECIFIED
+	return
+}
+
+func setCodecParametersFromContext(cp *avcodec.CodecParameters, ctx Context) {
+	c := (*C.struct_AVCodecParameters)(unsafe.Pointer(cp))
+	c.codec_id = C.enum_AVCodecID(ctx.CodecID)
+	c.codec_type = C.enum_AVMediaType(ctx.CodecType)
+	c.bit_rate = C.int64_t(ctx.BitRate)
+	switch ctx.CodecType {
+	case avutil.AVMEDIA_TYPE_AUDIO:
+		c.channel_layout = C.uint64_t(ctx.ChannelLayout)
+		c.channels = C.int(ctx.Channels)
+		c.format = C.int(ctx.SampleFmt)
+		c.frame_size = C.int(ctx.FrameSize)
+		c.sample_rate = C.int(ctx.SampleRate)
+	case avutil.AVMEDIA_TYPE_VIDEO:
+		c.format = C.int(ctx.PixelFormat)
+		c.height = C.int(ctx.Height)
+		c.sample_aspect_ratio = newCRational(ctx.SampleAspectRatio)
+		c.width = C.int(ctx.Width)
+	}
+}
+
+func setCodecParametersCodec(cp *avcodec.CodecParameters, t avcodec.MediaType, id avcodec.CodecId) {
+	c := (*C.struct_AVCodecParameters)(unsafe.Pointer(cp))
+	c.codec_type = C.enum_AVMediaType(t)
+	c.codec_id = C.enum_AVCodecID(id)
+}
+
+func codecParametersExtradataSize(cp *avcodec.CodecParameters) int {
+	return int((*C.struct_AVCodecParameters)(unsafe.Pointer(cp)).extradata_size)
+}
+
+// setCodecParametersExtradata copies b into padded extradata owned by the codec parameters
+func setCodecParametersExtradata(cp *avcodec.CodecParameters, b []byte) error {
+	c := (*C.struct_AVCodecParameters)(unsafe.Pointer(cp))
+	if c.extradata = (*C.uint8_t)(C.av_mallocz(C.size_t(len(b) + C.AV_INPUT_BUFFER_PADDING_SIZE))); c.extradata == nil {
+		return errors.New("astilibav: allocating extradata failed")
+	}
+	copy(cBytes(unsafe.Pointer(c.extradata), len(b)), b)
+	c.extradata_size = C.int(len(b))
+	return nil
+}
+
+func avcodecParametersCopy(dst, src *avcodec.CodecParameters) int {
+	return int(C.avcodec_parameters_copy((*C.struct_AVCodecParameters)(unsafe.Pointer(dst)), (*C.struct_AVCodecParameters)(unsafe.Pointer(src))))
+}
+
+// Packets
+
+// packetSideData returns a copy of the side data of the pkt with the type or nil if there's none
+func packetSideData(pkt *avcodec.Packet, t avcodec.AvPacketSideDataType) []byte {
+	var size C.int
+	sd := C.av_packet_get_side_data((*C.AVPacket)(unsafe.Pointer(pkt)), C.enum_AVPacketSideDataType(t), &size)
+	if sd == nil {
+		return nil
+	}
+	return C.GoBytes(unsafe.Pointer(sd), size)
+}
+
+// Frames
+
+func frameKeyFrame(f *avutil.Frame) bool {
+	return (*C.struct_AVFrame)(unsafe.Pointer(f)).key_frame == 1
+}
+
+// frameRow returns size bytes of the plane of the frame starting at x:y
+func frameRow(f *avutil.Frame, plane, x, y, size int) []byte {
+	cf := (*C.struct_AVFrame)(unsafe.Pointer(f))
+	return cBytes(unsafe.Pointer(uintptr(unsafe.Pointer(cf.data[plane]))+uintptr(y*int(cf.linesize[plane])+x)), size)
+}
+
+func frameApplyCropping(f *avutil.Frame, top, bottom, left, right int) int {
+	cf := (*C.struct_AVFrame)(unsafe.Pointer(f))
+	cf.crop_top = C.size_t(top)
+	cf.crop_bottom = C.size_t(bottom)
+	cf.crop_left = C.size_t(left)
+	cf.crop_right = C.size_t(right)
+
+	// Rectangles are validated against chroma subsampling so we can crop unaligned
+	return int(C.av_frame_apply_cropping(cf, C.AV_FRAME_CROP_UNALIGNED))
+}
+
+func samplesSetSilence(f *avutil.Frame, offset, nbSamples, channels int, sampleFmt avcodec.AvSampleFormat) {
+	C.av_samples_set_silence((*C.struct_AVFrame)(unsafe.Pointer(f)).extended_data, C.int(offset), C.int(nbSamples), C.int(channels), C.enum_AVSampleFormat(sampleFmt))
+}
+
+// Pixel formats
+
+type pixFmtDescriptor struct {
+	comps       []pixFmtComponent
+	flags       uint64
+	log2ChromaH int
+	log2ChromaW int
+	name        string
+}
+
+type pixFmtComponent struct {
+	depth  int
+	offset int
+	plane  int
+	shift  int
+	step   int
+}
+
+// pixFmtDescGet returns the pixel format descriptor or nil if there's none
+func pixFmtDescGet(pixFmt avutil.PixelFormat) *pixFmtDescriptor {
+	// Get C descriptor
+	desc := C.av_pix_fmt_desc_get(C.enum_AVPixelFormat(pixFmt))
+	if desc == nil {
+		return nil
+	}
+
+	// Create descriptor
+	d := &pixFmtDescriptor{
+		flags:       uint64(desc.flags),
+		log2ChromaH: int(desc.log2_chroma_h),
+		log2ChromaW: int(desc.log2_chroma_w),
+		name:        C.GoString(desc.name),
+	}
+	for i := 0; i < int(desc.nb_components); i++ {
+		c := desc.comp[i]
+		d.comps = append(d.comps, pixFmtComponent{
+			depth:  int(c.depth),
+			offset: int(c.offset),
+			plane:  int(c.plane),
+			shift:  int(c.shift),
+			step:   int(c.step),
+		})
+	}
+	return d
+}
+
+// Audio fifos
+
+type audioFifo C.AVAudioFifo
+
+// newAudioFifo returns nil if the fifo couldn't be allocated
+func newAudioFifo(sampleFmt avcodec.AvSampleFormat, channels, nbSamples int) *audioFifo {
+	return (*audioFifo)(C.av_audio_fifo_alloc(C.enum_AVSampleFormat(sampleFmt), C.int(channels), C.int(nbSamples)))
+}
+
+func (a *audioFifo) free() {
+	C.av_audio_fifo_free((*C.AVAudioFifo)(a))
+}
+
+func (a *audioFifo) size() int {
+	return int(C.av_audio_fifo_size((*C.AVAudioFifo)(a)))
+}
+
+// write writes the samples of the frame and returns the number of samples written or a negative error.
+// extended_data is used since data only holds the first planes of planar audio with many channels
+func (a *audioFifo) write(f *avutil.Frame) int {
+	cf := (*C.struct_AVFrame)(unsafe.Pointer(f))
+	return int(C.av_audio_fifo_write((*C.AVAudioFifo)(a), (*unsafe.Pointer)(unsafe.Pointer(cf.extended_data)), cf.nb_samples))
+}
+
+// read reads up to nbSamples samples into the frame and returns the number of samples read or a negative error
+func (a *audioFifo) read(f *avutil.Frame, nbSamples int) int {
+	cf := (*C.struct_AVFrame)(unsafe.Pointer(f))
+	return int(C.av_audio_fifo_read((*C.AVAudioFifo)(a), (*unsafe.Pointer)(unsafe.Pointer(cf.extended_data)), C.int(nbSamples)))
+}
+
+// Bitstream filters
+
+type bsfContext C.AVBSFContext
+
+func bsfListParseStr(filters string, ctx **bsfContext) int {
+	cf := C.CString(filters)
+	defer C.free(unsafe.Pointer(cf))
+	return int(C.av_bsf_list_parse_str(cf, (**C.AVBSFContext)(unsafe.Pointer(ctx))))
+}
+
+func bsfFree(ctx **bsfContext) {
+	C.av_bsf_free((**C.AVBSFContext)(unsafe.Pointer(ctx)))
+}
+
+func (c *bsfContext) init() int {
+	return int(C.av_bsf_init((*C.AVBSFContext)(c)))
+}
+
+func (c *bsfContext) parIn() *avcodec.CodecParameters {
+	return (*avcodec.CodecParameters)(unsafe.Pointer(c.par_in))
+}
+
+func (c *bsfContext) parOut() *avcodec.CodecParameters {
+	return (*avcodec.CodecParameters)(unsafe.Pointer(c.par_out))
+}
+
+func (c *bsfContext) timeBaseIn() avutil.Rational {
+	return newRationalFromC(c.time_base_in)
+}
+
+func (c *bsfContext) setTimeBaseIn(r avutil.Rational) {
+	c.time_base_in = newCRational(r)
+}
+
+func (c *bsfContext) timeBaseOut() avutil.Rational {
+	return newRationalFromC(c.time_base_out)
+}
+
+// sendPacket sends the pkt to the filters. A nil pkt signals the end of the stream
+func (c *bsfContext) sendPacket(pkt *avcodec.Packet) int {
+	return int(C.av_bsf_send_packet((*C.AVBSFContext)(c), (*C.struct_AVPacket)(unsafe.Pointer(pkt))))
+}
+
+func (c *bsfContext) receivePacket(pkt *avcodec.Packet) int {
+	return int(C.av_bsf_receive_packet((*C.AVBSFContext)(c), (*C.struct_AVPacket)(unsafe.Pointer(pkt))))
+}
+
+// AVIO
+
+// avIOOpen2 is avformat.AvIOOpen with protocol options
+func avIOOpen2(pb **avformat.AvIOContext, url string, flags int, dict **avutil.Dictionary) int {
+	cu := C.CString(url)
+	defer C.free(unsafe.Pointer(cu))
+	return int(C.avio_open2((**C.AVIOContext)(unsafe.Pointer(pb)), cu, C.int(flags), nil, (**C.AVDictionary)(unsafe.Pointer(dict))))
+}
+
+// avIOPosition returns the current byte position of the format ctx avio or -1 if there's none
+func avIOPosition(ctxFormat *avformat.Context) int64 {
+	pb := ctxFormat.Pb()
+	if pb == nil {
+		return -1
+	}
+	return int64(C.avio_tell((*C.AVIOContext)(unsafe.Pointer(pb))))
+}
+
+// avIOSize returns the size of the format ctx avio or -1 if it's unknown
+func avIOSize(ctxFormat *avformat.Context) int64 {
+	pb := ctxFormat.Pb()
+	if pb == nil {
+		return -1
+	}
+	if s := int64(C.avio_size((*C.AVIOContext)(unsafe.Pointer(pb)))); s >= 0 {
+		return s
+	}
+	return -1
+}
+
+// avIOAllocContext allocates an avio context whose callbacks are forwarded to the avio reader or writer
+// registered with the id. C can't store Go pointers, therefore the id is stored in C memory
+func avIOAllocContext(id, bufferSize int, writable, seekable bool) (ctx *avformat.AvIOContext, err error) {
+	// Store id in C memory
+	opaque := (*C.int)(C.malloc(C.size_t(unsafe.Sizeof(C.int(0)))))
+	*opaque = C.int(id)
+
+	// Alloc buffer
+	buf := C.av_malloc(C.size_t(bufferSize))
+	if buf == nil {
+		C.free(unsafe.Pointer(opaque))
+		err = errors.New("astilibav: av_malloc failed")
+		return
+	}
+
+	// Get callbacks
+	var flag C.int
+	var read, seek, write *[0]byte
+	if writable {
+		flag = 1
+		write = (*[0]byte)(unsafe.Pointer(C.goAstilibavAvIOWrite))
+		if seekable {
+			seek = (*[0]byte)(unsafe.Pointer(C.goAstilibavAvIOWriteSeek))
+		}
+	} else {
+		read = (*[0]byte)(unsafe.Pointer(C.goAstilibavAvIORead))
+		if seekable {
+			seek = (*[0]byte)(unsafe.Pointer(C.goAstilibavAvIOSeek))
+		}
+	}
+
+	// Alloc context
+	c := C.avio_alloc_context((*C.uchar)(buf), C.int(bufferSize), flag, unsafe.Pointer(opaque), read, write, seek)
+	if c == nil {
+		C.av_free(buf)
+		C.free(unsafe.Pointer(opaque))
+		err = errors.New("astilibav: avio_alloc_context failed")
+		return
+	}
+	ctx = (*avformat.AvIOContext)(unsafe.Pointer(c))
+	return
+}
+
+// avIOFreeContext frees a context allocated with avIOAllocContext
+func avIOFreeContext(ctx **avformat.AvIOContext) {
+	// Nothing to free
+	c := (*C.AVIOContext)(unsafe.Pointer(*ctx))
+	if c == nil {
+		return
+	}
+
+	// The buffer may have been reallocated by libav and must be freed separately
+	opaque := c.opaque
+	C.av_freep(unsafe.Pointer(&c.buffer))
+	C.avio_context_free(&c)
+	C.free(opaque)
+	*ctx = nil
+}
+
+// avIOFlush flushes the avio buffer and returns the error of the context if any
+func avIOFlush(ctx *avformat.AvIOContext) int {
+	c := (*C.AVIOContext)(unsafe.Pointer(ctx))
+	C.avio_flush(c)
+	return int(c.error)
+}
+
+func avIOOpaqueID(opaque unsafe.Pointer) int {
+	return int(*(*C.int)(opaque))
+}
+
+//export goAstilibavAvIORead
+func goAstilibavAvIORead(opaque unsafe.Pointer, buf *C.uint8_t, size C.int) C.int {
+	return C.int(avIOReaderRead(avIOOpaqueID(opaque), cBytes(unsafe.Pointer(buf), int(size))))
+}
+
+//export goAstilibavAvIOSeek
+func goAstilibavAvIOSeek(opaque unsafe.Pointer, offset C.int64_t, whence C.int) C.int64_t {
+	return C.int64_t(avIOReaderSeek(avIOOpaqueID(opaque), int64(offset), int(whence)))
+}
+
+//export goAstilibavAvIOWrite
+func goAstilibavAvIOWrite(opaque unsafe.Pointer, buf *C.uint8_t, size C.int) C.int {
+	return C.int(avIOWriterWrite(avIOOpaqueID(opaque), C.GoBytes(unsafe.Pointer(buf), size)))
+}
+
+//export goAstilibavAvIOWriteSeek
+func goAstilibavAvIOWriteSeek(opaque unsafe.Pointer, offset C.int64_t, whence C.int) C.int64_t {
+	return C.int64_t(avIOWriterSeek(avIOOpaqueID(opaque), int64(offset), int(whence)))
+}
+
+// Logs
+
+func avLogGetLevel() int {
+	return int(C.av_log_get_level())
+}
+
+// HDR
+
+func frameHDRMetadata(f *avutil.Frame) (m HDRMetadata) {
+	// Get C frame
+	cf := (*C.struct_AVFrame)(unsafe.Pointer(f))
+
+	// Content light level
+	if sd := C.av_frame_get_side_data(cf, C.AV_FRAME_DATA_CONTENT_LIGHT_LEVEL); sd != nil {
+		m.ContentLightLevel = newContentLightLevel((*C.AVContentLightMetadata)(unsafe.Pointer(sd.data)))
+	}
+
+	// Mastering display
+	if sd := C.av_frame_get_side_data(cf, C.AV_FRAME_DATA_MASTERING_DISPLAY_METADATA); sd != nil {
+		m.MasteringDisplay = newMasteringDisplay((*C.AVMasteringDisplayMetadata)(unsafe.Pointer(sd.data)))
+	}
+	return
+}
+
+func setFrameHDRMetadata(f *avutil.Frame, m HDRMetadata) error {
+	// Get C frame
+	cf := (*C.struct_AVFrame)(unsafe.Pointer(f))
+
+	// Content light level
+	if m.ContentLightLevel != nil {
+		v := C.av_content_light_metadata_create_side_data(cf)
+		if v == nil {
+			return errors.New("astilibav: av_content_light_metadata_create_side_data failed")
+		}
+		writeContentLightLevel(v, *m.ContentLightLevel)
+	}
+
+	// Mastering display
+	if m.MasteringDisplay != nil {
+		v := C.av_mastering_display_metadata_create_side_data(cf)
+		if v == nil {
+			return errors.New("astilibav: av_mastering_display_metadata_create_side_data failed")
+		}
+		writeMasteringDisplay(v, *m.MasteringDisplay)
+	}
+	return nil
+}
+
+func streamHDRMetadata(s *avformat.Stream) (m HDRMetadata) {
+	// Content light level
+	if sd := streamSideData(s, avcodec.AV_PKT_DATA_CONTENT_LIGHT_LEVEL); sd != nil {
+		m.ContentLightLevel = newContentLightLevel((*C.AVContentLightMetadata)(sd))
+	}
+
+	// Mastering display
+	if sd := streamSideData(s, avcodec.AV_PKT_DATA_MASTERING_DISPLAY_METADATA); sd != nil {
+		m.MasteringDisplay = newMasteringDisplay((*C.AVMasteringDisplayMetadata)(sd))
+	}
+	return
+}
+
+func setStreamHDRMetadata(s *avformat.Stream, m HDRMetadata) error {
+	// Content light level
+	if m.ContentLightLevel != nil {
+		sd := streamNewSideData(s, avcodec.AV_PKT_DATA_CONTENT_LIGHT_LEVEL, int(C.sizeof_AVContentLightMetadata))
+		if sd == nil {
+			return errors.New("astilibav: av_stream_new_side_data failed")
+		}
+		writeContentLightLevel((*C.AVContentLightMetadata)(sd), *m.ContentLightLevel)
+	}
+
+	// Mastering display
+	if m.MasteringDisplay != nil {
+		sd := streamNewSideData(s, avcodec.AV_PKT_DATA_MASTERING_DISPLAY_METADATA, int(C.sizeof_AVMasteringDisplayMetadata))
+		if sd == nil {
+			return errors.New("astilibav: av_stream_new_side_data failed")
+		}
+		writeMasteringDisplay((*C.AVMasteringDisplayMetadata)(sd), *m.MasteringDisplay)
+	}
+	return nil
+}
+
+func newContentLightLevel(v *C.AVContentLightMetadata) *ContentLightLevel {
+	return &ContentLightLevel{
+		MaxCLL:  uint(v.MaxCLL),
+		MaxFALL: uint(v.MaxFALL),
+	}
+}
+
+func writeContentLightLevel(v *C.AVContentLightMetadata, l ContentLightLevel) {
+	v.MaxCLL = C.uint(l.MaxCLL)
+	v.MaxFALL = C.uint(l.MaxFALL)
+}
+
+func newMasteringDisplay(v *C.AVMasteringDisplayMetadata) (d *MasteringDisplay) {
+	d = &MasteringDisplay{}
+	if v.has_primaries > 0 {
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 2; j++ {
+				d.DisplayPrimaries[i][j] = newRationalFromC(v.display_primaries[i][j])
+			}
+		}
+		for i := 0; i < 2; i++ {
+			d.WhitePoint[i] = newRationalFromC(v.white_point[i])
+		}
+	}
+	if v.has_luminance > 0 {
+		d.MaxLuminance = newRationalFromC(v.max_luminance)
+		d.MinLuminance = newRationalFromC(v.min_luminance)
+	}
+	return
+}
+
+func writeMasteringDisplay(v *C.AVMasteringDisplayMetadata, d MasteringDisplay) {
+	if d.WhitePoint[0].Den() > 0 && d.WhitePoint[1].Den() > 0 {
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 2; j++ {
+				v.display_primaries[i][j] = newCRational(d.DisplayPrimaries[i][j])
+			}
+		}
+		for i := 0; i < 2; i++ {
+			v.white_point[i] = newCRational(d.WhitePoint[i])
+		}
+		v.has_primaries = 1
+	}
+	if d.MaxLuminance.Den() > 0 {
+		min := d.MinLuminance
+		if min.Den() <= 0 {
+			min = avutil.NewRational(0, 1)
+		}
+		v.max_luminance = newCRational(d.MaxLuminance)
+		v.min_luminance = newCRational(min)
+		v.has_luminance = 1
+	}
+}
@@ -0,0 +1,33 @@
+package astilibav
+
+import (
+	"context"
+	"time"
+
+	"github.com/asticode/go-astikit"
+)
+
+// Clock represents an object capable of telling the time and sleeping
+// It allows driving timing-sensitive nodes with a virtual time in tests and benchmarks
+type Clock interface {
+	Now() time.Time
+	Sleep(ctx context.Context, d time.Duration)
+}
+
+type realClock struct{}
+
+// RealClock is the default clock. It relies on the system time
+var RealClock Clock = realClock{}
+
+// Now implements the Clock interface
+func (realClock) Now() time.Time { return time.Now() }
+
+// Sleep implements the Clock interface
+func (realClock) Sleep(ctx context.Context, d time.Duration) { astikit.Sleep(ctx, d) }
+
+func clockOrDefault(c Clock) Clock {
+	if c != nil {
+		return c
+	}
+	return RealClock
+}
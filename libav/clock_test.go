@@ -0,0 +1,29 @@
+package astilibav
+
+import (
+	"context"
+	"time"
+)
+
+// testClock is a fake clock whose sleeps move time forward, unless sleeps is set in which case they're sent to
+// sleeps and block until wake receives, time being moved forward manually
+type testClock struct {
+	now    time.Time
+	sleeps chan time.Duration
+	wake   chan struct{}
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Unix(0, 0)}
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func (c *testClock) Sleep(ctx context.Context, d time.Duration) {
+	if c.sleeps == nil {
+		c.now = c.now.Add(d)
+		return
+	}
+	c.sleeps <- d
+	<-c.wake
+}
@@ -0,0 +1,321 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+)
+
+// CodecCapabilities represents what a codec supports
+// Empty slices mean the codec doesn't restrict the corresponding values
+type CodecCapabilities struct {
+	ChannelLayouts []uint64
+	Flags          int
+	FrameRates     []avutil.Rational
+	ID             avcodec.CodecId
+	LongName       string
+	MediaType      avcodec.MediaType
+	Name           string
+	PixelFormats   []avutil.PixelFormat
+	SampleFmts     []avcodec.AvSampleFormat
+	SampleRates    []int
+}
+
+// FindEncoderCapabilities returns the capabilities of the encoder with the specified name
+func FindEncoderCapabilities(name string) (cc CodecCapabilities, err error) {
+	c := avcodec.AvcodecFindEncoderByName(name)
+	if c == nil {
+		err = fmt.Errorf("astilibav: no encoder with name %s", name)
+		return
+	}
+	cc = newCodecCapabilities(c)
+	return
+}
+
+// FindDecoderCapabilities returns the capabilities of the decoder with the specified name
+func FindDecoderCapabilities(name string) (cc CodecCapabilities, err error) {
+	c := avcodec.AvcodecFindDecoderByName(name)
+	if c == nil {
+		err = fmt.Errorf("astilibav: no decoder with name %s", name)
+		return
+	}
+	cc = newCodecCapabilities(c)
+	return
+}
+
+// Delay indicates whether the codec needs to be flushed at the end
+func (cc CodecCapabilities) Delay() bool {
+	return cc.Flags&avCodecCapDelay > 0
+}
+
+// Experimental indicates whether the codec is experimental
+func (cc CodecCapabilities) Experimental() bool {
+	return cc.Flags&avCodecCapExperimental > 0
+}
+
+// FrameThreads indicates whether the codec supports frame-level multithreading
+func (cc CodecCapabilities) FrameThreads() bool {
+	return cc.Flags&avCodecCapFrameThreads > 0
+}
+
+// Hardware indicates whether the codec is backed by a hardware implementation
+func (cc CodecCapabilities) Hardware() bool {
+	return cc.Flags&avCodecCapHardware > 0
+}
+
+// SliceThreads indicates whether the codec supports slice-based multithreading
+func (cc CodecCapabilities) SliceThreads() bool {
+	return cc.Flags&avCodecCapSliceThreads > 0
+}
+
+// VariableFrameSize indicates whether the audio codec accepts frames with any number of samples
+// Otherwise frames must contain exactly frame_size samples
+func (cc CodecCapabilities) VariableFrameSize() bool {
+	return cc.Flags&avCodecCapVariableFrameSize > 0
+}
+
+// SupportsChannelLayout indicates whether the codec supports the channel layout
+func (cc CodecCapabilities) SupportsChannelLayout(v uint64) bool {
+	if len(cc.ChannelLayouts) == 0 {
+		return true
+	}
+	for _, l := range cc.ChannelLayouts {
+		if l == v {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsFrameRate indicates whether the codec supports the frame rate
+func (cc CodecCapabilities) SupportsFrameRate(v avutil.Rational) bool {
+	if len(cc.FrameRates) == 0 {
+		return true
+	}
+	for _, r := range cc.FrameRates {
+		if r.Num()*v.Den() == v.Num()*r.Den() {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsPixelFormat indicates whether the codec supports the pixel format
+func (cc CodecCapabilities) SupportsPixelFormat(v avutil.PixelFormat) bool {
+	if len(cc.PixelFormats) == 0 {
+		return true
+	}
+	for _, f := range cc.PixelFormats {
+		if f == v {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsSampleFmt indicates whether the codec supports the sample fmt
+func (cc CodecCapabilities) SupportsSampleFmt(v avcodec.AvSampleFormat) bool {
+	if len(cc.SampleFmts) == 0 {
+		return true
+	}
+	for _, f := range cc.SampleFmts {
+		if f == v {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsSampleRate indicates whether the codec supports the sample rate
+func (cc CodecCapabilities) SupportsSampleRate(v int) bool {
+	if len(cc.SampleRates) == 0 {
+		return true
+	}
+	for _, r := range cc.SampleRates {
+		if r == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks whether the context is supported by the codec
+func (cc CodecCapabilities) Validate(ctx Context) error {
+	switch ctx.CodecType {
+	case avutil.AVMEDIA_TYPE_AUDIO:
+		if !cc.SupportsChannelLayout(ctx.ChannelLayout) {
+			return fmt.Errorf("astilibav: channel layout %d is not supported by %s", ctx.ChannelLayout, cc.Name)
+		}
+		if !cc.SupportsSampleFmt(ctx.SampleFmt) {
+			return fmt.Errorf("astilibav: sample fmt %v is not supported by %s", ctx.SampleFmt, cc.Name)
+		}
+		if !cc.SupportsSampleRate(ctx.SampleRate) {
+			return fmt.Errorf("astilibav: sample rate %d is not supported by %s", ctx.SampleRate, cc.Name)
+		}
+	case avutil.AVMEDIA_TYPE_VIDEO:
+		if !cc.SupportsPixelFormat(ctx.PixelFormat) {
+			return fmt.Errorf("astilibav: pixel format %v is not supported by %s", ctx.PixelFormat, cc.Name)
+		}
+		if ctx.FrameRate.Num() > 0 && !cc.SupportsFrameRate(ctx.FrameRate) {
+			return fmt.Errorf("astilibav: frame rate %s is not supported by %s", ctx.FrameRate, cc.Name)
+		}
+	}
+	return nil
+}
+
+// CodecSendOptions represents options used when sending packets or frames to a codec
+type CodecSendOptions struct {
+	// Delay before sending again when the codec returned EAGAIN and no output could be drained. Default is 1ms
+	Backoff time.Duration
+	// Max number of attempts to send the same input when the codec keeps returning EAGAIN. Default is 10
+	MaxAttempts int
+}
+
+func (o CodecSendOptions) withDefaults() CodecSendOptions {
+	if o.Backoff <= 0 {
+		o.Backoff = time.Millisecond
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 10
+	}
+	return o
+}
+
+// sendToCodec sends input to a codec and drains its output. When the codec returns EAGAIN, its output
+// must be drained before it accepts new input, therefore send and receive are alternated until the input
+// is accepted or the max number of attempts is reached
+func sendToCodec(ctx context.Context, o CodecSendOptions, statEAGAIN *astikit.CounterRateStat, send func() int, receive func() (received int)) (ret int) {
+	for attempt := 1; ; attempt++ {
+		// Send
+		ret = send()
+
+		// Drain output
+		received := receive()
+
+		// Input has been accepted or an error occurred
+		if ret != avutil.AVERROR_EAGAIN {
+			return
+		}
+
+		// Increment EAGAIN stat
+		statEAGAIN.Add(1)
+
+		// No more attempts
+		if attempt >= o.MaxAttempts {
+			return
+		}
+
+		// Nothing could be drained, give the codec some time
+		if received == 0 {
+			if err := astikit.Sleep(ctx, o.Backoff); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// codecBacklogStat counts inputs sent to a codec and not yet matched by an output, which reveals
+// whether the codec is accumulating latency
+type codecBacklogStat struct {
+	received int64
+	sent     int64
+}
+
+func newCodecBacklogStat() *codecBacklogStat {
+	return &codecBacklogStat{}
+}
+
+func (s *codecBacklogStat) addSent() { atomic.AddInt64(&s.sent, 1) }
+
+func (s *codecBacklogStat) addReceived() { atomic.AddInt64(&s.received, 1) }
+
+// Start implements the astikit.StatHandler interface
+func (s *codecBacklogStat) Start() {}
+
+// Stop implements the astikit.StatHandler interface
+func (s *codecBacklogStat) Stop() {}
+
+// Value implements the astikit.StatHandler interface
+func (s *codecBacklogStat) Value(_ time.Duration) interface{} {
+	return float64(atomic.LoadInt64(&s.sent) - atomic.LoadInt64(&s.received))
+}
+
+// codecBacklogDurationStat measures the duration between the latest timestamp sent to a codec and the latest
+// timestamp received from it, which is more accurate than codecBacklogStat when codecs don't output one frame per
+// packet (and vice versa)
+type codecBacklogDurationStat struct {
+	m           *sync.Mutex // Locks attributes below
+	hasReceived bool
+	hasSent     bool
+	received    time.Duration
+	sent        time.Duration
+}
+
+func newCodecBacklogDurationStat() *codecBacklogDurationStat {
+	return &codecBacklogDurationStat{m: &sync.Mutex{}}
+}
+
+func (s *codecBacklogDurationStat) addSent(pts int64, timeBase avutil.Rational) {
+	// Timestamps that are not set are ignored
+	if pts == avutil.AV_NOPTS_VALUE {
+		return
+	}
+	d := time.Duration(avutil.AvRescaleQ(pts, timeBase, nanosecondRational))
+
+	// Lock
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Timestamps have gone backwards (e.g. after a seek) or this is the first input
+	if !s.hasSent || d < s.sent {
+		s.hasReceived = false
+		s.hasSent = true
+		s.received = d
+		s.sent = d
+		return
+	}
+	s.sent = d
+}
+
+func (s *codecBacklogDurationStat) addReceived(pts int64, timeBase avutil.Rational) {
+	// Timestamps that are not set are ignored
+	if pts == avutil.AV_NOPTS_VALUE {
+		return
+	}
+	d := time.Duration(avutil.AvRescaleQ(pts, timeBase, nanosecondRational))
+
+	// Lock
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Outputs may be reordered, only the latest timestamp matters
+	if !s.hasReceived || d > s.received {
+		s.hasReceived = true
+		s.received = d
+	}
+}
+
+// Start implements the astikit.StatHandler interface
+func (s *codecBacklogDurationStat) Start() {}
+
+// Stop implements the astikit.StatHandler interface
+func (s *codecBacklogDurationStat) Stop() {}
+
+// Value implements the astikit.StatHandler interface
+func (s *codecBacklogDurationStat) Value(_ time.Duration) interface{} {
+	// Lock
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Nothing is pending
+	if s.received >= s.sent {
+		return 0.0
+	}
+	return float64(s.sent-s.received) / float64(time.Millisecond)
+}
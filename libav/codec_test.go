@@ -0,0 +1,46 @@
+package astilibav
+
+import (
+	"testing"
+
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecBacklogStat(t *testing.T) {
+	s := newCodecBacklogStat()
+	assert.Equal(t, 0.0, s.Value(0))
+	s.addSent()
+	s.addSent()
+	s.addSent()
+	assert.Equal(t, 3.0, s.Value(0))
+	s.addReceived()
+	assert.Equal(t, 2.0, s.Value(0))
+}
+
+func TestCodecBacklogDurationStat(t *testing.T) {
+	s := newCodecBacklogDurationStat()
+	tb := avutil.NewRational(1, 1000)
+	assert.Equal(t, 0.0, s.Value(0))
+
+	// Several inputs are sent before the first output
+	s.addSent(0, tb)
+	s.addSent(40, tb)
+	s.addSent(80, tb)
+	assert.Equal(t, 80.0, s.Value(0))
+
+	// Outputs may be reordered and more numerous than inputs
+	s.addReceived(80, avutil.NewRational(1, 500))
+	s.addReceived(40, tb)
+	s.addReceived(60, tb)
+	s.addReceived(avutil.AV_NOPTS_VALUE, tb)
+	assert.Equal(t, 0.0, s.Value(0))
+	s.addSent(200, tb)
+	assert.Equal(t, 40.0, s.Value(0))
+
+	// Timestamps going backwards reset the backlog
+	s.addSent(0, tb)
+	assert.Equal(t, 0.0, s.Value(0))
+	s.addSent(40, tb)
+	assert.Equal(t, 40.0, s.Value(0))
+}
@@ -2,6 +2,7 @@ package astilibav
 
 import (
 	"fmt"
+	"math/bits"
 	"strconv"
 	"strings"
 	"unsafe"
@@ -26,14 +27,20 @@ type Context struct {
 	// Audio
 	ChannelLayout uint64
 	Channels      int
+	FrameSize     int
 	SampleFmt     avcodec.AvSampleFormat
 	SampleRate    int
 
 	// Video
-	FrameRate         avutil.Rational
-	GopSize           int
+	// If nil, color properties are left to the codec defaults
+	Color     *ColorProperties
+	FrameRate avutil.Rational
+	GopSize   int
+	// HDR side data written in streams added by the encoder
+	HDR               HDRMetadata
 	Height            int
 	PixelFormat       avutil.PixelFormat
+	Rotation          float64
 	SampleAspectRatio avutil.Rational
 	Width             int
 }
@@ -96,6 +103,9 @@ func (ctx Context) String() string {
 		if ctx.PixelFormat >= 0 {
 			ss = append(ss, "pixel format: "+avutil.AvGetPixFmtName(ctx.PixelFormat))
 		}
+		if ctx.Rotation != 0 {
+			ss = append(ss, "rotation: "+strconv.FormatFloat(ctx.Rotation, 'f', -1, 64))
+		}
 		if ctx.SampleAspectRatio.ToDouble() > 0 {
 			ss = append(ss, "sample aspect ratio: "+ctx.SampleAspectRatio.String())
 		}
@@ -126,19 +136,84 @@ func NewContextFromStream(s *avformat.Stream) Context {
 		// Audio
 		ChannelLayout: ctxCodec.ChannelLayout(),
 		Channels:      ctxCodec.Channels(),
+		FrameSize:     ctxCodec.FrameSize(),
 		SampleFmt:     ctxCodec.SampleFmt(),
 		SampleRate:    ctxCodec.SampleRate(),
 
 		// Video
+		Color:             StreamColorProperties(s),
 		FrameRate:         streamFrameRate(s),
 		GopSize:           ctxCodec.GopSize(),
+		HDR:               StreamHDRMetadata(s),
 		Height:            ctxCodec.Height(),
 		PixelFormat:       ctxCodec.PixFmt(),
+		Rotation:          StreamRotation(s),
 		SampleAspectRatio: s.SampleAspectRatio(),
 		Width:             ctxCodec.Width(),
 	}
 }
 
+// NewVideoContext creates a new video context that doesn't rely on an input stream
+func NewVideoContext(width, height int, pixFmt avutil.PixelFormat, timeBase, frameRate avutil.Rational) Context {
+	return Context{
+		CodecType:         avutil.AVMEDIA_TYPE_VIDEO,
+		FrameRate:         frameRate,
+		Height:            height,
+		PixelFormat:       pixFmt,
+		SampleAspectRatio: avutil.NewRational(1, 1),
+		TimeBase:          timeBase,
+		Width:             width,
+	}
+}
+
+// NewAudioContext creates a new audio context that doesn't rely on an input stream
+func NewAudioContext(sampleRate int, sampleFmt avcodec.AvSampleFormat, channelLayout uint64, timeBase avutil.Rational) Context {
+	return Context{
+		ChannelLayout: channelLayout,
+		Channels:      bits.OnesCount64(channelLayout),
+		CodecType:     avutil.AVMEDIA_TYPE_AUDIO,
+		SampleFmt:     sampleFmt,
+		SampleRate:    sampleRate,
+		TimeBase:      timeBase,
+	}
+}
+
+func (ctx Context) canAllocFrameBuffer() bool {
+	switch ctx.CodecType {
+	case avutil.AVMEDIA_TYPE_AUDIO:
+		return ctx.ChannelLayout > 0 && ctx.SampleFmt >= 0 && ctx.SampleRate > 0 && ctx.FrameSize > 0
+	case avutil.AVMEDIA_TYPE_VIDEO:
+		return ctx.Height > 0 && ctx.PixelFormat >= 0 && ctx.Width > 0
+	default:
+		return false
+	}
+}
+
+func (ctx Context) allocFrameBuffer(f *avutil.Frame) (err error) {
+	// Set frame attributes
+	switch ctx.CodecType {
+	case avutil.AVMEDIA_TYPE_AUDIO:
+		f.SetChannelLayout(ctx.ChannelLayout)
+		f.SetFormat(int(ctx.SampleFmt))
+		f.SetNbSamples(ctx.FrameSize)
+		f.SetSampleRate(ctx.SampleRate)
+	case avutil.AVMEDIA_TYPE_VIDEO:
+		f.SetFormat(int(ctx.PixelFormat))
+		f.SetHeight(ctx.Height)
+		f.SetWidth(ctx.Width)
+	default:
+		err = fmt.Errorf("astilibav: can't allocate frame buffer for codec type %v", ctx.CodecType)
+		return
+	}
+
+	// Alloc buffer
+	if ret := avutil.AvFrameGetBuffer(f, 0); ret < 0 {
+		err = fmt.Errorf("astilibav: avutil.AvFrameGetBuffer failed: %w", NewAvError(ret))
+		return
+	}
+	return
+}
+
 func streamFrameRate(s *avformat.Stream) avutil.Rational {
 	if v := s.AvgFrameRate(); v.Num() > 0 {
 		return s.AvgFrameRate()
@@ -0,0 +1,226 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avutil"
+)
+
+var countCropper uint64
+
+// Cropper represents an object capable of cropping frames
+type Cropper struct {
+	*astiencoder.BaseNode
+	c                 *astikit.Chan
+	d                 *frameDispatcher
+	eh                *astiencoder.EventHandler
+	outputCtx         Context
+	p                 *framePool
+	r                 CropperRectangle
+	statIncomingRate  *astikit.CounterRateStat
+	statProcessedRate *astikit.CounterRateStat
+}
+
+// CropperRectangle represents a cropper rectangle
+type CropperRectangle struct {
+	Height int
+	Width  int
+	X      int
+	Y      int
+}
+
+// CropperOptions represents cropper options
+type CropperOptions struct {
+	// Context of the frames coming in
+	InputCtx  Context
+	Node      astiencoder.NodeOptions
+	Rectangle CropperRectangle
+}
+
+// NewCropper creates a new cropper
+func NewCropper(o CropperOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (cr *Cropper, err error) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countCropper, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("cropper_%d", count), fmt.Sprintf("Cropper #%d", count), "Crops", "cropper")
+
+	// Create cropper
+	cr = &Cropper{
+		c:                 astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
+		eh:                eh,
+		outputCtx:         o.InputCtx,
+		p:                 newFramePool(c),
+		r:                 o.Rectangle,
+		statIncomingRate:  astikit.NewCounterRateStat(),
+		statProcessedRate: astikit.NewCounterRateStat(),
+	}
+
+	// Create base node
+	cr.BaseNode = astiencoder.NewBaseNode(o.Node, eh, s, cr, astiencoder.EventTypeToNodeEventName)
+
+	// Create frame dispatcher
+	cr.d = newFrameDispatcher(cr, eh, cr.p)
+
+	// Add stats
+	cr.addStats()
+
+	// Validate rectangle
+	if o.InputCtx.Width > 0 && o.InputCtx.Height > 0 {
+		if err = o.Rectangle.validate(o.InputCtx.Width, o.InputCtx.Height, o.InputCtx.PixelFormat); err != nil {
+			err = fmt.Errorf("astilibav: validating rectangle failed: %w", err)
+			return
+		}
+	}
+
+	// Update output ctx
+	cr.outputCtx.Height = o.Rectangle.Height
+	cr.outputCtx.Width = o.Rectangle.Width
+	return
+}
+
+func (r CropperRectangle) validate(width, height int, pixFmt avutil.PixelFormat) error {
+	// Check bounds
+	if r.X < 0 || r.Y < 0 || r.Width <= 0 || r.Height <= 0 {
+		return fmt.Errorf("astilibav: invalid rectangle %+v", r)
+	}
+	if r.X+r.Width > width || r.Y+r.Height > height {
+		return fmt.Errorf("astilibav: rectangle %+v is out of %dx%d bounds", r, width, height)
+	}
+
+	// Check chroma subsampling alignment
+	desc := pixFmtDescGet(pixFmt)
+	if desc == nil {
+		return fmt.Errorf("astilibav: no descriptor found for pixel format %v", pixFmt)
+	}
+	if alignW, alignH := 1<<uint(desc.log2ChromaW), 1<<uint(desc.log2ChromaH); r.X%alignW != 0 || r.Width%alignW != 0 || r.Y%alignH != 0 || r.Height%alignH != 0 {
+		return fmt.Errorf("astilibav: rectangle %+v is not aligned with chroma subsampling %dx%d", r, alignW, alignH)
+	}
+	return nil
+}
+
+func (cr *Cropper) addStats() {
+	// Get stats
+	ss := cr.c.Stats()
+	ss = append(ss, cr.d.stats()...)
+	ss = append(ss,
+		astikit.StatOptions{
+			Handler: cr.statIncomingRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames coming in per second",
+				Label:       "Incoming rate",
+				Name:        StatNameIncomingRate,
+				Unit:        "fps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: cr.statProcessedRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames processed per second",
+				Label:       "Processed rate",
+				Name:        StatNameProcessedRate,
+				Unit:        "fps",
+			},
+		},
+	)
+
+	// Add stats
+	cr.BaseNode.AddStats(ss...)
+}
+
+// OutputCtx returns the output ctx
+func (cr *Cropper) OutputCtx() Context {
+	return cr.outputCtx
+}
+
+// Connect implements the FrameHandlerConnector interface
+func (cr *Cropper) Connect(h FrameHandler) {
+	// Add handler
+	cr.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(cr, h)
+}
+
+// Disconnect implements the FrameHandlerConnector interface
+func (cr *Cropper) Disconnect(h FrameHandler) {
+	// Delete handler
+	cr.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(cr, h)
+}
+
+// Drain implements the Drainer interface
+func (cr *Cropper) Drain(ctx context.Context) error {
+	return drainChan(ctx, cr.BaseNode, cr.c)
+}
+
+// Start starts the cropper
+func (cr *Cropper) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	cr.BaseNode.Start(ctx, t, func(t *astikit.Task) {
+		// Make sure to stop the chan properly
+		defer cr.c.Stop()
+
+		// Start chan
+		cr.c.Start(cr.Context())
+	})
+}
+
+// HandleFrame implements the FrameHandler interface
+func (cr *Cropper) HandleFrame(p FrameHandlerPayload) {
+	// Increment incoming rate
+	cr.statIncomingRate.Add(1)
+
+	// Copy frame
+	f := cr.p.get()
+	if ret := avutil.AvFrameRef(f, p.Frame); ret < 0 {
+		cr.p.put(f)
+		emitAvError(cr, cr.eh, ret, "avutil.AvFrameRef failed")
+		return
+	}
+
+	// Add to chan
+	cr.c.Add(func() {
+		// Handle pause
+		defer cr.HandlePause()
+
+		// Make sure to close frame
+		defer cr.p.put(f)
+
+		// Increment processed rate
+		cr.statProcessedRate.Add(1)
+
+		// Crop
+		if err := cropFrame(f, cr.r); err != nil {
+			cr.eh.Emit(astiencoder.EventError(cr, fmt.Errorf("astilibav: cropping frame failed: %w", err)))
+			return
+		}
+
+		// Dispatch frame
+		cr.d.dispatch(f, p.Descriptor)
+	})
+}
+
+// cropFrame crops the frame in place without copying its data: only its data pointers and dimensions are updated
+func cropFrame(f *avutil.Frame, r CropperRectangle) (err error) {
+	// Validate rectangle
+	if err = r.validate(f.Width(), f.Height(), avutil.PixelFormat(f.Format())); err != nil {
+		err = fmt.Errorf("astilibav: validating rectangle failed: %w", err)
+		return
+	}
+
+	// Nothing to crop
+	if r.X == 0 && r.Y == 0 && r.Width == f.Width() && r.Height == f.Height() {
+		return
+	}
+
+	// Apply cropping
+	if ret := frameApplyCropping(f, r.Y, f.Height()-r.Y-r.Height, r.X, f.Width()-r.X-r.Width); ret < 0 {
+		err = fmt.Errorf("astilibav: av_frame_apply_cropping failed: %w", NewAvError(ret))
+		return
+	}
+	return
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sync/atomic"
+	"unsafe"
 
 	"github.com/asticode/go-astiencoder"
 	"github.com/asticode/go-astikit"
@@ -20,18 +21,30 @@ type Decoder struct {
 	ctxCodec          *avcodec.Context
 	d                 *frameDispatcher
 	eh                *astiencoder.EventHandler
-	outputCtx         Context
+	fdn               *firstDispatchedNotifier
 	fp                *framePool
+	outputCtx         Context
 	pp                *pktPool
+	sendOptions       CodecSendOptions
+	statBacklog       *codecBacklogStat
+	statBacklogDur    *codecBacklogDurationStat
+	statEAGAINRate    *astikit.CounterRateStat
 	statIncomingRate  *astikit.CounterRateStat
 	statProcessedRate *astikit.CounterRateStat
 }
 
 // DecoderOptions represents decoder options
 type DecoderOptions struct {
+	// Clock used to timestamp the first dispatched frame. Default is RealClock
+	Clock       Clock
 	CodecParams *avcodec.CodecParameters
+	// If provided, the decoder will be found by name instead of by codec id
+	// This is useful to pin a specific implementation (e.g. h264_qsv vs h264)
+	DecoderName string
 	Node        astiencoder.NodeOptions
 	OutputCtx   Context
+	// Options used when the decoder returns EAGAIN
+	Send CodecSendOptions
 }
 
 // NewDecoder creates a new decoder
@@ -47,6 +60,10 @@ func NewDecoder(o DecoderOptions, eh *astiencoder.EventHandler, c *astikit.Close
 		outputCtx:         o.OutputCtx,
 		fp:                newFramePool(c),
 		pp:                newPktPool(c),
+		sendOptions:       o.Send.withDefaults(),
+		statBacklog:       newCodecBacklogStat(),
+		statBacklogDur:    newCodecBacklogDurationStat(),
+		statEAGAINRate:    astikit.NewCounterRateStat(),
 		statIncomingRate:  astikit.NewCounterRateStat(),
 		statProcessedRate: astikit.NewCounterRateStat(),
 	}
@@ -57,12 +74,23 @@ func NewDecoder(o DecoderOptions, eh *astiencoder.EventHandler, c *astikit.Close
 	// Create frame dispatcher
 	d.d = newFrameDispatcher(d, eh, d.fp)
 
+	// Create first dispatched notifier
+	d.fdn = newFirstDispatchedNotifier(EventNameDecoderFirstFrameDispatched, d, eh, o.Clock)
+
 	// Add stats
 	d.addStats()
 
 	// Find decoder
 	var cdc *avcodec.Codec
-	if cdc = avcodec.AvcodecFindDecoder(o.CodecParams.CodecId()); cdc == nil {
+	if len(o.DecoderName) > 0 {
+		if cdc = avcodec.AvcodecFindDecoderByName(o.DecoderName); cdc == nil {
+			err = fmt.Errorf("astilibav: no decoder with name %s", o.DecoderName)
+			return
+		} else if id := codecID(cdc); id != o.CodecParams.CodecId() {
+			err = fmt.Errorf("astilibav: decoder %s handles codec id %+v whereas stream's codec id is %+v", o.DecoderName, id, o.CodecParams.CodecId())
+			return
+		}
+	} else if cdc = avcodec.AvcodecFindDecoder(o.CodecParams.CodecId()); cdc == nil {
 		err = fmt.Errorf("astilibav: no decoder found for codec id %+v", o.CodecParams.CodecId())
 		return
 	}
@@ -92,6 +120,9 @@ func NewDecoder(o DecoderOptions, eh *astiencoder.EventHandler, c *astikit.Close
 		}
 		return nil
 	})
+
+	// Attribute logs
+	c.Add(logParents.add(unsafe.Pointer(d.ctxCodec), d))
 	return
 }
 
@@ -100,6 +131,33 @@ func (d *Decoder) addStats() {
 	ss := append(d.c.Stats())
 	ss = append(ss, d.d.stats()...)
 	ss = append(ss,
+		astikit.StatOptions{
+			Handler: d.statBacklog,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of packets sent to the codec minus number of frames received from it",
+				Label:       "Backlog",
+				Name:        StatNameBacklog,
+				Unit:        "frames",
+			},
+		},
+		astikit.StatOptions{
+			Handler: d.statBacklogDur,
+			Metadata: &astikit.StatMetadata{
+				Description: "Duration between the latest packet sent to the codec and the latest frame received from it",
+				Label:       "Backlog duration",
+				Name:        StatNameBacklogDuration,
+				Unit:        "ms",
+			},
+		},
+		astikit.StatOptions{
+			Handler: d.statEAGAINRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of times per second the codec returned EAGAIN when sending it packets",
+				Label:       "EAGAIN rate",
+				Name:        StatNameEAGAINRate,
+				Unit:        "pps",
+			},
+		},
 		astikit.StatOptions{
 			Handler: d.statIncomingRate,
 			Metadata: &astikit.StatMetadata{
@@ -147,6 +205,11 @@ func (d *Decoder) Disconnect(h FrameHandler) {
 	astiencoder.DisconnectNodes(d, h)
 }
 
+// Drain implements the Drainer interface
+func (d *Decoder) Drain(ctx context.Context) error {
+	return drainChan(ctx, d.BaseNode, d.c)
+}
+
 // Start starts the decoder
 func (d *Decoder) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 	d.BaseNode.Start(ctx, t, func(t *astikit.Task) {
@@ -158,6 +221,14 @@ func (d *Decoder) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 	})
 }
 
+// Flush drops frames buffered by the codec once packets already received have been processed.
+// It's useful after the demuxer has seeked so that frames of the previous position are not dispatched
+func (d *Decoder) Flush() {
+	d.c.Add(func() {
+		d.ctxCodec.AvcodecFlushBuffers()
+	})
+}
+
 // HandlePkt implements the PktHandler interface
 func (d *Decoder) HandlePkt(p PktHandlerPayload) {
 	// Increment incoming rate
@@ -166,6 +237,7 @@ func (d *Decoder) HandlePkt(p PktHandlerPayload) {
 	// Copy pkt
 	pkt := d.pp.get()
 	if ret := pkt.AvPacketRef(p.Pkt); ret < 0 {
+		d.pp.put(pkt)
 		emitAvError(d, d.eh, ret, "AvPacketRef failed")
 		return
 	}
@@ -181,22 +253,40 @@ func (d *Decoder) HandlePkt(p PktHandlerPayload) {
 		// Increment processed rate
 		d.statProcessedRate.Add(1)
 
-		// Send pkt to decoder
-		if ret := avcodec.AvcodecSendPacket(d.ctxCodec, pkt); ret < 0 {
-			emitAvError(d, d.eh, ret, "avcodec.AvcodecSendPacket failed")
+		// Acquire quota
+		release, err := d.AcquireQuota()
+		if err != nil {
+			d.eh.Emit(astiencoder.EventError(d, fmt.Errorf("astilibav: acquiring quota failed: %w", err)))
 			return
 		}
+		defer release()
 
-		// Loop
-		for {
-			// Receive frame
-			if stop := d.receiveFrame(p.Descriptor); stop {
-				return
+		// Send pkt to decoder and receive frames
+		if ret := sendToCodec(d.Context(), d.sendOptions, d.statEAGAINRate, func() (ret int) {
+			if ret = avcodec.AvcodecSendPacket(d.ctxCodec, pkt); ret >= 0 {
+				d.statBacklog.addSent()
+				d.statBacklogDur.addSent(pkt.Pts(), p.Descriptor.TimeBase())
 			}
+			return
+		}, func() int {
+			return d.receiveFrames(p.Descriptor)
+		}); ret < 0 {
+			emitAvError(d, d.eh, ret, "avcodec.AvcodecSendPacket failed")
+			return
 		}
 	})
 }
 
+func (d *Decoder) receiveFrames(descriptor Descriptor) (received int) {
+	for {
+		// Receive frame
+		if stop := d.receiveFrame(descriptor); stop {
+			return
+		}
+		received++
+	}
+}
+
 func (d *Decoder) receiveFrame(descriptor Descriptor) (stop bool) {
 	// Get frame
 	f := d.fp.get()
@@ -211,7 +301,24 @@ func (d *Decoder) receiveFrame(descriptor Descriptor) (stop bool) {
 		return
 	}
 
+	// Update backlog
+	d.statBacklog.addReceived()
+	d.statBacklogDur.addReceived(f.Pts(), descriptor.TimeBase())
+
 	// Dispatch frame
 	d.d.dispatch(f, descriptor)
+
+	// Notify first dispatched frame
+	d.fdn.notify(f.Pts(), descriptor.TimeBase())
 	return
 }
+
+// FirstFrameDispatched returns a chan closed once the first frame has been dispatched
+func (d *Decoder) FirstFrameDispatched() <-chan struct{} {
+	return d.fdn.c
+}
+
+// FirstFrame returns the first dispatched frame, if any
+func (d *Decoder) FirstFrame() (FirstDispatched, bool) {
+	return d.fdn.first()
+}
@@ -0,0 +1,35 @@
+package astilibav
+
+import (
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecoderName(t *testing.T) {
+	// Create demuxer
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	d, err := NewDemuxer(DemuxerOptions{URL: "../examples/sample.mp4"}, eh, c, nil)
+	require.NoError(t, err)
+	ss := d.StreamsByCodecType(avutil.AVMEDIA_TYPE_VIDEO)
+	require.Len(t, ss, 1)
+	s := ss[0]
+
+	// Unknown decoder
+	_, err = NewDecoder(DecoderOptions{CodecParams: s.CodecParameters(), DecoderName: "invalid"}, eh, c, nil)
+	assert.Error(t, err)
+
+	// Decoder doesn't handle the stream's codec
+	_, err = NewDecoder(DecoderOptions{CodecParams: s.CodecParameters(), DecoderName: "mpeg4"}, eh, c, nil)
+	assert.Error(t, err)
+
+	// Valid decoder
+	_, err = NewDecoder(DecoderOptions{CodecParams: s.CodecParameters(), DecoderName: "h264"}, eh, c, nil)
+	assert.NoError(t, err)
+}
@@ -2,9 +2,15 @@ package astilibav
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+	"unsafe"
 
 	"github.com/asticode/go-astiencoder"
 	"github.com/asticode/go-astikit"
@@ -18,25 +24,81 @@ var (
 	nanosecondRational = avutil.NewRational(1, 1e9)
 )
 
+// ErrDemuxerReadTimeout is the error emitted when no data has been read within DemuxerOptions.ReadTimeout
+var ErrDemuxerReadTimeout = errors.New("astilibav: demuxer read timeout")
+
 // Demuxer represents an object capable of demuxing packets out of an input
 type Demuxer struct {
 	*astiencoder.BaseNode
+	bytePosition     int64 // Accessed atomically
+	byteSize         int64 // Accessed atomically
+	ci               *astikit.Closer
+	clock            Clock
 	ctxFormat        *avformat.Context
 	d                *pktDispatcher
 	eh               *astiencoder.EventHandler
 	emulateRate      bool
+	eofEmitted       uint32 // Accessed atomically
+	emulateRateSpeed uint64 // Accessed atomically, holds float64 bits
+	fdn              *firstDispatchedNotifier
 	interruptRet     *int
 	loop             bool
+	loopCount        int
+	loops            int
+	ms               *sync.Mutex // Locks seek and ss, as well as ctxFormat when it's read outside of the read loop
+	o                DemuxerOptions
 	p                *pktPool
+	readTimedOut     uint32 // Accessed atomically
+	readTimer        *time.Timer
+	readTimestamp    int64 // Accessed atomically
 	restamper        PktRestamper
+	seek             *DemuxerSeek
 	ss               map[int]*demuxerStream
 	statIncomingRate *astikit.CounterRateStat
+	statPktSizeAvg   *astikit.CounterAvgStat
 }
 
 type demuxerStream struct {
-	ctx               Context
-	emulateRateNextAt time.Time
-	s                 *avformat.Stream
+	ctx                 Context
+	d                   Descriptor
+	emulateRateNextAt   time.Time
+	frameRateOverridden bool
+	s                   *avformat.Stream
+}
+
+// DemuxerReconnectOptions represents demuxer reconnect options
+// Packets are restamped when they're enabled so that timestamps keep increasing across reconnects
+type DemuxerReconnectOptions struct {
+	// Delay before the second attempt. It's doubled after each failed attempt, up to MaxBackoff if > 0
+	Backoff time.Duration
+	// Max number of attempts per read failure. 0 means reconnecting is disabled
+	MaxAttempts int
+	MaxBackoff  time.Duration
+}
+
+// DemuxerReconnectAttempt represents an attempt to reopen the demuxer input
+type DemuxerReconnectAttempt struct {
+	Attempt     int
+	Err         error
+	MaxAttempts int
+	URL         string
+}
+
+// DemuxerStreamOverride represents values overriding the ones detected for a stream.
+// Zero values mean the detected values are kept
+type DemuxerStreamOverride struct {
+	FrameRate avutil.Rational
+	// Packet timestamps are interpreted in this time base instead of the detected one
+	TimeBase avutil.Rational
+}
+
+type demuxerStreamDescriptor struct {
+	timeBase avutil.Rational
+}
+
+// TimeBase implements the Descriptor interface
+func (d demuxerStreamDescriptor) TimeBase() avutil.Rational {
+	return d.timeBase
 }
 
 type demuxerPkt struct {
@@ -44,28 +106,60 @@ type demuxerPkt struct {
 	receivedAt time.Time
 }
 
-func newDemuxerPkt(pkt *avcodec.Packet) *demuxerPkt {
+func newDemuxerPkt(pkt *avcodec.Packet, c Clock) *demuxerPkt {
 	return &demuxerPkt{
 		dts:        pkt.Dts(),
-		receivedAt: time.Now(),
+		receivedAt: c.Now(),
 	}
 }
 
 // DemuxerOptions represents demuxer options
 type DemuxerOptions struct {
+	// Clock used to emulate rate and to timestamp packets. Default is RealClock
+	Clock Clock
 	// String content of the demuxer as you would use in ffmpeg
 	Dict *Dict
 	// If true, the demuxer will sleep between packets for the exact duration of the packet
 	EmulateRate bool
+	// Speed multiplier used to emulate rate: 2 plays twice as fast as real time. 0 means 1, negative values are
+	// rejected
+	EmulateRateSpeed float64
 	// Exact input format
 	Format *avformat.InputFormat
 	// If true, at the end of the input the demuxer will seek to its beginning and start over
 	// In this case the packets are restamped
 	Loop bool
+	// Number of times the input is played when Loop is true. 0 means infinite
+	LoopCount int
 	// Basic node options
 	Node astiencoder.NodeOptions
+	// Max duration, in microseconds, analyzed when retrieving stream information. 0 means the libav default
+	// is used. Lowering it speeds up the startup of live inputs
+	AnalyzeDuration int64
 	// Context used to cancel probing
 	ProbeCtx context.Context
+	// Max number of bytes read when probing the input. 0 means the libav default is used
+	ProbeSize int64
+	// Options used to reopen the input when reading it fails (e.g. a live upstream dropping). It's ignored
+	// if Reader is set
+	Reconnect DemuxerReconnectOptions
+	// If set, the input is read from it instead of URL. The input is seekable only if it implements io.Seeker.
+	// Reads are aborted once the demuxer is stopped, but a Read call blocking forever must be unblocked by the caller
+	Reader io.Reader
+	// Size of the buffer used when reading from Reader. Default is 32KB
+	ReaderBufferSize int
+	// If > 0, reading a packet is aborted when it takes longer than this duration and an error wrapping
+	// ErrDemuxerReadTimeout is emitted. Time spent paused is not taken into account
+	ReadTimeout time.Duration
+	// Discard levels indexed by stream index. Packets are discarded by libav before reaching the demuxer's
+	// handlers which is cheaper than decoding and then dropping frames
+	StreamDiscards map[int]Discard
+	// Indexes of the streams that are demuxed. Packets of other streams are discarded. Nil means all streams
+	// are demuxed
+	StreamIndexes []int
+	// Overrides of the detected frame rate and time base indexed by stream index. They're useful for inputs
+	// reporting wrong values, are reflected in the dispatched descriptor and are used to emulate rate
+	StreamOverrides map[int]DemuxerStreamOverride
 	// URL of the input
 	URL string
 }
@@ -76,14 +170,33 @@ func NewDemuxer(o DemuxerOptions, eh *astiencoder.EventHandler, c *astikit.Close
 	count := atomic.AddUint64(&countDemuxer, uint64(1))
 	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("demuxer_%d", count), fmt.Sprintf("Demuxer #%d", count), fmt.Sprintf("Demuxes %s", o.URL), "demuxer")
 
+	// URL and reader are mutually exclusive
+	if o.Reader != nil && o.URL != "" {
+		err = errors.New("astilibav: URL and Reader are mutually exclusive")
+		return
+	}
+
+	// Check emulate rate speed
+	if o.EmulateRateSpeed < 0 {
+		err = fmt.Errorf("astilibav: invalid emulate rate speed %f", o.EmulateRateSpeed)
+		return
+	} else if o.EmulateRateSpeed == 0 {
+		o.EmulateRateSpeed = 1
+	}
+
 	// Create demuxer
 	d = &Demuxer{
+		clock:            clockOrDefault(o.Clock),
 		eh:               eh,
 		emulateRate:      o.EmulateRate,
+		emulateRateSpeed: math.Float64bits(o.EmulateRateSpeed),
 		loop:             o.Loop,
+		loopCount:        o.LoopCount,
+		ms:               &sync.Mutex{},
+		o:                o,
 		p:                newPktPool(c),
-		ss:               make(map[int]*demuxerStream),
 		statIncomingRate: astikit.NewCounterRateStat(),
+		statPktSizeAvg:   astikit.NewCounterAvgStat(),
 	}
 
 	// Create base node
@@ -92,19 +205,42 @@ func NewDemuxer(o DemuxerOptions, eh *astiencoder.EventHandler, c *astikit.Close
 	// Create pkt dispatcher
 	d.d = newPktDispatcher(d, eh, d.p)
 
+	// Create first dispatched notifier
+	d.fdn = newFirstDispatchedNotifier(EventNameDemuxerFirstPktDispatched, d, eh, d.clock)
+
 	// Add stats
 	d.addStats()
 
-	// If loop is enabled, we need to add a restamper
-	if d.loop {
+	// If loop or reconnect is enabled, we need to add a restamper
+	if d.loop || (o.Reconnect.MaxAttempts > 0 && o.Reader == nil) {
 		d.restamper = NewPktRestamperWithPktDuration()
 	}
 
+	// Create input closer
+	// It's created once and reset each time the input is closed so that reopening the input many times
+	// doesn't make the parent closer grow
+	d.ci = c.NewChild()
+
+	// Open input
+	if err = d.openInput(); err != nil {
+		err = fmt.Errorf("astilibav: opening input failed: %w", err)
+		return
+	}
+	return
+}
+
+func (d *Demuxer) openInput() (err error) {
+	// Probe options take precedence over the dict
+	od := d.o.Dict
+	if pd := d.probeDict(); pd != nil {
+		od = NewDictWithDefaults(od, pd)
+	}
+
 	// Dict
 	var dict *avutil.Dictionary
-	if o.Dict != nil {
+	if od != nil {
 		// Parse dict
-		if err = o.Dict.Parse(&dict); err != nil {
+		if err = od.Parse(&dict); err != nil {
 			err = fmt.Errorf("astilibav: parsing dict failed: %w", err)
 			return
 		}
@@ -120,15 +256,15 @@ func NewDemuxer(o DemuxerOptions, eh *astiencoder.EventHandler, c *astikit.Close
 	d.interruptRet = ctxFormat.SetInterruptCallback()
 
 	// Handle probe cancellation
-	if o.ProbeCtx != nil {
+	if d.o.ProbeCtx != nil {
 		// Create context
-		probeCtx, probeCancel := context.WithCancel(o.ProbeCtx)
+		probeCtx, probeCancel := context.WithCancel(d.o.ProbeCtx)
 
 		// Handle interrupt
 		*d.interruptRet = 0
 		go func() {
 			<-probeCtx.Done()
-			if o.ProbeCtx.Err() != nil {
+			if d.o.ProbeCtx.Err() != nil {
 				*d.interruptRet = 1
 			}
 		}()
@@ -137,9 +273,35 @@ func NewDemuxer(o DemuxerOptions, eh *astiencoder.EventHandler, c *astikit.Close
 		defer probeCancel()
 	}
 
+	// Input is a reader
+	if d.o.Reader != nil {
+		// Get buffer size
+		bufferSize := d.o.ReaderBufferSize
+		if bufferSize <= 0 {
+			bufferSize = 32 * 1024
+		}
+
+		// Create avio reader
+		var r *avIOReader
+		if r, err = newAvIOReader(d.o.Reader, bufferSize, d.interruptRet); err != nil {
+			ctxFormat.AvformatFreeContext()
+			err = fmt.Errorf("astilibav: creating avio reader failed: %w", err)
+			return
+		}
+
+		// Make sure the avio reader is properly closed once the input has been closed
+		d.ci.Add(func() error {
+			r.close()
+			return nil
+		})
+
+		// Set pb
+		ctxFormat.SetPb(r.avIOContext())
+	}
+
 	// Open input
-	if ret := avformat.AvformatOpenInput(&ctxFormat, o.URL, o.Format, &dict); ret < 0 {
-		err = fmt.Errorf("astilibav: avformat.AvformatOpenInput on %+v failed: %w", o, NewAvError(ret))
+	if ret := avformat.AvformatOpenInput(&ctxFormat, d.o.URL, d.o.Format, &dict); ret < 0 {
+		err = fmt.Errorf("astilibav: avformat.AvformatOpenInput on %+v failed: %w", d.o, NewAvError(ret))
 		return
 	}
 
@@ -148,51 +310,222 @@ func NewDemuxer(o DemuxerOptions, eh *astiencoder.EventHandler, c *astikit.Close
 	d.ctxFormat = ctxFormat
 
 	// Make sure the input is properly closed
-	c.Add(func() error {
-		avformat.AvformatCloseInput(d.ctxFormat)
+	d.ci.Add(func() error {
+		avformat.AvformatCloseInput(ctxFormat)
 		return nil
 	})
 
+	// Attribute logs
+	d.ci.Add(logParents.add(unsafe.Pointer(ctxFormat), d))
+
 	// Check whether probe has been cancelled
-	if o.ProbeCtx != nil && o.ProbeCtx.Err() != nil {
-		err = fmt.Errorf("astilibav: probing has been cancelled: %w", o.ProbeCtx.Err())
+	if d.o.ProbeCtx != nil && d.o.ProbeCtx.Err() != nil {
+		err = fmt.Errorf("astilibav: probing has been cancelled: %w", d.o.ProbeCtx.Err())
 		return
 	}
 
 	// Retrieve stream information
 	if ret := d.ctxFormat.AvformatFindStreamInfo(nil); ret < 0 {
-		err = fmt.Errorf("astilibav: ctxFormat.AvformatFindStreamInfo on %+v failed: %w", o, NewAvError(ret))
+		err = fmt.Errorf("astilibav: ctxFormat.AvformatFindStreamInfo on %+v failed: %w", d.o, NewAvError(ret))
 		return
 	}
 
 	// Check whether probe has been cancelled
-	if o.ProbeCtx != nil && o.ProbeCtx.Err() != nil {
-		err = fmt.Errorf("astilibav: probing has been cancelled: %w", o.ProbeCtx.Err())
+	if d.o.ProbeCtx != nil && d.o.ProbeCtx.Err() != nil {
+		err = fmt.Errorf("astilibav: probing has been cancelled: %w", d.o.ProbeCtx.Err())
 		return
 	}
 
+	// Store byte size and position
+	atomic.StoreInt64(&d.byteSize, avIOSize(d.ctxFormat))
+	atomic.StoreInt64(&d.bytePosition, avIOPosition(d.ctxFormat))
+
+	// Get selected streams
+	var selected map[int]bool
+	if d.o.StreamIndexes != nil {
+		selected = make(map[int]bool)
+		for _, idx := range d.o.StreamIndexes {
+			if idx < 0 || idx >= len(d.ctxFormat.Streams()) {
+				err = fmt.Errorf("astilibav: no stream with index %d", idx)
+				return
+			}
+			selected[idx] = true
+		}
+	}
+
 	// Index streams
+	d.ss = make(map[int]*demuxerStream)
 	for _, s := range d.ctxFormat.Streams() {
-		d.ss[s.Index()] = &demuxerStream{
-			ctx: NewContextFromStream(s),
-			s:   s,
+		// Packets of streams that are not selected are discarded by libav
+		if selected != nil && !selected[s.Index()] {
+			SetStreamDiscard(s, DiscardAll)
+			continue
+		}
+		d.ss[s.Index()] = newDemuxerStream(s, d.o.StreamOverrides[s.Index()])
+	}
+
+	// Set discard levels
+	for idx, v := range d.o.StreamDiscards {
+		if err = d.setStreamDiscard(idx, v); err != nil {
+			err = fmt.Errorf("astilibav: setting discard of stream %d failed: %w", idx, err)
+			return
 		}
 	}
 	return
 }
 
+func (d *Demuxer) probeDict() *Dict {
+	var ss []string
+	if d.o.AnalyzeDuration > 0 {
+		ss = append(ss, fmt.Sprintf("analyzeduration=%d", d.o.AnalyzeDuration))
+	}
+	if d.o.ProbeSize > 0 {
+		ss = append(ss, fmt.Sprintf("probesize=%d", d.o.ProbeSize))
+	}
+	if len(ss) == 0 {
+		return nil
+	}
+	return NewDefaultDict(strings.Join(ss, ","))
+}
+
+func newDemuxerStream(s *avformat.Stream, o DemuxerStreamOverride) (ds *demuxerStream) {
+	// Create demuxer stream
+	ds = &demuxerStream{
+		ctx: NewContextFromStream(s),
+		d:   s,
+		s:   s,
+	}
+
+	// Override frame rate
+	if o.FrameRate.Num() > 0 && o.FrameRate.Den() > 0 {
+		ds.ctx.FrameRate = o.FrameRate
+		ds.frameRateOverridden = true
+	}
+
+	// Override time base
+	if o.TimeBase.Num() > 0 && o.TimeBase.Den() > 0 {
+		ds.ctx.TimeBase = o.TimeBase
+		ds.d = demuxerStreamDescriptor{timeBase: o.TimeBase}
+	}
+	return
+}
+
+// StreamCtx returns the context of the stream with the specified index, overrides included
+func (d *Demuxer) StreamCtx(idx int) (Context, bool) {
+	d.ms.Lock()
+	defer d.ms.Unlock()
+	s, ok := d.ss[idx]
+	if !ok {
+		return Context{}, false
+	}
+	return s.ctx, true
+}
+
+// Streams returns the contexts of demuxed streams, overrides included, ordered by stream index
+func (d *Demuxer) Streams() (cs []Context) {
+	d.ms.Lock()
+	defer d.ms.Unlock()
+	for _, s := range d.ctxFormat.Streams() {
+		if ds, ok := d.ss[s.Index()]; ok {
+			cs = append(cs, ds.ctx)
+		}
+	}
+	return
+}
+
+// StreamByMetadata returns the first demuxed stream, ordered by index, whose metadata has the specified value for the
+// specified key (e.g. "language" and "eng")
+func (d *Demuxer) StreamByMetadata(key, value string) (*avformat.Stream, bool) {
+	d.ms.Lock()
+	defer d.ms.Unlock()
+	for _, s := range d.ctxFormat.Streams() {
+		if _, ok := d.ss[s.Index()]; !ok {
+			continue
+		}
+		if v, ok := StreamMetadata(s)[key]; ok && v == value {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// StreamsByCodecType returns the demuxed streams of the specified codec type, ordered by index
+func (d *Demuxer) StreamsByCodecType(t avcodec.MediaType) (ss []*avformat.Stream) {
+	d.ms.Lock()
+	defer d.ms.Unlock()
+	for _, s := range d.ctxFormat.Streams() {
+		if _, ok := d.ss[s.Index()]; ok && s.CodecParameters().CodecType() == t {
+			ss = append(ss, s)
+		}
+	}
+	return
+}
+
+// reopenInput closes the current input, running its close funcs, before opening a new one.
+// The input is closed even if opening the new one fails midway so that nothing is leaked.
+func (d *Demuxer) reopenInput() (err error) {
+	// Lock since streams are swapped
+	d.ms.Lock()
+	defer d.ms.Unlock()
+
+	// Close input
+	if err = d.ci.Close(); err != nil {
+		err = fmt.Errorf("astilibav: closing input failed: %w", err)
+		return
+	}
+
+	// Open input
+	if err = d.openInput(); err != nil {
+		// Make sure what has been opened is closed
+		if errClose := d.ci.Close(); errClose != nil {
+			d.eh.Emit(astiencoder.EventError(d, fmt.Errorf("astilibav: closing input failed: %w", errClose)))
+		}
+		err = fmt.Errorf("astilibav: opening input failed: %w", err)
+		return
+	}
+	return
+}
+
+// SetStreamDiscard sets the discard level of the stream with the specified index
+func (d *Demuxer) SetStreamDiscard(idx int, v Discard) error {
+	d.ms.Lock()
+	defer d.ms.Unlock()
+	return d.setStreamDiscard(idx, v)
+}
+
+// setStreamDiscard must be called with ms locked or while the input is being opened
+func (d *Demuxer) setStreamDiscard(idx int, v Discard) error {
+	s, ok := d.ss[idx]
+	if !ok {
+		return fmt.Errorf("astilibav: no stream with index %d", idx)
+	}
+	SetStreamDiscard(s.s, v)
+	return nil
+}
+
 func (d *Demuxer) addStats() {
 	// Get stats
 	ss := d.d.stats()
-	ss = append(ss, astikit.StatOptions{
-		Handler: d.statIncomingRate,
-		Metadata: &astikit.StatMetadata{
-			Description: "Number of bits going in per second",
-			Label:       "Incoming rate",
-			Name:        StatNameIncomingRate,
-			Unit:        "bps",
+	ss = append(ss,
+		astikit.StatOptions{
+			Handler: d.statIncomingRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of bits going in per second",
+				Label:       "Incoming rate",
+				Name:        StatNameIncomingRate,
+				Unit:        "bps",
+			},
 		},
-	})
+		astikit.StatOptions{
+			Handler: d.statPktSizeAvg,
+			Metadata: &astikit.StatMetadata{
+				Description: "Average size of packets going in",
+				Label:       "Average packet size",
+				Name:        StatNameAveragePktSize,
+				Unit:        "B",
+			},
+		},
+	)
 
 	// Add stats
 	d.BaseNode.AddStats(ss...)
@@ -231,6 +564,7 @@ func (d *Demuxer) ConnectForStream(h PktHandler, i *avformat.Stream) {
 }
 
 // DisconnectForStream disconnects the demuxer from a PktHandler for a specific stream
+// Use DrainHandler afterwards to wait for the handler to process pkts already dispatched to it
 func (d *Demuxer) DisconnectForStream(h PktHandler, i *avformat.Stream) {
 	// Delete handler
 	d.d.delHandler(newPktCond(i, h))
@@ -249,8 +583,25 @@ func (d *Demuxer) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 			*d.interruptRet = 1
 		}()
 
+		// Reset flags
+		atomic.StoreUint32(&d.eofEmitted, 0)
+		atomic.StoreUint32(&d.readTimedOut, 0)
+
+		// Handle read timeout
+		if d.o.ReadTimeout > 0 {
+			d.readTimer = time.AfterFunc(d.o.ReadTimeout, d.readTimeout)
+			d.stopReadTimer()
+			defer func() {
+				d.stopReadTimer()
+				d.readTimer = nil
+			}()
+		}
+
 		// Loop
 		for {
+			// Seek
+			d.applySeek()
+
 			// Read frame
 			if stop := d.readFrame(ctx); stop {
 				return
@@ -273,24 +624,27 @@ func (d *Demuxer) readFrame(ctx context.Context) (stop bool) {
 	defer d.p.put(pkt)
 
 	// Read frame
-	if ret := d.ctxFormat.AvReadFrame(pkt); ret < 0 {
-		if ret != avutil.AVERROR_EOF || !d.loop {
-			if ret != avutil.AVERROR_EOF {
-				emitAvError(d, d.eh, ret, "ctxFormat.AvReadFrame on %s failed", d.ctxFormat.Filename())
-			}
-			stop = true
-		} else {
-			// Seek to start
-			if ret = d.ctxFormat.AvSeekFrame(-1, d.ctxFormat.StartTime(), avformat.AVSEEK_FLAG_BACKWARD); ret < 0 {
-				emitAvError(d, d.eh, ret, "ctxFormat.AvSeekFrame on %s failed", d.ctxFormat.Filename())
-				stop = true
-			}
+	if d.readTimer != nil {
+		d.readTimer.Reset(d.o.ReadTimeout)
+	}
+	ret := d.ctxFormat.AvReadFrame(pkt)
+	if d.readTimer != nil {
+		// The read has succeeded, a timeout that fired meanwhile must not interrupt the next one
+		if !d.readTimer.Stop() && ret >= 0 {
+			d.resetReadTimeout()
 		}
+	}
+	if ret < 0 {
+		stop = d.handleReadError(ctx, ret)
 		return
 	}
 
-	// Increment incoming rate
+	// Update throughput stats
 	d.statIncomingRate.Add(float64(pkt.Size() * 8))
+	d.statPktSizeAvg.Add(float64(pkt.Size()))
+
+	// Store byte position
+	atomic.StoreInt64(&d.bytePosition, avIOPosition(d.ctxFormat))
 
 	// Get stream
 	s, ok := d.ss[pkt.StreamIndex()]
@@ -298,6 +652,11 @@ func (d *Demuxer) readFrame(ctx context.Context) (stop bool) {
 		return
 	}
 
+	// Store read timestamp
+	if pkt.Dts() != avutil.AV_NOPTS_VALUE {
+		atomic.StoreInt64(&d.readTimestamp, avutil.AvRescaleQ(pkt.Dts(), s.ctx.TimeBase, nanosecondRational))
+	}
+
 	// Restamp
 	if d.restamper != nil {
 		d.restamper.Restamp(pkt)
@@ -307,23 +666,291 @@ func (d *Demuxer) readFrame(ctx context.Context) (stop bool) {
 	if d.emulateRate {
 		// Sleep until next at
 		if !s.emulateRateNextAt.IsZero() {
-			if delta := time.Until(s.emulateRateNextAt); delta > 0 {
-				astikit.Sleep(ctx, delta)
+			if delta := s.emulateRateNextAt.Sub(d.clock.Now()); delta > 0 {
+				d.clock.Sleep(ctx, delta)
 			}
 		} else {
-			s.emulateRateNextAt = time.Now()
+			s.emulateRateNextAt = d.clock.Now()
 		}
 
 		// Compute next at
-		s.emulateRateNextAt = s.emulateRateNextAt.Add(time.Duration(avutil.AvRescaleQ(d.emulateRatePktDuration(pkt, s.ctx), s.s.TimeBase(), nanosecondRational)))
+		// All streams share the same speed so that they stay in sync
+		s.emulateRateNextAt = s.emulateRateNextAt.Add(time.Duration(float64(avutil.AvRescaleQ(d.emulateRatePktDuration(pkt, s), s.ctx.TimeBase, nanosecondRational)) / d.EmulateRateSpeed()))
 	}
 
 	// Dispatch pkt
-	d.d.dispatch(pkt, s.s)
+	d.d.dispatch(pkt, s.d)
+
+	// Notify first dispatched pkt
+	d.fdn.notify(pkt.Pts(), s.ctx.TimeBase)
 	return
 }
 
-func (d *Demuxer) emulateRatePktDuration(pkt *avcodec.Packet, ctx Context) int64 {
+// DemuxerSeek represents a seek applied by the demuxer
+type DemuxerSeek struct {
+	Flags       int
+	StreamIndex int
+	// Timestamp in the stream time base or in AV_TIME_BASE if stream index is -1
+	Timestamp int64
+}
+
+func (d *Demuxer) handleReadError(ctx context.Context, ret int) (stop bool) {
+	// Emit error
+	if atomic.LoadUint32(&d.readTimedOut) > 0 {
+		d.eh.Emit(astiencoder.Event{
+			Name:    EventNameDemuxerReadTimeout,
+			Payload: d.o.ReadTimeout,
+			Target:  d,
+		})
+		d.eh.Emit(astiencoder.EventError(d, fmt.Errorf("astilibav: reading %s failed: %w", d.ctxFormat.Filename(), ErrDemuxerReadTimeout)))
+	} else if ret != avutil.AVERROR_EOF {
+		emitAvError(d, d.eh, ret, "ctxFormat.AvReadFrame on %s failed", d.ctxFormat.Filename())
+	} else if !d.loop || (d.loopCount > 0 && d.loops+1 >= d.loopCount) {
+		// Notify EOF
+		if *d.interruptRet == 0 && atomic.CompareAndSwapUint32(&d.eofEmitted, 0, 1) {
+			d.eh.Emit(astiencoder.Event{
+				Name:    EventNameDemuxerEOF,
+				Payload: d.Metadata().Name,
+				Target:  d,
+			})
+		}
+		return true
+	} else {
+		// Seek to start
+		if ret = d.ctxFormat.AvSeekFrame(-1, d.ctxFormat.StartTime(), avformat.AVSEEK_FLAG_BACKWARD); ret < 0 {
+			emitAvError(d, d.eh, ret, "ctxFormat.AvSeekFrame on %s failed", d.ctxFormat.Filename())
+			return true
+		}
+
+		// Increment loops
+		d.loops++
+		return false
+	}
+
+	// Reconnect
+	if d.o.Reconnect.MaxAttempts > 0 && d.o.Reader == nil && !d.cancelled() {
+		if err := d.reconnect(ctx); err != nil {
+			d.eh.Emit(astiencoder.EventError(d, fmt.Errorf("astilibav: reconnecting to %s failed: %w", d.o.URL, err)))
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+func (d *Demuxer) cancelled() bool {
+	ctx := d.Context()
+	return ctx != nil && ctx.Err() != nil
+}
+
+// reconnect closes the input and opens it again until it succeeds or max attempts are reached
+func (d *Demuxer) reconnect(ctx context.Context) (err error) {
+	// Loop
+	r := d.o.Reconnect
+	backoff := r.Backoff
+	for attempt := 1; ; attempt++ {
+		// Reopen input
+		if err = d.reopenInput(); err == nil {
+			// Reset read timeout
+			atomic.StoreUint32(&d.readTimedOut, 0)
+
+			// The new input must be interrupted as well if the demuxer has been stopped meanwhile
+			if d.cancelled() {
+				*d.interruptRet = 1
+			}
+		}
+
+		// Send attempt event
+		d.eh.Emit(astiencoder.Event{
+			Name: EventNameDemuxerReconnectAttempt,
+			Payload: DemuxerReconnectAttempt{
+				Attempt:     attempt,
+				Err:         err,
+				MaxAttempts: r.MaxAttempts,
+				URL:         d.o.URL,
+			},
+			Target: d,
+		})
+
+		// Success or no more attempts
+		if err == nil || attempt >= r.MaxAttempts {
+			return
+		}
+
+		// Sleep
+		if errSleep := astikit.Sleep(ctx, backoff); errSleep != nil {
+			err = fmt.Errorf("astilibav: reconnecting to %s has been cancelled: %w", d.o.URL, errSleep)
+			return
+		}
+
+		// Increase backoff
+		if backoff *= 2; r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+			backoff = r.MaxBackoff
+		}
+	}
+}
+
+// Seek makes the demuxer jump to the specified timestamp of the stream with the specified index before reading
+// its next packet. If the stream index is -1, the timestamp is relative to the input start. Flags are
+// avformat.AVSEEK_FLAG_* flags.
+// The seek is applied by the read loop, which prevents racing AvReadFrame, therefore it's applied on resume
+// when the demuxer is paused. Only the last requested seek is applied and failures are emitted as errors
+func (d *Demuxer) Seek(t time.Duration, streamIndex int, flags int) error {
+	// Lock
+	d.ms.Lock()
+	defer d.ms.Unlock()
+
+	// Get time base
+	tb := avutil.AV_TIME_BASE_Q
+	if streamIndex >= 0 {
+		s, ok := d.ss[streamIndex]
+		if !ok {
+			return fmt.Errorf("astilibav: no stream with index %d", streamIndex)
+		}
+		tb = s.s.TimeBase()
+	}
+	return d.seekTimestamp(streamIndex, avutil.AvRescaleQ(t.Nanoseconds(), nanosecondRational, tb), flags)
+}
+
+// SeekTimestamp is the same as Seek but the timestamp is expressed in the stream time base,
+// or in AV_TIME_BASE if the stream index is -1.
+// Once the seek has been applied, EventNameDemuxerSeeked is emitted before the next packet is dispatched
+func (d *Demuxer) SeekTimestamp(streamIndex int, ts int64, flags int) error {
+	d.ms.Lock()
+	defer d.ms.Unlock()
+	return d.seekTimestamp(streamIndex, ts, flags)
+}
+
+// seekTimestamp must be called with ms locked
+func (d *Demuxer) seekTimestamp(streamIndex int, ts int64, flags int) error {
+	// Check stream
+	if _, ok := d.ss[streamIndex]; !ok && streamIndex >= 0 {
+		return fmt.Errorf("astilibav: no stream with index %d", streamIndex)
+	}
+
+	// Store seek
+	d.seek = &DemuxerSeek{
+		Flags:       flags,
+		StreamIndex: streamIndex,
+		Timestamp:   ts,
+	}
+	return nil
+}
+
+func (d *Demuxer) applySeek() {
+	// Get seek
+	d.ms.Lock()
+	sk := d.seek
+	d.seek = nil
+	d.ms.Unlock()
+
+	// No seek
+	if sk == nil {
+		return
+	}
+
+	// Seek
+	if ret := d.ctxFormat.AvSeekFrame(sk.StreamIndex, sk.Timestamp, sk.Flags); ret < 0 {
+		emitAvError(d, d.eh, ret, "ctxFormat.AvSeekFrame on %s failed", d.ctxFormat.Filename())
+		return
+	}
+
+	// Emulated rate must start over from the new position. Timestamps of restamped packets keep
+	// increasing since the restamper relies on packet durations
+	for _, s := range d.ss {
+		s.emulateRateNextAt = time.Time{}
+	}
+
+	// Packets are dispatched synchronously therefore none is in-flight in the dispatcher. Handlers are notified
+	// before the next packet is dispatched so that they can flush their buffers (see Decoder.Flush)
+	d.eh.Emit(astiencoder.Event{
+		Name:    EventNameDemuxerSeeked,
+		Payload: *sk,
+		Target:  d,
+	})
+}
+
+// EmulateRateSpeed returns the speed multiplier used to emulate rate
+func (d *Demuxer) EmulateRateSpeed() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&d.emulateRateSpeed))
+}
+
+// SetEmulateRateSpeed updates the speed multiplier used to emulate rate while the demuxer is running.
+// It applies from the next packet on
+func (d *Demuxer) SetEmulateRateSpeed(v float64) error {
+	if v <= 0 {
+		return fmt.Errorf("astilibav: invalid emulate rate speed %f", v)
+	}
+	atomic.StoreUint64(&d.emulateRateSpeed, math.Float64bits(v))
+	return nil
+}
+
+// Duration returns the input duration detected when probing. It's 0 if it's unknown, which is usually
+// the case for live inputs
+func (d *Demuxer) Duration() time.Duration {
+	v := d.ctxFormat.Duration()
+	if v == avutil.AV_NOPTS_VALUE || v < 0 {
+		return 0
+	}
+	return time.Duration(avutil.AvRescaleQ(v, avutil.AV_TIME_BASE_Q, nanosecondRational))
+}
+
+// BitRate returns the input bit rate in bits per second detected when probing. It's 0 if it's unknown
+func (d *Demuxer) BitRate() int64 {
+	if v := int64(d.ctxFormat.BitRate()); v > 0 {
+		return v
+	}
+	return 0
+}
+
+// readTimeout is called by the read timer when reading a packet takes too long
+func (d *Demuxer) readTimeout() {
+	atomic.StoreUint32(&d.readTimedOut, 1)
+	*d.interruptRet = 1
+}
+
+// stopReadTimer stops the read timer and, if it has already fired, resets what it has set
+func (d *Demuxer) stopReadTimer() {
+	if !d.readTimer.Stop() {
+		d.resetReadTimeout()
+	}
+}
+
+func (d *Demuxer) resetReadTimeout() {
+	atomic.StoreUint32(&d.readTimedOut, 0)
+	if !d.cancelled() {
+		*d.interruptRet = 0
+	}
+}
+
+// BytePosition returns the current byte position in the input or -1 if it's unknown
+func (d *Demuxer) BytePosition() int64 {
+	return atomic.LoadInt64(&d.bytePosition)
+}
+
+// ByteSize returns the input size in bytes or -1 if it's unknown (e.g. live streams).
+// Combined with BytePosition, it allows computing a progress for inputs without reliable duration
+func (d *Demuxer) ByteSize() int64 {
+	return atomic.LoadInt64(&d.byteSize)
+}
+
+// ReadTimestamp returns the dts of the last packet read
+func (d *Demuxer) ReadTimestamp() time.Duration {
+	return time.Duration(atomic.LoadInt64(&d.readTimestamp))
+}
+
+// FirstPktDispatched returns a chan closed once the first packet has been dispatched
+func (d *Demuxer) FirstPktDispatched() <-chan struct{} {
+	return d.fdn.c
+}
+
+// FirstPkt returns the first dispatched packet, if any
+func (d *Demuxer) FirstPkt() (FirstDispatched, bool) {
+	return d.fdn.first()
+}
+
+func (d *Demuxer) emulateRatePktDuration(pkt *avcodec.Packet, s *demuxerStream) int64 {
+	ctx := s.ctx
 	switch ctx.CodecType {
 	case avutil.AVMEDIA_TYPE_AUDIO:
 		// Get skip samples side data
@@ -335,6 +962,12 @@ func (d *Demuxer) emulateRatePktDuration(pkt *avcodec.Packet, ctx Context) int64
 		// Substract number of samples
 		skipStart, skipEnd := avutil.AV_RL32(sd, 0), avutil.AV_RL32(sd, 4)
 		return pkt.Duration() - avutil.AvRescaleQ(int64(float64(skipStart+skipEnd)/float64(ctx.SampleRate)*1e9), nanosecondRational, ctx.TimeBase)
+	case avutil.AVMEDIA_TYPE_VIDEO:
+		// Overridden frame rate is more reliable than the pkt duration
+		if s.frameRateOverridden {
+			return avutil.AvRescaleQ(int64(1e9/ctx.FrameRate.ToDouble()), nanosecondRational, ctx.TimeBase)
+		}
+		return pkt.Duration()
 	default:
 		return pkt.Duration()
 	}
@@ -0,0 +1,177 @@
+package astilibav
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDemuxerEmulateRate(t *testing.T) {
+	// Create
+	c := astikit.NewCloser()
+	defer c.Close()
+	cl := newTestClock()
+	d, err := NewDemuxer(DemuxerOptions{
+		Clock:       cl,
+		EmulateRate: true,
+		URL:         "../examples/sample.mp4",
+	}, astiencoder.NewEventHandler(), c, nil)
+	require.NoError(t, err)
+
+	// Read all packets
+	for {
+		if stop := d.readFrame(context.Background()); stop {
+			break
+		}
+	}
+
+	// Virtual time should match the input duration
+	assert.InDelta(t, d.CtxFormat().Duration()/1e6, cl.now.Unix(), 1)
+}
+
+func TestDemuxerEmulateRateSpeed(t *testing.T) {
+	// Invalid speed
+	c := astikit.NewCloser()
+	defer c.Close()
+	_, err := NewDemuxer(DemuxerOptions{
+		EmulateRate:      true,
+		EmulateRateSpeed: -1,
+		URL:              "../examples/sample.mp4",
+	}, astiencoder.NewEventHandler(), c, nil)
+	assert.Error(t, err)
+
+	// Create
+	cl := newTestClock()
+	d, err := NewDemuxer(DemuxerOptions{
+		Clock:            cl,
+		EmulateRate:      true,
+		EmulateRateSpeed: 2,
+		URL:              "../examples/sample.mp4",
+	}, astiencoder.NewEventHandler(), c, nil)
+	require.NoError(t, err)
+	assert.Error(t, d.SetEmulateRateSpeed(0))
+	assert.Equal(t, 2.0, d.EmulateRateSpeed())
+
+	// Read all packets
+	for {
+		if stop := d.readFrame(context.Background()); stop {
+			break
+		}
+	}
+
+	// Virtual time should match half the input duration
+	assert.InDelta(t, d.CtxFormat().Duration()/2e6, cl.now.Unix(), 1)
+}
+
+func TestDemuxerReader(t *testing.T) {
+	// Open file
+	f, err := os.Open("../examples/sample.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+
+	// URL and reader are mutually exclusive
+	c := astikit.NewCloser()
+	defer c.Close()
+	_, err = NewDemuxer(DemuxerOptions{
+		Reader: f,
+		URL:    "../examples/sample.mp4",
+	}, astiencoder.NewEventHandler(), c, nil)
+	assert.Error(t, err)
+
+	// Create
+	d, err := NewDemuxer(DemuxerOptions{
+		Reader:           f,
+		ReaderBufferSize: 4096,
+	}, astiencoder.NewEventHandler(), c, nil)
+	require.NoError(t, err)
+	assert.Len(t, d.Streams(), len(d.CtxFormat().Streams()))
+	assert.True(t, d.Duration() > 0)
+
+	// Read all packets
+	var count int
+	for {
+		if stop := d.readFrame(context.Background()); stop {
+			break
+		}
+		count++
+	}
+	assert.True(t, count > 0)
+}
+
+func TestDemuxerReopenInput(t *testing.T) {
+	// Create
+	c := astikit.NewCloser()
+	defer c.Close()
+	d, err := NewDemuxer(DemuxerOptions{URL: "../examples/sample.mp4"}, astiencoder.NewEventHandler(), c, nil)
+	require.NoError(t, err)
+
+	// Count how many times the input close funcs are run
+	var count int
+	d.ci.Add(func() error {
+		count++
+		return nil
+	})
+
+	// Reopen many times
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.reopenInput())
+	}
+
+	// Close funcs of previous inputs shouldn't have been kept
+	assert.Equal(t, 1, count)
+
+	// Input should still be readable
+	assert.False(t, d.readFrame(context.Background()))
+
+	// Last input should be closed properly
+	assert.NoError(t, c.Close())
+	assert.Equal(t, 1, count)
+}
+
+func TestDemuxerStreamOverrides(t *testing.T) {
+	// Get video stream
+	c := astikit.NewCloser()
+	defer c.Close()
+	d, err := NewDemuxer(DemuxerOptions{URL: "../examples/sample.mp4"}, astiencoder.NewEventHandler(), c, nil)
+	require.NoError(t, err)
+	idx := -1
+	var fr avutil.Rational
+	for _, s := range d.CtxFormat().Streams() {
+		if s.CodecParameters().CodecType() == avutil.AVMEDIA_TYPE_VIDEO {
+			ctx, ok := d.StreamCtx(s.Index())
+			require.True(t, ok)
+			idx, fr = s.Index(), ctx.FrameRate
+		}
+	}
+	require.NotEqual(t, -1, idx)
+
+	// Create with half the frame rate
+	cl := newTestClock()
+	hfr := avutil.NewRational(fr.Num(), 2*fr.Den())
+	d, err = NewDemuxer(DemuxerOptions{
+		Clock:           cl,
+		EmulateRate:     true,
+		StreamOverrides: map[int]DemuxerStreamOverride{idx: {FrameRate: hfr}},
+		URL:             "../examples/sample.mp4",
+	}, astiencoder.NewEventHandler(), c, nil)
+	require.NoError(t, err)
+	ctx, ok := d.StreamCtx(idx)
+	require.True(t, ok)
+	assert.Equal(t, hfr, ctx.FrameRate)
+
+	// Read all packets
+	for {
+		if stop := d.readFrame(context.Background()); stop {
+			break
+		}
+	}
+
+	// Virtual time should match twice the input duration
+	assert.InDelta(t, 2*d.CtxFormat().Duration()/1e6, cl.now.Unix(), 1)
+}
@@ -7,6 +7,7 @@ import (
 )
 
 type Dict struct {
+	defaults  *Dict
 	flags     int
 	i         string
 	keyValSep string
@@ -30,7 +31,28 @@ func NewDefaultDictf(format string, args ...interface{}) *Dict {
 	return NewDict(fmt.Sprintf(format, args...), "=", ",", 0)
 }
 
+// NewDictWithDefaults creates a dict whose pairs override the ones of defaults. It returns defaults if d is nil
+// which allows sharing options between several nodes unless they're overridden
+func NewDictWithDefaults(defaults, d *Dict) *Dict {
+	if d == nil {
+		return defaults
+	}
+	if defaults == nil {
+		return d
+	}
+	c := *d
+	c.defaults = defaults
+	return &c
+}
+
 func (d *Dict) Parse(i **avutil.Dictionary) (err error) {
+	// Parse defaults first so that they're overridden
+	if d.defaults != nil {
+		if err = d.defaults.Parse(i); err != nil {
+			return
+		}
+	}
+
 	if ret := avutil.AvDictParseString(i, d.i, d.keyValSep, d.pairsSep, d.flags); ret < 0 {
 		err = fmt.Errorf("astilibav: avutil.AvDictParseString on %s failed: %w", d.i, NewAvError(ret))
 		return
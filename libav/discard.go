@@ -0,0 +1,30 @@
+package astilibav
+
+import (
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avformat"
+)
+
+// Discard represents a level of packets the demuxer discards for a stream
+type Discard int
+
+// Discard levels
+const (
+	DiscardNone     = Discard(avcodec.AVDISCARD_NONE)
+	DiscardDefault  = Discard(avcodec.AVDISCARD_DEFAULT)
+	DiscardNonRef   = Discard(avcodec.AVDISCARD_NONREF)
+	DiscardBidir    = Discard(avcodec.AVDISCARD_BIDIR)
+	DiscardNonIntra = Discard(avcodec.AVDISCARD_NONINTRA)
+	DiscardNonKey   = Discard(avcodec.AVDISCARD_NONKEY)
+	DiscardAll      = Discard(avcodec.AVDISCARD_ALL)
+)
+
+// StreamDiscard returns the discard level of the stream
+func StreamDiscard(s *avformat.Stream) Discard {
+	return Discard(s.Discard())
+}
+
+// SetStreamDiscard sets the discard level of the stream which is honored by AvReadFrame
+func SetStreamDiscard(s *avformat.Stream, d Discard) {
+	setStreamDiscard(s, d)
+}
@@ -0,0 +1,46 @@
+package astilibav
+
+import (
+	"context"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+)
+
+// Drainer represents a handler that can wait for items already dispatched to it to be processed
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// DrainHandler waits for the handler to process items already dispatched to it or for the context to be done.
+// Since Disconnect methods wait for in-flight dispatches to be done, calling it right after disconnecting
+// a handler guarantees it won't receive any new item so that it can safely be stopped and freed.
+// It returns immediately if the handler processes items synchronously
+func DrainHandler(ctx context.Context, h astiencoder.Node) error {
+	if d, ok := h.(Drainer); ok {
+		return d.Drain(ctx)
+	}
+	return nil
+}
+
+func drainChan(ctx context.Context, n *astiencoder.BaseNode, c *astikit.Chan) error {
+	// Items of stopped nodes are either processed when stopping, or will be when starting
+	if n.Status() == astiencoder.StatusStopped {
+		return nil
+	}
+
+	// Add marker after items already in the chan
+	done := make(chan struct{})
+	c.Add(func() { close(done) })
+
+	// Wait
+	select {
+	case <-done:
+		return nil
+	case <-n.Context().Done():
+		// Remaining items are processed before the node stops
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
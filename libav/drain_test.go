@@ -0,0 +1,46 @@
+package astilibav
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrainHandler(t *testing.T) {
+	// Create
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	f, err := NewForwarder(ForwarderOptions{}, eh, c, nil)
+	require.NoError(t, err)
+
+	// Stopped nodes are not waited for
+	assert.NoError(t, DrainHandler(context.Background(), f))
+
+	// Start
+	w := astikit.NewWorker(astikit.WorkerOptions{})
+	defer w.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f.Start(ctx, w.NewTask)
+
+	// Items already added are processed
+	var processed bool
+	f.c.Add(func() {
+		time.Sleep(50 * time.Millisecond)
+		processed = true
+	})
+	assert.NoError(t, DrainHandler(context.Background(), f))
+	assert.True(t, processed)
+
+	// Context is taken into account
+	f.c.Add(func() { time.Sleep(time.Second) })
+	dctx, dcancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer dcancel()
+	assert.Equal(t, context.DeadlineExceeded, DrainHandler(dctx, f))
+}
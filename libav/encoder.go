@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"sync/atomic"
+	"unsafe"
 
 	"github.com/asticode/go-astiencoder"
 	"github.com/asticode/go-astikit"
@@ -19,20 +20,70 @@ var countEncoder uint64
 type Encoder struct {
 	*astiencoder.BaseNode
 	c                  *astikit.Chan
+	cdc                *avcodec.Codec
 	ctxCodec           *avcodec.Context
 	d                  *pktDispatcher
+	dict               *Dict
 	eh                 *astiencoder.EventHandler
 	fp                 *framePool
+	hdr                HDRMetadata
+	lookaheadDict      *Dict
+	lowLatencyDict     *Dict
 	pp                 *pktPool
 	previousDescriptor Descriptor
+	resizePolicy       EncoderResizePolicy
+	rotation           float64
+	sendOptions        CodecSendOptions
+	statAverageQP      *astikit.CounterAvgStat
+	statBacklog        *codecBacklogStat
+	statBacklogDur     *codecBacklogDurationStat
+	statBitRate        *astikit.CounterRateStat
+	statEAGAINRate     *astikit.CounterRateStat
 	statIncomingRate   *astikit.CounterRateStat
+	statIntervals      *ptsIntervalStats
 	statProcessedRate  *astikit.CounterRateStat
 }
 
+// EncoderResizePolicy represents what happens when the dimensions of incoming frames change
+type EncoderResizePolicy string
+
+// Encoder resize policies
+const (
+	// Frames whose dimensions differ from the encoder ones are dropped and an error is emitted
+	EncoderResizePolicyError EncoderResizePolicy = "error"
+	// The encoder is drained and its codec is reopened with the new dimensions. The next frame starts a new GOP.
+	// Streams that have already been added to a muxer are not updated, therefore it should only be used with
+	// formats handling in-band parameter changes (e.g. mpegts) and without global headers
+	EncoderResizePolicyReopen EncoderResizePolicy = "reopen"
+)
+
+// EncoderResize represents an encoder resize
+type EncoderResize struct {
+	FromHeight int
+	FromWidth  int
+	ToHeight   int
+	ToWidth    int
+}
+
 // EncoderOptions represents encoder options
 type EncoderOptions struct {
-	Ctx  Context
-	Node astiencoder.NodeOptions
+	// Max number of b-frames between non b-frames
+	BFrames *int
+	Ctx     Context
+	// When true, codec-appropriate low latency settings are applied: low delay flag, no b-frames, one second GOP
+	// and private options such as "tune=zerolatency" for libx264. Ctx.GopSize and Ctx.Dict take precedence
+	LowLatency bool
+	// Number of frames the rate control looks ahead. It's mapped to the codec private option
+	// and is only supported by encoders listed in encoderLookaheadOptionNames
+	Lookahead *int
+	Node      astiencoder.NodeOptions
+	// Number of reference frames
+	RefFrames *int
+	// What happens when the dimensions of incoming frames change. See constants with pattern EncoderResizePolicy*
+	// Default is EncoderResizePolicyError
+	ResizePolicy EncoderResizePolicy
+	// Options used when the encoder returns EAGAIN
+	Send CodecSendOptions
 }
 
 // NewEncoder creates a new encoder
@@ -44,10 +95,21 @@ func NewEncoder(o EncoderOptions, eh *astiencoder.EventHandler, c *astikit.Close
 	// Create encoder
 	e = &Encoder{
 		c:                 astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
+		dict:              o.Ctx.Dict,
 		eh:                eh,
 		fp:                newFramePool(c),
+		hdr:               o.Ctx.HDR,
 		pp:                newPktPool(c),
+		resizePolicy:      o.ResizePolicy,
+		rotation:          o.Ctx.Rotation,
+		sendOptions:       o.Send.withDefaults(),
+		statAverageQP:     astikit.NewCounterAvgStat(),
+		statBacklog:       newCodecBacklogStat(),
+		statBacklogDur:    newCodecBacklogDurationStat(),
+		statBitRate:       astikit.NewCounterRateStat(),
+		statEAGAINRate:    astikit.NewCounterRateStat(),
 		statIncomingRate:  astikit.NewCounterRateStat(),
+		statIntervals:     newPTSIntervalStats("frames encoded"),
 		statProcessedRate: astikit.NewCounterRateStat(),
 	}
 
@@ -60,15 +122,24 @@ func NewEncoder(o EncoderOptions, eh *astiencoder.EventHandler, c *astikit.Close
 	// Add stats
 	e.addStats()
 
+	// Check resize policy
+	switch e.resizePolicy {
+	case "":
+		e.resizePolicy = EncoderResizePolicyError
+	case EncoderResizePolicyError, EncoderResizePolicyReopen:
+	default:
+		err = fmt.Errorf("astilibav: invalid resize policy %s", e.resizePolicy)
+		return
+	}
+
 	// Find encoder
-	var cdc *avcodec.Codec
 	if len(o.Ctx.CodecName) > 0 {
-		if cdc = avcodec.AvcodecFindEncoderByName(o.Ctx.CodecName); cdc == nil {
+		if e.cdc = avcodec.AvcodecFindEncoderByName(o.Ctx.CodecName); e.cdc == nil {
 			err = fmt.Errorf("astilibav: no encoder with name %s", o.Ctx.CodecName)
 			return
 		}
 	} else if o.Ctx.CodecID > 0 {
-		if cdc = avcodec.AvcodecFindEncoder(o.Ctx.CodecID); cdc == nil {
+		if e.cdc = avcodec.AvcodecFindEncoder(o.Ctx.CodecID); e.cdc == nil {
 			err = fmt.Errorf("astilibav: no encoder with id %+v", o.Ctx.CodecID)
 			return
 		}
@@ -78,13 +149,13 @@ func NewEncoder(o EncoderOptions, eh *astiencoder.EventHandler, c *astikit.Close
 	}
 
 	// Check whether the context is valid with the codec
-	if err = o.Ctx.validWithCodec(cdc); err != nil {
+	if err = o.Ctx.validWithCodec(e.cdc); err != nil {
 		err = fmt.Errorf("astilibav: checking whether the context is valid with the codec failed: %w", err)
 		return
 	}
 
 	// Alloc context
-	if e.ctxCodec = cdc.AvcodecAllocContext3(); e.ctxCodec == nil {
+	if e.ctxCodec = e.cdc.AvcodecAllocContext3(); e.ctxCodec == nil {
 		err = errors.New("astilibav: no context allocated")
 		return
 	}
@@ -114,27 +185,33 @@ func NewEncoder(o EncoderOptions, eh *astiencoder.EventHandler, c *astikit.Close
 		e.ctxCodec.SetSampleAspectRatio(o.Ctx.SampleAspectRatio)
 		e.ctxCodec.SetTimeBase(o.Ctx.TimeBase)
 		e.ctxCodec.SetWidth(o.Ctx.Width)
+		if o.Ctx.Color != nil {
+			setCodecContextColorProperties(e.ctxCodec, *o.Ctx.Color)
+		}
 	default:
 		err = fmt.Errorf("astilibav: encoder doesn't handle %v codec type", o.Ctx.CodecType)
 		return
 	}
 
-	// Dict
-	var dict *avutil.Dictionary
-	if o.Ctx.Dict != nil {
-		// Parse dict
-		if err = o.Ctx.Dict.Parse(&dict); err != nil {
-			err = fmt.Errorf("astilibav: parsing dict failed: %w", err)
-			return
-		}
+	// Apply low latency preset
+	if o.LowLatency {
+		applyLowLatency(e.ctxCodec, o.Ctx)
+		e.lowLatencyDict = lowLatencyDict(e.cdc)
+	}
+
+	// Apply frame options
+	applyFrameOptions(e.ctxCodec, o)
 
-		// Make sure the dict is freed
-		defer avutil.AvDictFree(&dict)
+	// Get lookahead dict
+	var ok bool
+	if e.lookaheadDict, ok = lookaheadDict(e.cdc, o.Lookahead); !ok {
+		err = fmt.Errorf("astilibav: lookahead is not supported by encoder %s", codecName(e.cdc))
+		return
 	}
 
 	// Open codec
-	if ret := e.ctxCodec.AvcodecOpen2(cdc, &dict); ret < 0 {
-		err = fmt.Errorf("astilibav: d.e.ctxCodec.AvcodecOpen2 failed: %w", NewAvError(ret))
+	if err = e.openCodec(); err != nil {
+		err = fmt.Errorf("astilibav: opening codec failed: %w", err)
 		return
 	}
 
@@ -145,6 +222,33 @@ func NewEncoder(o EncoderOptions, eh *astiencoder.EventHandler, c *astikit.Close
 		}
 		return nil
 	})
+
+	// Attribute logs
+	c.Add(logParents.add(unsafe.Pointer(e.ctxCodec), e))
+	return
+}
+
+func (e *Encoder) openCodec() (err error) {
+	// Dict
+	var dict *avutil.Dictionary
+	defer avutil.AvDictFree(&dict)
+
+	// Parse dicts. Later dicts override earlier ones so that explicit options take precedence
+	for _, d := range []*Dict{e.lowLatencyDict, e.lookaheadDict, e.dict} {
+		if d == nil {
+			continue
+		}
+		if err = d.Parse(&dict); err != nil {
+			err = fmt.Errorf("astilibav: parsing dict failed: %w", err)
+			return
+		}
+	}
+
+	// Open codec
+	if ret := e.ctxCodec.AvcodecOpen2(e.cdc, &dict); ret < 0 {
+		err = fmt.Errorf("astilibav: d.e.ctxCodec.AvcodecOpen2 failed: %w", NewAvError(ret))
+		return
+	}
 	return
 }
 
@@ -152,7 +256,52 @@ func (e *Encoder) addStats() {
 	// Get stats
 	ss := e.c.Stats()
 	ss = append(ss, e.d.stats()...)
+	ss = append(ss, e.statIntervals.stats()...)
 	ss = append(ss,
+		astikit.StatOptions{
+			Handler: e.statAverageQP,
+			Metadata: &astikit.StatMetadata{
+				Description: "Average quantizer of packets going out, when reported by the codec",
+				Label:       "Average QP",
+				Name:        StatNameAverageQP,
+			},
+		},
+		astikit.StatOptions{
+			Handler: e.statBacklog,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames sent to the codec minus number of packets received from it",
+				Label:       "Backlog",
+				Name:        StatNameBacklog,
+				Unit:        "frames",
+			},
+		},
+		astikit.StatOptions{
+			Handler: e.statBacklogDur,
+			Metadata: &astikit.StatMetadata{
+				Description: "Duration between the latest frame sent to the codec and the latest packet received from it",
+				Label:       "Backlog duration",
+				Name:        StatNameBacklogDuration,
+				Unit:        "ms",
+			},
+		},
+		astikit.StatOptions{
+			Handler: e.statBitRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of bits going out per second",
+				Label:       "Bit rate",
+				Name:        StatNameBitRate,
+				Unit:        "bps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: e.statEAGAINRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of times per second the codec returned EAGAIN when sending it frames",
+				Label:       "EAGAIN rate",
+				Name:        StatNameEAGAINRate,
+				Unit:        "fps",
+			},
+		},
 		astikit.StatOptions{
 			Handler: e.statIncomingRate,
 			Metadata: &astikit.StatMetadata{
@@ -195,6 +344,11 @@ func (e *Encoder) Disconnect(h PktHandler) {
 	astiencoder.DisconnectNodes(e, h)
 }
 
+// Drain implements the Drainer interface
+func (e *Encoder) Drain(ctx context.Context) error {
+	return drainChan(ctx, e.BaseNode, e.c)
+}
+
 // Start starts the encoder
 func (e *Encoder) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 	e.BaseNode.Start(ctx, t, func(t *astikit.Task) {
@@ -213,6 +367,92 @@ func (e *Encoder) flush() {
 	e.encode(nil, nil)
 }
 
+// ForceGOPReset drains the encoder and reopens its codec with the same parameters so that the next
+// frame starts a new GOP. It happens once frames handled before the call have been encoded.
+func (e *Encoder) ForceGOPReset() {
+	e.c.Add(func() {
+		// Handle pause
+		defer e.HandlePause()
+
+		// Reopen codec
+		if err := e.reopenCodec(nil); err != nil {
+			e.eh.Emit(astiencoder.EventError(e, fmt.Errorf("astilibav: reopening codec failed: %w", err)))
+			return
+		}
+
+		// Emit event
+		e.eh.Emit(astiencoder.Event{
+			Name:   EventNameEncoderGOPReset,
+			Target: e,
+		})
+	})
+}
+
+// reopenCodec drains the encoder, closes its codec, executes fn if not nil and opens its codec again
+func (e *Encoder) reopenCodec(fn func()) (err error) {
+	// Drain buffered pkts
+	e.flush()
+
+	// Close codec
+	if ret := e.ctxCodec.AvcodecClose(); ret < 0 {
+		err = fmt.Errorf("astilibav: e.ctxCodec.AvcodecClose failed: %w", NewAvError(ret))
+		return
+	}
+
+	// Custom
+	if fn != nil {
+		fn()
+	}
+
+	// Open codec
+	if err = e.openCodec(); err != nil {
+		err = fmt.Errorf("astilibav: opening codec failed: %w", err)
+		return
+	}
+	return
+}
+
+// handleResize checks whether the frame dimensions differ from the encoder ones and applies the resize policy.
+// It returns false if the frame must not be encoded
+func (e *Encoder) handleResize(f *avutil.Frame) bool {
+	// Dimensions are the same
+	if e.ctxCodec.CodecType() != avutil.AVMEDIA_TYPE_VIDEO || (f.Width() == e.ctxCodec.Width() && f.Height() == e.ctxCodec.Height()) {
+		return true
+	}
+
+	// Create payload
+	r := EncoderResize{
+		FromHeight: e.ctxCodec.Height(),
+		FromWidth:  e.ctxCodec.Width(),
+		ToHeight:   f.Height(),
+		ToWidth:    f.Width(),
+	}
+
+	// Switch on policy
+	switch e.resizePolicy {
+	case EncoderResizePolicyReopen:
+		// Reopen codec with new dimensions
+		if err := e.reopenCodec(func() {
+			e.ctxCodec.SetHeight(f.Height())
+			e.ctxCodec.SetWidth(f.Width())
+		}); err != nil {
+			emitThrottledError(e, e.eh, fmt.Errorf("astilibav: reopening codec from %dx%d to %dx%d failed: %w", r.FromWidth, r.FromHeight, r.ToWidth, r.ToHeight, err))
+			return false
+		}
+
+		// Emit event
+		e.eh.Emit(astiencoder.Event{
+			Name:    EventNameEncoderResized,
+			Payload: r,
+			Target:  e,
+		})
+		return true
+	default:
+		emitThrottledError(e, e.eh, fmt.Errorf("astilibav: frame is %dx%d whereas encoder is %dx%d, use EncoderResizePolicyReopen to handle resolution changes", r.ToWidth, r.ToHeight, r.FromWidth, r.FromHeight))
+		return false
+	}
+}
+
 // HandleFrame implements the FrameHandler interface
 func (e *Encoder) HandleFrame(p FrameHandlerPayload) {
 	// Increment incoming rate
@@ -221,6 +461,7 @@ func (e *Encoder) HandleFrame(p FrameHandlerPayload) {
 	// Copy frame
 	f := e.fp.get()
 	if ret := avutil.AvFrameRef(f, p.Frame); ret < 0 {
+		e.fp.put(f)
 		emitAvError(e, e.eh, ret, "avutil.AvFrameRef failed")
 		return
 	}
@@ -236,6 +477,22 @@ func (e *Encoder) HandleFrame(p FrameHandlerPayload) {
 		// Increment processed rate
 		e.statProcessedRate.Add(1)
 
+		// Acquire quota
+		release, err := e.AcquireQuota()
+		if err != nil {
+			e.eh.Emit(astiencoder.EventError(e, fmt.Errorf("astilibav: acquiring quota failed: %w", err)))
+			return
+		}
+		defer release()
+
+		// Update interval stats
+		e.statIntervals.add(f.Pts(), p.Descriptor.TimeBase())
+
+		// Handle resize
+		if ok := e.handleResize(f); !ok {
+			return
+		}
+
 		// Encode
 		e.encode(f, p.Descriptor)
 	})
@@ -251,18 +508,29 @@ func (e *Encoder) encode(f *avutil.Frame, d Descriptor) {
 		}
 	}
 
-	// Send frame to encoder
-	if ret := avcodec.AvcodecSendFrame(e.ctxCodec, f); ret < 0 {
+	// Send frame to encoder and receive pkts
+	// The encoder may be flushed once its context is done, hence the background context
+	if ret := sendToCodec(context.Background(), e.sendOptions, e.statEAGAINRate, func() (ret int) {
+		if ret = avcodec.AvcodecSendFrame(e.ctxCodec, f); ret >= 0 && f != nil {
+			e.statBacklog.addSent()
+			e.statBacklogDur.addSent(f.Pts(), d.TimeBase())
+		}
+		return
+	}, func() int {
+		return e.receivePkts(d)
+	}); ret < 0 {
 		emitAvError(e, e.eh, ret, "avcodec.AvcodecSendFrame failed")
 		return
 	}
+}
 
-	// Loop
+func (e *Encoder) receivePkts(d Descriptor) (received int) {
 	for {
 		// Receive pkt
 		if stop := e.receivePkt(d); stop {
 			return
 		}
+		received++
 	}
 }
 
@@ -280,6 +548,12 @@ func (e *Encoder) receivePkt(d Descriptor) (stop bool) {
 		return
 	}
 
+	// Update rate control stats
+	e.statBitRate.Add(float64(pkt.Size() * 8))
+	if qp, ok := pktQP(pkt); ok {
+		e.statAverageQP.Add(qp)
+	}
+
 	// Get descriptor
 	if d == nil && e.previousDescriptor == nil {
 		e.eh.Emit(astiencoder.EventError(e, errors.New("astilibav: no valid descriptor")))
@@ -290,6 +564,10 @@ func (e *Encoder) receivePkt(d Descriptor) (stop bool) {
 		e.previousDescriptor = d
 	}
 
+	// Update backlog
+	e.statBacklog.addReceived()
+	e.statBacklogDur.addReceived(pkt.Pts(), d.TimeBase())
+
 	// Set pkt duration based on framerate
 	if f := e.ctxCodec.Framerate(); f.Num() > 0 {
 		pkt.SetDuration(avutil.AvRescaleQ(int64(1e9/f.ToDouble()), nanosecondRational, d.TimeBase()))
@@ -304,7 +582,15 @@ func (e *Encoder) receivePkt(d Descriptor) (stop bool) {
 }
 
 // AddStream adds a stream based on the codec ctx
+// It fails if the output format requires global headers but the encoder has been opened without
+// AV_CODEC_FLAG_GLOBAL_HEADER since the flag can't be set once the codec is opened
 func (e *Encoder) AddStream(ctxFormat *avformat.Context) (o *avformat.Stream, err error) {
+	// Check global header
+	if FormatRequiresGlobalHeader(ctxFormat) && e.ctxCodec.Flags()&avcodec.AV_CODEC_FLAG_GLOBAL_HEADER == 0 {
+		err = fmt.Errorf("astilibav: output format of %s requires global headers but encoder has been opened without them, set Context.GlobalHeader to true", ctxFormat.Filename())
+		return
+	}
+
 	// Add stream
 	o = AddStream(ctxFormat)
 
@@ -316,6 +602,18 @@ func (e *Encoder) AddStream(ctxFormat *avformat.Context) (o *avformat.Stream, er
 
 	// Set other attributes
 	o.SetTimeBase(e.ctxCodec.TimeBase())
+
+	// Set rotation
+	if err = SetStreamRotation(o, e.rotation); err != nil {
+		err = fmt.Errorf("astilibav: setting stream rotation failed: %w", err)
+		return
+	}
+
+	// Set HDR metadata
+	if err = SetStreamHDRMetadata(o, e.hdr); err != nil {
+		err = fmt.Errorf("astilibav: setting stream hdr metadata failed: %w", err)
+		return
+	}
 	return
 }
 
@@ -0,0 +1,48 @@
+package astilibav
+
+import (
+	"math"
+
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+)
+
+// Private options applied by the low latency preset, indexed by encoder name
+var encoderLowLatencyDicts = map[string]string{
+	"h264_nvenc": "zerolatency=1,delay=0,rc-lookahead=0,rc=cbr",
+	"hevc_nvenc": "zerolatency=1,delay=0,rc-lookahead=0,rc=cbr",
+	"libaom-av1": "usage=realtime,lag-in-frames=0",
+	"libopus":    "application=lowdelay",
+	"libvpx":     "deadline=realtime,lag-in-frames=0",
+	"libvpx-vp9": "deadline=realtime,lag-in-frames=0",
+	"libx264":    "tune=zerolatency",
+	"libx265":    "tune=zerolatency",
+}
+
+// lowLatencyDict returns the private options of the low latency preset or nil if the encoder has none
+func lowLatencyDict(cdc *avcodec.Codec) *Dict {
+	if v, ok := encoderLowLatencyDicts[codecName(cdc)]; ok {
+		return NewDefaultDict(v)
+	}
+	return nil
+}
+
+// applyLowLatency sets the codec context parameters of the low latency preset. Explicit context options take
+// precedence
+func applyLowLatency(ctxCodec *avcodec.Context, ctx Context) {
+	// Flags
+	ctxCodec.SetFlags(ctxCodec.Flags() | avCodecFlagLowDelay)
+
+	// Video
+	if ctx.CodecType != avutil.AVMEDIA_TYPE_VIDEO {
+		return
+	}
+
+	// No b-frames
+	ctxCodec.SetMaxBFrames(0)
+
+	// One second GOP
+	if ctx.GopSize <= 0 && ctx.FrameRate.Num() > 0 && ctx.FrameRate.Den() > 0 {
+		ctxCodec.SetGopSize(int(math.Ceil(ctx.FrameRate.ToDouble())))
+	}
+}
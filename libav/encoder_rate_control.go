@@ -0,0 +1,51 @@
+package astilibav
+
+import (
+	"encoding/binary"
+	"strconv"
+
+	"github.com/asticode/goav/avcodec"
+)
+
+// Private option names of the lookahead, indexed by encoder name
+var encoderLookaheadOptionNames = map[string]string{
+	"h264_nvenc": "rc-lookahead",
+	"hevc_nvenc": "rc-lookahead",
+	"libaom-av1": "lag-in-frames",
+	"libvpx":     "lag-in-frames",
+	"libvpx-vp9": "lag-in-frames",
+	"libx264":    "rc-lookahead",
+	"libx265":    "rc-lookahead",
+}
+
+// applyFrameOptions sets the number of b-frames and reference frames
+func applyFrameOptions(ctxCodec *avcodec.Context, o EncoderOptions) {
+	if o.BFrames != nil {
+		ctxCodec.SetMaxBFrames(*o.BFrames)
+	}
+	if o.RefFrames != nil {
+		setCodecContextRefs(ctxCodec, *o.RefFrames)
+	}
+}
+
+// lookaheadDict returns the private option setting the lookahead or nil if it's not set
+func lookaheadDict(cdc *avcodec.Codec, lookahead *int) (d *Dict, ok bool) {
+	if lookahead == nil {
+		return nil, true
+	}
+	n, ok := encoderLookaheadOptionNames[codecName(cdc)]
+	if !ok {
+		return nil, false
+	}
+	return NewDefaultDict(n + "=" + strconv.Itoa(*lookahead)), true
+}
+
+// pktQP returns the quantizer of the pkt based on its quality stats side data
+func pktQP(pkt *avcodec.Packet) (qp float64, ok bool) {
+	sd := packetSideData(pkt, avPktDataQualityStats)
+	if len(sd) < 4 {
+		return
+	}
+	// Quality is the first field and is stored as a little-endian uint32
+	return float64(binary.LittleEndian.Uint32(sd)) / ffQP2Lambda, true
+}
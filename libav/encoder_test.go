@@ -0,0 +1,60 @@
+package astilibav
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoderGlobalHeader(t *testing.T) {
+	// Create temp dir
+	dir, err := ioutil.TempDir("", "astilibav-encoder-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Create closer and event handler
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+
+	// Create muxer
+	m, err := NewMuxer(MuxerOptions{
+		FormatName: "mp4",
+		URL:        filepath.Join(dir, "output.mp4"),
+	}, eh, c, nil)
+	require.NoError(t, err)
+	assert.True(t, m.GlobalHeader())
+
+	// Encoder without global header
+	ctx := Context{
+		CodecID:     avcodec.AV_CODEC_ID_MPEG4,
+		CodecType:   avutil.AVMEDIA_TYPE_VIDEO,
+		FrameRate:   avutil.NewRational(25, 1),
+		Height:      16,
+		PixelFormat: avutil.AV_PIX_FMT_YUV420P,
+		TimeBase:    avutil.NewRational(1, 25),
+		Width:       16,
+	}
+	e, err := NewEncoder(EncoderOptions{Ctx: ctx}, eh, c, nil)
+	require.NoError(t, err)
+	_, err = e.AddStream(m.CtxFormat())
+	assert.Error(t, err)
+
+	// Encoder with global header
+	ctx.GlobalHeader = true
+	e, err = NewEncoder(EncoderOptions{Ctx: ctx}, eh, c, nil)
+	require.NoError(t, err)
+	s, err := e.AddStream(m.CtxFormat())
+	require.NoError(t, err)
+
+	// Extradata is in the header
+	assert.True(t, codecParametersExtradataSize(s.CodecParameters()) > 0)
+}
@@ -1,7 +1,10 @@
 package astilibav
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/asticode/go-astiencoder"
 	"github.com/asticode/goav/avutil"
@@ -30,5 +33,124 @@ func NewAvError(ret int) AvError {
 }
 
 func emitAvError(target interface{}, eh *astiencoder.EventHandler, ret int, format string, args ...interface{}) {
-	eh.Emit(astiencoder.EventError(target, fmt.Errorf("astilibav: "+format+": %w", append(args, NewAvError(ret))...)))
+	emitThrottledError(target, eh, fmt.Errorf("astilibav: "+format+": %w", append(args, NewAvError(ret))...))
+}
+
+// emitThrottledError should be used for errors that may be emitted for every pkt or frame
+func emitThrottledError(target interface{}, eh *astiencoder.EventHandler, err error) {
+	avErrors.emit(target, eh, err)
+}
+
+var avErrors = newAvErrorThrottler(RealClock, time.Second)
+
+// avErrorThrottler prevents a node failing in a tight loop from flooding the event handler: only the first
+// occurrence of an error is emitted within a window, and the number of occurrences that have been suppressed
+// is emitted once the window ends
+type avErrorThrottler struct {
+	c      Clock
+	es     map[string]*throttledAvError
+	m      *sync.Mutex // Locks es
+	window time.Duration
+}
+
+type throttledAvError struct {
+	at         time.Time
+	eh         *astiencoder.EventHandler
+	err        error
+	flushing   bool
+	suppressed int
+	target     interface{}
+}
+
+func newAvErrorThrottler(c Clock, window time.Duration) *avErrorThrottler {
+	return &avErrorThrottler{
+		c:      c,
+		es:     make(map[string]*throttledAvError),
+		m:      &sync.Mutex{},
+		window: window,
+	}
+}
+
+func (t *avErrorThrottler) emit(target interface{}, eh *astiencoder.EventHandler, err error) {
+	// Lock
+	t.m.Lock()
+
+	// Identical errors emitted by the same target are collapsed
+	key := fmt.Sprintf("%p|%s", target, err)
+	now := t.c.Now()
+	e, exists := t.es[key]
+	if exists && now.Sub(e.at) < t.window {
+		// Suppress
+		e.suppressed++
+
+		// Make sure the number of suppressed errors is emitted once the window ends
+		if !e.flushing {
+			e.flushing = true
+			go func(d time.Duration) {
+				t.c.Sleep(context.Background(), d)
+				t.flush(key, e)
+			}(e.at.Add(t.window).Sub(now))
+		}
+		t.m.Unlock()
+		return
+	}
+
+	// Purge stale errors so that errors with variable messages don't make the map grow forever
+	if !exists && len(t.es) >= 1000 {
+		for k, v := range t.es {
+			if !v.flushing && now.Sub(v.at) >= t.window {
+				delete(t.es, k)
+			}
+		}
+	}
+
+	// The previous window has ended but hasn't been flushed yet
+	var suppressed int
+	if exists {
+		suppressed = e.suppressed
+		e.suppressed = 0
+	}
+
+	// Store
+	t.es[key] = &throttledAvError{
+		at:     now,
+		eh:     eh,
+		err:    err,
+		target: target,
+	}
+
+	// Unlock
+	t.m.Unlock()
+
+	// Emit
+	if suppressed > 0 {
+		emitSuppressedAvErrors(e, suppressed)
+	}
+	eh.Emit(astiencoder.EventError(target, err))
+}
+
+func (t *avErrorThrottler) flush(key string, e *throttledAvError) {
+	// Lock
+	t.m.Lock()
+
+	// Get number of suppressed errors
+	suppressed := e.suppressed
+	e.suppressed = 0
+
+	// The error is not being throttled anymore
+	if v, ok := t.es[key]; ok && v == e {
+		delete(t.es, key)
+	}
+
+	// Unlock
+	t.m.Unlock()
+
+	// Emit
+	if suppressed > 0 {
+		emitSuppressedAvErrors(e, suppressed)
+	}
+}
+
+func emitSuppressedAvErrors(e *throttledAvError, suppressed int) {
+	e.eh.Emit(astiencoder.EventError(e.target, fmt.Errorf("%w (%d identical errors have been suppressed)", e.err, suppressed)))
 }
@@ -1,8 +1,11 @@
 package astilibav
 
 import (
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/asticode/go-astiencoder"
 	"github.com/asticode/goav/avutil"
 	"github.com/stretchr/testify/assert"
 )
@@ -13,3 +16,37 @@ func TestAvError(t *testing.T) {
 	assert.False(t, err.Is(NewAvError(avutil.AVERROR_EOF)))
 	assert.True(t, err.Is(NewAvError(avutil.AVERROR_EPIPE)))
 }
+
+func TestAvErrorThrottler(t *testing.T) {
+	c := newTestClock()
+	c.sleeps = make(chan time.Duration, 1)
+	c.wake = make(chan struct{})
+	th := newAvErrorThrottler(c, time.Second)
+	eh := astiencoder.NewEventHandler()
+	errs := make(chan string, 10)
+	eh.AddForEventName(astiencoder.EventNameError, func(e astiencoder.Event) bool {
+		errs <- e.Payload.(error).Error()
+		return false
+	})
+
+	// Only the first occurrence is emitted
+	th.emit(th, eh, errors.New("a"))
+	assert.Equal(t, "a", <-errs)
+	c.now = c.now.Add(500 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		th.emit(th, eh, errors.New("a"))
+	}
+	assert.Equal(t, 500*time.Millisecond, <-c.sleeps)
+	th.emit(th, eh, errors.New("b"))
+	assert.Equal(t, "b", <-errs)
+	assert.Len(t, errs, 0)
+
+	// Suppressed occurrences are emitted once the window ends
+	c.now = c.now.Add(500 * time.Millisecond)
+	c.wake <- struct{}{}
+	assert.Equal(t, "a (3 identical errors have been suppressed)", <-errs)
+
+	// Next occurrence is emitted
+	th.emit(th, eh, errors.New("a"))
+	assert.Equal(t, "a", <-errs)
+}
@@ -2,7 +2,41 @@ package astilibav
 
 // Event names
 const (
-	EventNameLog = "astilibav.log"
+	// First frame has been dispatched by the decoder. Payload is a FirstDispatched
+	EventNameDecoderFirstFrameDispatched = "astilibav.decoder.first.frame.dispatched"
+	// The end of the input has been reached and the demuxer is stopping. It is emitted once per start and not
+	// when the demuxer stops because of an error or a cancellation. Payload is the node name
+	EventNameDemuxerEOF = "astilibav.demuxer.eof"
+	// First packet has been dispatched by the demuxer. Payload is a FirstDispatched
+	EventNameDemuxerFirstPktDispatched = "astilibav.demuxer.first.pkt.dispatched"
+	// Reading a packet has taken longer than DemuxerOptions.ReadTimeout and the demuxer is stopping.
+	// Payload is the read timeout
+	EventNameDemuxerReadTimeout = "astilibav.demuxer.read.timeout"
+	// The demuxer has tried to reopen its input after a read failure. Payload is a DemuxerReconnectAttempt
+	EventNameDemuxerReconnectAttempt = "astilibav.demuxer.reconnect.attempt"
+	// The demuxer has jumped to a new position. Payload is a DemuxerSeek
+	EventNameDemuxerSeeked = "astilibav.demuxer.seeked"
+	// The encoder has been drained and reopened after ForceGOPReset has been called
+	EventNameEncoderGOPReset = "astilibav.encoder.gop.reset"
+	// The encoder has been drained and reopened with new dimensions. Payload is an EncoderResize
+	EventNameEncoderResized = "astilibav.encoder.resized"
+	EventNameLog            = "astilibav.log"
+	// The trailer has been written successfully. Payload is a MuxerClosed
+	EventNameMuxerClosed = "astilibav.muxer.closed"
+	// Packets of a stream added after the header has been written have been received by the muxer and are dropped.
+	// Payload is the stream index
+	EventNameMuxerLateStream = "astilibav.muxer.late.stream"
+	// The muxer has tried to open its output. Payload is a MuxerOpenAttempt
+	EventNameMuxerOpenAttempt = "astilibav.muxer.open.attempt"
+	// The muxer paused queue has reached its max size. Payload is the max size
+	EventNameMuxerPausedQueueFull = "astilibav.muxer.paused.queue.full"
+	// A segment has been closed by the muxer. Payload is a MuxerSegment
+	EventNameMuxerSegmentClosed = "astilibav.muxer.segment.closed"
+	// A stream time base requested with SetStreamTimeBase has been changed when writing the header.
+	// Payload is a MuxerTimeBaseMismatch
+	EventNameMuxerTimeBaseMismatch = "astilibav.muxer.time.base.mismatch"
+	// Output has been verified by the muxer. Payload is a MuxerVerification
+	EventNameMuxerVerified = "astilibav.muxer.verified"
 	// First packet of new node has been received by the rate enforcer
 	EventNameRateEnforcerSwitchedIn = "astilibav.rate.enforcer.switched.in"
 	// First packet of new node has been dispatched by the rate enforcer
@@ -11,10 +45,27 @@ const (
 
 // Stat names
 const (
-	StatNameAverageDelay  = "astilibav.average.delay"
-	StatNameFilledRate    = "astilibav.filled.rate"
-	StatNameIncomingRate  = "astilibav.incoming.rate"
-	StatNameOutgoingRate  = "astilibav.outgoing.rate"
-	StatNameProcessedRate = "astilibav.processed.rate"
-	StatNameWorkRatio     = "astilibav.work.ratio"
+	StatNameAverageDelay      = "astilibav.average.delay"
+	StatNameAveragePktSize    = "astilibav.average.pkt.size"
+	StatNameAverageQP         = "astilibav.average.qp"
+	StatNameAverageSleep      = "astilibav.average.sleep"
+	StatNameBacklog           = "astilibav.backlog"
+	StatNameBacklogDuration   = "astilibav.backlog.duration"
+	StatNameBitRate           = "astilibav.bit.rate"
+	StatNameCorrection        = "astilibav.correction"
+	StatNameDroppedRate       = "astilibav.dropped.rate"
+	StatNameEAGAINRate        = "astilibav.eagain.rate"
+	StatNameFilledRate        = "astilibav.filled.rate"
+	StatNameIncomingFrameRate = "astilibav.incoming.frame.rate"
+	StatNameIncomingPktRate   = "astilibav.incoming.pkt.rate"
+	StatNameIncomingRate      = "astilibav.incoming.rate"
+	StatNameIntervalJitter    = "astilibav.interval.jitter"
+	StatNameMaxInterval       = "astilibav.max.interval"
+	StatNameMeanInterval      = "astilibav.mean.interval"
+	StatNameMeanLuma          = "astilibav.mean.luma"
+	StatNameMinInterval       = "astilibav.min.interval"
+	StatNameOutgoingRate      = "astilibav.outgoing.rate"
+	StatNamePausedRatio       = "astilibav.paused.ratio"
+	StatNameProcessedRate     = "astilibav.processed.rate"
+	StatNameWorkRatio         = "astilibav.work.ratio"
 )
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -24,11 +25,14 @@ type Filterer struct {
 	bufferSinkCtx     *avfilter.Context
 	bufferSrcCtxs     map[astiencoder.Node][]*avfilter.Context
 	c                 *astikit.Chan
+	clock             Clock
 	cl                *astikit.Closer
+	cmds              []FiltererCommand
 	d                 *frameDispatcher
 	eh                *astiencoder.EventHandler
 	emulatePeriod     time.Duration
 	g                 *avfilter.Graph
+	mc                *sync.Mutex // Locks cmds
 	outputCtx         Context
 	p                 *framePool
 	restamper         FrameRestamper
@@ -38,6 +42,8 @@ type Filterer struct {
 
 // FiltererOptions represents filterer options
 type FiltererOptions struct {
+	// Clock used to emulate rate. Default is RealClock
+	Clock       Clock
 	Content     string
 	EmulateRate avutil.Rational
 	Inputs      map[string]astiencoder.Node
@@ -57,8 +63,10 @@ func NewFilterer(o FiltererOptions, eh *astiencoder.EventHandler, c *astikit.Clo
 		bufferSrcCtxs:     make(map[astiencoder.Node][]*avfilter.Context),
 		c:                 astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
 		cl:                c.NewChild(),
+		clock:             clockOrDefault(o.Clock),
 		eh:                eh,
 		g:                 avfilter.AvfilterGraphAlloc(),
+		mc:                &sync.Mutex{},
 		outputCtx:         o.OutputCtx,
 		restamper:         o.Restamper,
 		statIncomingRate:  astikit.NewCounterRateStat(),
@@ -256,12 +264,17 @@ func (f *Filterer) Disconnect(h FrameHandler) {
 	astiencoder.DisconnectNodes(f, h)
 }
 
+// Drain implements the Drainer interface
+func (f *Filterer) Drain(ctx context.Context) error {
+	return drainChan(ctx, f.BaseNode, f.c)
+}
+
 // Start starts the filterer
 func (f *Filterer) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 	f.BaseNode.Start(ctx, t, func(t *astikit.Task) {
 		// In case there are no inputs, we emulate frames coming in
 		if len(f.bufferSrcCtxs) == 0 {
-			nextAt := time.Now()
+			nextAt := f.clock.Now()
 			desc := newFiltererDescriptor(f.bufferSinkCtx, nil)
 			for {
 				if stop := f.tickFunc(&nextAt, desc); stop {
@@ -284,8 +297,8 @@ func (f *Filterer) tickFunc(nextAt *time.Time, desc Descriptor) (stop bool) {
 	*nextAt = nextAt.Add(f.emulatePeriod)
 
 	// Sleep until next at
-	if delta := time.Until(*nextAt); delta > 0 {
-		astikit.Sleep(f.Context(), delta)
+	if delta := nextAt.Sub(f.clock.Now()); delta > 0 {
+		f.clock.Sleep(f.Context(), delta)
 	}
 
 	// Check context
@@ -294,6 +307,9 @@ func (f *Filterer) tickFunc(nextAt *time.Time, desc Descriptor) (stop bool) {
 		return
 	}
 
+	// Apply queued commands
+	f.applyCommands()
+
 	// Pull filtered frame
 	f.pullFilteredFrame(desc)
 	return
@@ -307,6 +323,7 @@ func (f *Filterer) HandleFrame(p FrameHandlerPayload) {
 	// Copy frame
 	fm := f.p.get()
 	if ret := avutil.AvFrameRef(fm, p.Frame); ret < 0 {
+		f.p.put(fm)
 		emitAvError(f, f.eh, ret, "avutil.AvFrameRef failed")
 		return
 	}
@@ -322,6 +339,9 @@ func (f *Filterer) HandleFrame(p FrameHandlerPayload) {
 		// Increment processed rate
 		f.statProcessedRate.Add(1)
 
+		// Apply queued commands
+		f.applyCommands()
+
 		// Retrieve buffer ctxs
 		bufferSrcCtxs, ok := f.bufferSrcCtxs[p.Node]
 		if !ok {
@@ -381,6 +401,51 @@ func (f *Filterer) SendCommand(target, cmd, arg string, flags int) (err error) {
 	return
 }
 
+// FiltererCommand represents a command sent to a filter of the graph
+type FiltererCommand struct {
+	Arg   string
+	Cmd   string
+	Flags int
+	// Filter instance name or class name, "all" targets every filter of the graph
+	Target string
+}
+
+// QueueCommand queues a command that will be sent to the graph before the next frame is processed.
+// Contrary to SendCommand, it can be called from any goroutine while frames are being filtered.
+// If a command with the same target and cmd is already queued, it's replaced so that only the
+// latest value is applied
+func (f *Filterer) QueueCommand(c FiltererCommand) {
+	// Lock
+	f.mc.Lock()
+	defer f.mc.Unlock()
+
+	// Replace queued command
+	for idx, v := range f.cmds {
+		if v.Target == c.Target && v.Cmd == c.Cmd {
+			f.cmds[idx] = c
+			return
+		}
+	}
+
+	// Append command
+	f.cmds = append(f.cmds, c)
+}
+
+func (f *Filterer) applyCommands() {
+	// Get queued commands
+	f.mc.Lock()
+	cmds := f.cmds
+	f.cmds = nil
+	f.mc.Unlock()
+
+	// Loop through commands
+	for _, c := range cmds {
+		if err := f.SendCommand(c.Target, c.Cmd, c.Arg, c.Flags); err != nil {
+			f.eh.Emit(astiencoder.EventError(f, fmt.Errorf("astilibav: sending command failed: %w", err)))
+		}
+	}
+}
+
 type filtererDescriptor struct {
 	timeBase avutil.Rational
 }
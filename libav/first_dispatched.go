@@ -0,0 +1,67 @@
+package astilibav
+
+import (
+	"sync"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avutil"
+)
+
+// FirstDispatched represents the first packet or frame dispatched by a node
+type FirstDispatched struct {
+	At       time.Time
+	Pts      int64
+	TimeBase avutil.Rational
+}
+
+type firstDispatchedNotifier struct {
+	c         chan struct{}
+	clock     Clock
+	eh        *astiencoder.EventHandler
+	eventName string
+	o         *sync.Once
+	target    interface{}
+	v         FirstDispatched
+}
+
+func newFirstDispatchedNotifier(eventName string, target interface{}, eh *astiencoder.EventHandler, c Clock) *firstDispatchedNotifier {
+	return &firstDispatchedNotifier{
+		c:         make(chan struct{}),
+		clock:     clockOrDefault(c),
+		eh:        eh,
+		eventName: eventName,
+		o:         &sync.Once{},
+		target:    target,
+	}
+}
+
+func (n *firstDispatchedNotifier) notify(pts int64, timeBase avutil.Rational) {
+	n.o.Do(func() {
+		// Store
+		n.v = FirstDispatched{
+			At:       n.clock.Now(),
+			Pts:      pts,
+			TimeBase: timeBase,
+		}
+
+		// Close chan
+		close(n.c)
+
+		// Send event
+		n.eh.Emit(astiencoder.Event{
+			Name:    n.eventName,
+			Payload: n.v,
+			Target:  n.target,
+		})
+	})
+}
+
+func (n *firstDispatchedNotifier) first() (v FirstDispatched, ok bool) {
+	select {
+	case <-n.c:
+		return n.v, true
+	default:
+		return
+	}
+}
@@ -0,0 +1,35 @@
+package astilibav
+
+import (
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirstDispatchedNotifier(t *testing.T) {
+	c := newTestClock()
+	eh := astiencoder.NewEventHandler()
+	var es []FirstDispatched
+	eh.AddForEventName("test", func(e astiencoder.Event) bool {
+		es = append(es, e.Payload.(FirstDispatched))
+		return false
+	})
+	n := newFirstDispatchedNotifier("test", nil, eh, c)
+
+	_, ok := n.first()
+	assert.False(t, ok)
+
+	c.now = time.Unix(1, 0)
+	n.notify(1, avutil.NewRational(1, 25))
+	c.now = time.Unix(2, 0)
+	n.notify(2, avutil.NewRational(1, 25))
+
+	v, ok := n.first()
+	assert.True(t, ok)
+	e := FirstDispatched{At: time.Unix(1, 0), Pts: 1, TimeBase: avutil.NewRational(1, 25)}
+	assert.Equal(t, e, v)
+	assert.Equal(t, []FirstDispatched{e}, es)
+}
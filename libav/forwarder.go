@@ -2,7 +2,9 @@ package astilibav
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"sync/atomic"
 
 	"github.com/asticode/go-astiencoder"
@@ -12,28 +14,76 @@ import (
 
 var countForwarder uint64
 
+// ForwarderDropPolicy represents what happens when the max number of frames are already queued
+type ForwarderDropPolicy string
+
+// Forwarder drop policies
+const (
+	// Frames are never dropped
+	ForwarderDropPolicyNone ForwarderDropPolicy = "none"
+	// The oldest queued frame is dropped
+	ForwarderDropPolicyOldest ForwarderDropPolicy = "oldest"
+	// The incoming frame is dropped
+	ForwarderDropPolicyNewest ForwarderDropPolicy = "newest"
+)
+
 // Forwarder represents an object capable of forwarding frames
 type Forwarder struct {
 	*astiencoder.BaseNode
 	c                 *astikit.Chan
 	d                 *frameDispatcher
+	dropPolicy        ForwarderDropPolicy
 	eh                *astiencoder.EventHandler
+	lastInterval      *int64
+	maxQueue          int
 	outputCtx         Context
 	p                 *framePool
+	queued            int64
 	restamper         FrameRestamper
+	statDroppedRate   *astikit.CounterRateStat
 	statIncomingRate  *astikit.CounterRateStat
+	statIntervals     *ptsIntervalStats
 	statProcessedRate *astikit.CounterRateStat
+	targetFrameRate   avutil.Rational
+	toDrop            int64
 }
 
 // ForwarderOptions represents forwarder options
 type ForwarderOptions struct {
+	// What happens when MaxQueue frames are already queued. See constants with pattern ForwarderDropPolicy*.
+	// Default is ForwarderDropPolicyNone
+	DropPolicy ForwarderDropPolicy
+	// Max number of queued frames. 0 means unbounded
+	MaxQueue  int
 	Node      astiencoder.NodeOptions
 	OutputCtx Context
 	Restamper FrameRestamper
+	// If set, frames whose PTS falls within the same output frame interval as the previous forwarded frame are
+	// dropped. It can't be used with audio
+	TargetFrameRate avutil.Rational
 }
 
 // NewForwarder creates a new forwarder
-func NewForwarder(o ForwarderOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (f *Forwarder) {
+func NewForwarder(o ForwarderOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (f *Forwarder, err error) {
+	// Check drop policy
+	switch o.DropPolicy {
+	case "", ForwarderDropPolicyNewest, ForwarderDropPolicyNone, ForwarderDropPolicyOldest:
+	default:
+		err = fmt.Errorf("astilibav: invalid drop policy %s", o.DropPolicy)
+		return
+	}
+
+	// Check target frame rate
+	if o.TargetFrameRate.Num() != 0 || o.TargetFrameRate.Den() != 0 {
+		if o.TargetFrameRate.Num() <= 0 || o.TargetFrameRate.Den() <= 0 {
+			err = fmt.Errorf("astilibav: invalid target frame rate %s", o.TargetFrameRate)
+			return
+		} else if o.OutputCtx.CodecType == avutil.AVMEDIA_TYPE_AUDIO {
+			err = errors.New("astilibav: target frame rate can't be used with audio")
+			return
+		}
+	}
+
 	// Extend node metadata
 	count := atomic.AddUint64(&countForwarder, uint64(1))
 	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("forwarder_%d", count), fmt.Sprintf("Forwarder #%d", count), "Forwards", "forwarder")
@@ -41,12 +91,17 @@ func NewForwarder(o ForwarderOptions, eh *astiencoder.EventHandler, c *astikit.C
 	// Create forwarder
 	f = &Forwarder{
 		c:                 astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
+		dropPolicy:        o.DropPolicy,
 		eh:                eh,
+		maxQueue:          o.MaxQueue,
 		outputCtx:         o.OutputCtx,
 		p:                 newFramePool(c),
 		restamper:         o.Restamper,
+		statDroppedRate:   astikit.NewCounterRateStat(),
 		statIncomingRate:  astikit.NewCounterRateStat(),
+		statIntervals:     newPTSIntervalStats("frames going out"),
 		statProcessedRate: astikit.NewCounterRateStat(),
+		targetFrameRate:   o.TargetFrameRate,
 	}
 
 	// Create base node
@@ -64,6 +119,7 @@ func (f *Forwarder) addStats() {
 	// Get stats
 	ss := f.c.Stats()
 	ss = append(ss, f.d.stats()...)
+	ss = append(ss, f.statIntervals.stats()...)
 	ss = append(ss,
 		astikit.StatOptions{
 			Handler: f.statIncomingRate,
@@ -83,6 +139,15 @@ func (f *Forwarder) addStats() {
 				Unit:        "fps",
 			},
 		},
+		astikit.StatOptions{
+			Handler: f.statDroppedRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames dropped per second because they couldn't be copied or queued, or to reach the target frame rate",
+				Label:       "Dropped rate",
+				Name:        StatNameDroppedRate,
+				Unit:        "fps",
+			},
+		},
 	)
 
 	// Add stats
@@ -112,6 +177,11 @@ func (f *Forwarder) Disconnect(h FrameHandler) {
 	astiencoder.DisconnectNodes(f, h)
 }
 
+// Drain implements the Drainer interface
+func (f *Forwarder) Drain(ctx context.Context) error {
+	return drainChan(ctx, f.BaseNode, f.c)
+}
+
 // Start starts the forwarder
 func (f *Forwarder) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 	f.BaseNode.Start(ctx, t, func(t *astikit.Task) {
@@ -128,14 +198,34 @@ func (f *Forwarder) HandleFrame(p FrameHandlerPayload) {
 	// Increment incoming rate
 	f.statIncomingRate.Add(1)
 
+	// Queue is full
+	var dropOldest bool
+	if f.maxQueue > 0 && atomic.LoadInt64(&f.queued)-atomic.LoadInt64(&f.toDrop) >= int64(f.maxQueue) {
+		switch f.dropPolicy {
+		case ForwarderDropPolicyNewest:
+			f.statDroppedRate.Add(1)
+			return
+		case ForwarderDropPolicyOldest:
+			dropOldest = true
+		}
+	}
+
 	// Copy frame
 	fm := f.p.get()
 	if ret := avutil.AvFrameRef(fm, p.Frame); ret < 0 {
+		f.p.put(fm)
+		f.statDroppedRate.Add(1)
 		emitAvError(f, f.eh, ret, "avutil.AvFrameRef failed")
 		return
 	}
 
+	// The oldest frame is dropped when it's processed
+	if dropOldest {
+		atomic.AddInt64(&f.toDrop, 1)
+	}
+
 	// Add to chan
+	atomic.AddInt64(&f.queued, 1)
 	f.c.Add(func() {
 		// Handle pause
 		defer f.HandlePause()
@@ -143,15 +233,75 @@ func (f *Forwarder) HandleFrame(p FrameHandlerPayload) {
 		// Make sure to close frame
 		defer f.p.put(fm)
 
+		// Update queued count
+		atomic.AddInt64(&f.queued, -1)
+
+		// Frame needs to be dropped
+		if f.dropOldest() {
+			f.statDroppedRate.Add(1)
+			return
+		}
+
 		// Increment processed rate
 		f.statProcessedRate.Add(1)
 
+		// Audio frames can't be dropped based on a target frame rate
+		if f.targetFrameRate.Num() > 0 && fm.NbSamples() > 0 {
+			f.statDroppedRate.Add(1)
+			f.eh.Emit(astiencoder.EventError(f, errors.New("astilibav: target frame rate can't be used with audio frames")))
+			return
+		}
+
+		// Frame is in the same output frame interval as the previous forwarded frame
+		if f.sameInterval(fm, p.Descriptor) {
+			f.statDroppedRate.Add(1)
+			return
+		}
+
 		// Restamp
 		if f.restamper != nil {
 			f.restamper.Restamp(fm)
 		}
 
+		// Update interval stats
+		f.statIntervals.add(fm.Pts(), p.Descriptor.TimeBase())
+
 		// Dispatch frame
 		f.d.dispatch(fm, p.Descriptor)
 	})
 }
+
+// dropOldest checks whether the frame being processed needs to be dropped because of ForwarderDropPolicyOldest
+func (f *Forwarder) dropOldest() bool {
+	for {
+		n := atomic.LoadInt64(&f.toDrop)
+		if n <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&f.toDrop, n, n-1) {
+			return true
+		}
+	}
+}
+
+// sameInterval checks whether the frame falls within the same output frame interval as the previous forwarded
+// frame, and stores its interval otherwise. If pts go backwards, the interval is reset so that frames are not
+// dropped until pts catch up
+func (f *Forwarder) sameInterval(fm *avutil.Frame, d Descriptor) bool {
+	// No target frame rate
+	if f.targetFrameRate.Num() <= 0 || fm.Pts() == avutil.AV_NOPTS_VALUE {
+		return false
+	}
+
+	// Get interval
+	i := int64(math.Floor(float64(fm.Pts()) * d.TimeBase().ToDouble() * f.targetFrameRate.ToDouble()))
+
+	// Same interval
+	if f.lastInterval != nil && i == *f.lastInterval {
+		return true
+	}
+
+	// Store interval
+	f.lastInterval = &i
+	return false
+}
@@ -0,0 +1,90 @@
+package astilibav
+
+import (
+	"context"
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwarderTargetFrameRate(t *testing.T) {
+	// Create
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	var errs int
+	eh.AddForEventName(astiencoder.EventNameError, func(e astiencoder.Event) bool {
+		errs++
+		return false
+	})
+	f, err := NewForwarder(ForwarderOptions{TargetFrameRate: avutil.NewRational(25, 1)}, eh, c, nil)
+	require.NoError(t, err)
+
+	// Connect handler
+	p := newFramePool(c)
+	h := newTestFrameHandler("test", eh, p)
+	var ptss []int64
+	h.fn = func(p FrameHandlerPayload) { ptss = append(ptss, p.Frame.Pts()) }
+	f.Connect(h)
+
+	// Start
+	w := astikit.NewWorker(astikit.WorkerOptions{})
+	defer w.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f.Start(ctx, w.NewTask)
+
+	// Handle video frames
+	vctx := Context{
+		CodecType:   avutil.AVMEDIA_TYPE_VIDEO,
+		Height:      2,
+		PixelFormat: avutil.AV_PIX_FMT_YUV420P,
+		Width:       2,
+	}
+	for _, pts := range []int64{
+		0,
+		// Same interval
+		20,
+		40,
+		// Same interval
+		60,
+		80,
+		// Pts go backwards
+		0,
+		20,
+		40,
+	} {
+		p.setBufferCtx(vctx)
+		fm, err := p.getWithBuffer()
+		require.NoError(t, err)
+		fm.SetPts(pts)
+		f.HandleFrame(FrameHandlerPayload{
+			Descriptor: testDescriptor{timeBase: avutil.NewRational(1, 1000)},
+			Frame:      fm,
+		})
+		p.put(fm)
+	}
+
+	// Handle audio frame
+	actx := NewAudioContext(48000, avcodec.AvSampleFormat(avutil.AV_SAMPLE_FMT_FLTP), avutil.AV_CH_LAYOUT_STEREO, avutil.NewRational(1, 48000))
+	actx.FrameSize = 1024
+	p.setBufferCtx(actx)
+	fm, err := p.getWithBuffer()
+	require.NoError(t, err)
+	fm.SetPts(0)
+	f.HandleFrame(FrameHandlerPayload{
+		Descriptor: testDescriptor{timeBase: actx.TimeBase},
+		Frame:      fm,
+	})
+	p.put(fm)
+
+	// Assert
+	require.NoError(t, f.Drain(context.Background()))
+	assert.Equal(t, []int64{0, 40, 80, 0, 40}, ptss)
+	assert.Equal(t, 1, errs)
+}
@@ -1,6 +1,8 @@
 package astilibav
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/asticode/go-astiencoder"
@@ -27,19 +29,36 @@ type FrameHandlerPayload struct {
 	Node       astiencoder.Node
 }
 
+// Metadata returns the frame metadata
+func (p FrameHandlerPayload) Metadata() map[string]string {
+	return FrameMetadata(p.Frame)
+}
+
+// SetMetadata sets a frame metadata
+// Since the frame is shared between handlers, it should only be used by the node dispatching the frame
+func (p FrameHandlerPayload) SetMetadata(key, value string) error {
+	return SetFrameMetadata(p.Frame, key, value)
+}
+
 type frameDispatcher struct {
 	eh               *astiencoder.EventHandler
-	hs               map[string]FrameHandler
-	m                *sync.Mutex // Locks hs
+	hs               map[string]*frameDispatcherHandler
+	m                *sync.Mutex // Locks hs and ns
+	ns               []string    // Handler names in connect order
 	n                astiencoder.Node
 	p                *framePool
 	statOutgoingRate *astikit.CounterRateStat
 }
 
+type frameDispatcherHandler struct {
+	h  FrameHandler
+	wg *sync.WaitGroup // Counts in-flight dispatches to this handler
+}
+
 func newFrameDispatcher(n astiencoder.Node, eh *astiencoder.EventHandler, p *framePool) *frameDispatcher {
 	return &frameDispatcher{
 		eh:               eh,
-		hs:               make(map[string]FrameHandler),
+		hs:               make(map[string]*frameDispatcherHandler),
 		m:                &sync.Mutex{},
 		n:                n,
 		p:                p,
@@ -50,40 +69,91 @@ func newFrameDispatcher(n astiencoder.Node, eh *astiencoder.EventHandler, p *fra
 func (d *frameDispatcher) addHandler(h FrameHandler) {
 	d.m.Lock()
 	defer d.m.Unlock()
-	d.hs[h.Metadata().Name] = h
+	if _, ok := d.hs[h.Metadata().Name]; !ok {
+		d.ns = append(d.ns, h.Metadata().Name)
+	}
+	d.hs[h.Metadata().Name] = &frameDispatcherHandler{
+		h:  h,
+		wg: &sync.WaitGroup{},
+	}
 }
 
+// delHandler returns once in-flight dispatches to the handler are done so that it doesn't receive new items
+// afterwards. Dispatches to other handlers are not waited for, therefore a synchronous handler can delete any
+// handler but itself
 func (d *frameDispatcher) delHandler(h FrameHandler) {
+	// Lock
 	d.m.Lock()
-	defer d.m.Unlock()
+
+	// Get handler
+	dh, ok := d.hs[h.Metadata().Name]
+	if !ok {
+		d.m.Unlock()
+		return
+	}
+
+	// Delete
 	delete(d.hs, h.Metadata().Name)
+	for idx, n := range d.ns {
+		if n == h.Metadata().Name {
+			d.ns = append(d.ns[:idx], d.ns[idx+1:]...)
+			break
+		}
+	}
+
+	// Unlock
+	d.m.Unlock()
+
+	// Wait for in-flight dispatches
+	dh.wg.Wait()
+}
+
+// acquire returns false if the handler has been deleted or replaced since the handlers have been retrieved.
+// Otherwise the dispatch is counted as in-flight until release is called
+func (d *frameDispatcher) acquire(n string, dh *frameDispatcherHandler) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.hs[n] != dh {
+		return false
+	}
+	dh.wg.Add(1)
+	return true
 }
 
+// dispatch calls handlers sequentially in connect order. Handlers only ref the frame and queue it in their own chan,
+// therefore they already process frames concurrently with each other, each in dispatch order, and a slow handler
+// doesn't block the others
 func (d *frameDispatcher) dispatch(f *avutil.Frame, descriptor Descriptor) {
 	// Increment outgoing rate
 	d.statOutgoingRate.Add(1)
 
-	// Get handlers
+	// Get handlers in connect order
 	d.m.Lock()
-	var hs []FrameHandler
-	for _, h := range d.hs {
-		hs = append(hs, h)
+	var ns []string
+	var dhs []*frameDispatcherHandler
+	for _, n := range d.ns {
+		dh := d.hs[n]
+		ns = append(ns, n)
+		dhs = append(dhs, dh)
 	}
 	d.m.Unlock()
 
-	// No handlers
-	if len(hs) == 0 {
-		return
-	}
-
 	// Loop through handlers
-	for _, h := range hs {
+	for idx, dh := range dhs {
+		// Handler has been deleted in the meantime
+		if !d.acquire(ns[idx], dh) {
+			continue
+		}
+
 		// Handle frame
-		h.HandleFrame(FrameHandlerPayload{
+		dh.h.HandleFrame(FrameHandlerPayload{
 			Descriptor: descriptor,
 			Frame:      f,
 			Node:       d.n,
 		})
+
+		// Release
+		dh.wg.Done()
 	}
 }
 
@@ -102,9 +172,10 @@ func (d *frameDispatcher) stats() []astikit.StatOptions {
 }
 
 type framePool struct {
-	c *astikit.Closer
-	m *sync.Mutex
-	p []*avutil.Frame
+	bufferCtx *Context
+	c         *astikit.Closer
+	m         *sync.Mutex
+	p         []*avutil.Frame
 }
 
 func newFramePool(c *astikit.Closer) *framePool {
@@ -130,6 +201,45 @@ func (p *framePool) get() (f *avutil.Frame) {
 	return
 }
 
+// setBufferCtx sets the context used to allocate frame buffers in getWithBuffer
+func (p *framePool) setBufferCtx(ctx Context) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.bufferCtx = &ctx
+}
+
+// getWithBuffer returns a frame whose buffer is already allocated based on the buffer context
+// so that nodes writing into fixed size frames don't rely on libav's lazy allocation.
+// It must not be used for frames used as AvFrameRef destinations since their buffer would leak.
+// It fails if the buffer context is unknown or incomplete.
+func (p *framePool) getWithBuffer() (f *avutil.Frame, err error) {
+	// Get buffer ctx
+	p.m.Lock()
+	ctx := p.bufferCtx
+	p.m.Unlock()
+
+	// No buffer ctx
+	if ctx == nil {
+		err = errors.New("astilibav: no buffer context")
+		return
+	} else if !ctx.canAllocFrameBuffer() {
+		err = fmt.Errorf("astilibav: can't allocate frame buffer for context %s", ctx)
+		return
+	}
+
+	// Get frame
+	f = p.get()
+
+	// Alloc buffer
+	if err = ctx.allocFrameBuffer(f); err != nil {
+		p.put(f)
+		f = nil
+		err = fmt.Errorf("astilibav: allocating frame buffer failed: %w", err)
+		return
+	}
+	return
+}
+
 func (p *framePool) put(f *avutil.Frame) {
 	p.m.Lock()
 	defer p.m.Unlock()
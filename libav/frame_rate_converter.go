@@ -0,0 +1,293 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avutil"
+)
+
+var countFrameRateConverter uint64
+
+// FrameRateConverter represents an object capable of converting frames to a constant frame rate based on their
+// PTS, by duplicating or dropping frames. Unlike RateEnforcer, it doesn't rely on a clock and can therefore
+// process frames faster than real time
+type FrameRateConverter struct {
+	*astiencoder.BaseNode
+	c                 *astikit.Chan
+	d                 *frameDispatcher
+	eh                *astiencoder.EventHandler
+	emitted           bool
+	f                 *avutil.Frame
+	maxGap            int64
+	nextPTS           int64
+	outputCtx         Context
+	p                 *framePool
+	restamper         FrameRestamper
+	statDroppedRate   *astikit.CounterRateStat
+	statFilledRate    *astikit.CounterRateStat
+	statIncomingRate  *astikit.CounterRateStat
+	statProcessedRate *astikit.CounterRateStat
+	timeBase          avutil.Rational
+}
+
+// FrameRateConverterOptions represents frame rate converter options
+type FrameRateConverterOptions struct {
+	FrameRate avutil.Rational
+	// If the pts of an incoming frame is more than MaxGap output frames away from the next output pts, in either
+	// direction, output pts are resynced on it instead of filling or dropping frames. Default is 10 seconds worth
+	// of frames
+	MaxGap int64
+	Node   astiencoder.NodeOptions
+	// Its frame rate and time base are overwritten based on FrameRate
+	OutputCtx Context
+	// If set, it runs after frames have been restamped to the output time base
+	Restamper FrameRestamper
+}
+
+// NewFrameRateConverter creates a new frame rate converter
+func NewFrameRateConverter(o FrameRateConverterOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (r *FrameRateConverter, err error) {
+	// Check frame rate
+	if o.FrameRate.Num() <= 0 || o.FrameRate.Den() <= 0 {
+		err = fmt.Errorf("astilibav: invalid frame rate %s", o.FrameRate)
+		return
+	}
+
+	// Extend node metadata
+	count := atomic.AddUint64(&countFrameRateConverter, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("frame_rate_converter_%d", count), fmt.Sprintf("Frame Rate Converter #%d", count), fmt.Sprintf("Converts frame rate to %s", o.FrameRate), "frame rate converter")
+
+	// Create frame rate converter
+	r = &FrameRateConverter{
+		c:                 astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
+		eh:                eh,
+		outputCtx:         o.OutputCtx,
+		p:                 newFramePool(c),
+		restamper:         o.Restamper,
+		statDroppedRate:   astikit.NewCounterRateStat(),
+		statFilledRate:    astikit.NewCounterRateStat(),
+		statIncomingRate:  astikit.NewCounterRateStat(),
+		statProcessedRate: astikit.NewCounterRateStat(),
+		timeBase:          avutil.NewRational(o.FrameRate.Den(), o.FrameRate.Num()),
+	}
+
+	// Update max gap
+	if r.maxGap = o.MaxGap; r.maxGap <= 0 {
+		if r.maxGap = 10 * int64(o.FrameRate.Num()) / int64(o.FrameRate.Den()); r.maxGap < 1 {
+			r.maxGap = 1
+		}
+	}
+
+	// Update output ctx
+	r.outputCtx.FrameRate = o.FrameRate
+	r.outputCtx.TimeBase = r.timeBase
+
+	// Create base node
+	r.BaseNode = astiencoder.NewBaseNode(o.Node, eh, s, r, astiencoder.EventTypeToNodeEventName)
+
+	// Create frame dispatcher
+	r.d = newFrameDispatcher(r, eh, r.p)
+
+	// Add stats
+	r.addStats()
+	return
+}
+
+func (r *FrameRateConverter) addStats() {
+	// Get stats
+	ss := r.c.Stats()
+	ss = append(ss, r.d.stats()...)
+	ss = append(ss,
+		astikit.StatOptions{
+			Handler: r.statIncomingRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames coming in per second",
+				Label:       "Incoming rate",
+				Name:        StatNameIncomingRate,
+				Unit:        "fps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: r.statProcessedRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames processed per second",
+				Label:       "Processed rate",
+				Name:        StatNameProcessedRate,
+				Unit:        "fps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: r.statDroppedRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames dropped per second",
+				Label:       "Dropped rate",
+				Name:        StatNameDroppedRate,
+				Unit:        "fps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: r.statFilledRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames duplicated per second",
+				Label:       "Filled rate",
+				Name:        StatNameFilledRate,
+				Unit:        "fps",
+			},
+		},
+	)
+
+	// Add stats
+	r.BaseNode.AddStats(ss...)
+}
+
+// OutputCtx returns the output ctx
+func (r *FrameRateConverter) OutputCtx() Context {
+	return r.outputCtx
+}
+
+// TimeBase implements the Descriptor interface
+func (r *FrameRateConverter) TimeBase() avutil.Rational {
+	return r.timeBase
+}
+
+// Connect implements the FrameHandlerConnector interface
+func (r *FrameRateConverter) Connect(h FrameHandler) {
+	// Add handler
+	r.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(r, h)
+}
+
+// Disconnect implements the FrameHandlerConnector interface
+func (r *FrameRateConverter) Disconnect(h FrameHandler) {
+	// Delete handler
+	r.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(r, h)
+}
+
+// Drain implements the Drainer interface. The last frame received is dispatched once in its slot
+func (r *FrameRateConverter) Drain(ctx context.Context) error {
+	r.c.Add(func() {
+		if r.f != nil {
+			r.dispatchUntil(r.nextPTS + 1)
+		}
+	})
+	return drainChan(ctx, r.BaseNode, r.c)
+}
+
+// Start starts the frame rate converter
+func (r *FrameRateConverter) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	r.BaseNode.Start(ctx, t, func(t *astikit.Task) {
+		// Make sure to stop the chan properly
+		defer r.c.Stop()
+
+		// Start chan
+		r.c.Start(r.Context())
+
+		// Make sure the last frame is dispatched once and released before the pool frees it
+		if r.f != nil {
+			if !r.emitted {
+				r.dispatchUntil(r.nextPTS + 1)
+			}
+			r.p.put(r.f)
+			r.f = nil
+		}
+	})
+}
+
+// HandleFrame implements the FrameHandler interface
+func (r *FrameRateConverter) HandleFrame(p FrameHandlerPayload) {
+	// Increment incoming rate
+	r.statIncomingRate.Add(1)
+
+	// Copy frame
+	f := r.p.get()
+	if ret := avutil.AvFrameRef(f, p.Frame); ret < 0 {
+		r.p.put(f)
+		emitAvError(r, r.eh, ret, "avutil.AvFrameRef failed")
+		return
+	}
+
+	// Add to chan
+	r.c.Add(func() {
+		// Handle pause
+		defer r.HandlePause()
+
+		// Increment processed rate
+		r.statProcessedRate.Add(1)
+
+		// Frames without pts can't be placed
+		if f.Pts() == avutil.AV_NOPTS_VALUE {
+			r.statDroppedRate.Add(1)
+			r.p.put(f)
+			return
+		}
+
+		// Get pts in output time base
+		pts := avutil.AvRescaleQ(f.Pts(), p.Descriptor.TimeBase(), r.timeBase)
+
+		// First frame
+		if r.f == nil {
+			r.nextPTS = pts
+		} else {
+			// Gap is too big
+			if gap := pts - r.nextPTS; gap > r.maxGap || gap < -r.maxGap {
+				// Make sure the previous frame is dispatched once
+				if !r.emitted {
+					r.dispatchUntil(r.nextPTS + 1)
+				}
+
+				// Resync
+				r.nextPTS = pts
+			}
+
+			// Dispatch previous frame in all slots before the current frame
+			r.dispatchUntil(pts)
+
+			// Previous frame has never been dispatched
+			if !r.emitted {
+				r.statDroppedRate.Add(1)
+			}
+			r.p.put(r.f)
+		}
+
+		// Store frame
+		r.emitted = false
+		r.f = f
+	})
+}
+
+// dispatchUntil dispatches the last frame received in all slots strictly before pts
+func (r *FrameRateConverter) dispatchUntil(pts int64) {
+	for ; r.nextPTS < pts; r.nextPTS++ {
+		// Copy frame
+		f := r.p.get()
+		if ret := avutil.AvFrameRef(f, r.f); ret < 0 {
+			r.p.put(f)
+			emitAvError(r, r.eh, ret, "avutil.AvFrameRef failed")
+			return
+		}
+
+		// Restamp
+		f.SetPts(r.nextPTS)
+		if r.restamper != nil {
+			r.restamper.Restamp(f)
+		}
+
+		// Frame is duplicated
+		if r.emitted {
+			r.statFilledRate.Add(1)
+		}
+		r.emitted = true
+
+		// Dispatch frame
+		r.d.dispatch(f, r)
+		r.p.put(f)
+	}
+}
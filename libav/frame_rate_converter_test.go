@@ -0,0 +1,87 @@
+package astilibav
+
+import (
+	"context"
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameRateConverter(t *testing.T) {
+	// Create
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	r, err := NewFrameRateConverter(FrameRateConverterOptions{
+		FrameRate: avutil.NewRational(10, 1),
+		MaxGap:    5,
+	}, eh, c, nil)
+	require.NoError(t, err)
+
+	// Connect handler
+	p := newFramePool(c)
+	p.setBufferCtx(Context{
+		CodecType:   avutil.AVMEDIA_TYPE_VIDEO,
+		Height:      2,
+		PixelFormat: avutil.AV_PIX_FMT_YUV420P,
+		Width:       2,
+	})
+	h := newTestFrameHandler("test", eh, p)
+	var ptss []int64
+	h.fn = func(p FrameHandlerPayload) { ptss = append(ptss, p.Frame.Pts()) }
+	r.Connect(h)
+
+	// Start
+	w := astikit.NewWorker(astikit.WorkerOptions{})
+	defer w.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.Start(ctx, w.NewTask)
+
+	// Handle frames
+	for _, pts := range []int64{
+		0,
+		100,
+		// Frame is duplicated
+		300,
+		// Frame without pts is dropped
+		avutil.AV_NOPTS_VALUE,
+		// Frame is dropped
+		320,
+		400,
+		// Gap is too big
+		10000,
+		10100,
+		// Backward jump is too big
+		500,
+	} {
+		f, err := p.getWithBuffer()
+		require.NoError(t, err)
+		f.SetPts(pts)
+		r.HandleFrame(FrameHandlerPayload{
+			Descriptor: testDescriptor{timeBase: avutil.NewRational(1, 1000)},
+			Frame:      f,
+		})
+		p.put(f)
+	}
+	require.NoError(t, r.Drain(context.Background()))
+	assert.Equal(t, []int64{0, 1, 2, 3, 4, 100, 101, 5}, ptss)
+
+	// Last frame is dispatched when the node stops
+	f, err := p.getWithBuffer()
+	require.NoError(t, err)
+	f.SetPts(600)
+	r.HandleFrame(FrameHandlerPayload{
+		Descriptor: testDescriptor{timeBase: avutil.NewRational(1, 1000)},
+		Frame:      f,
+	})
+	p.put(f)
+	cancel()
+	w.Stop()
+	w.Wait()
+	assert.Equal(t, []int64{0, 1, 2, 3, 4, 100, 101, 5, 6}, ptss)
+}
@@ -1,6 +1,8 @@
 package astilibav
 
 import (
+	"time"
+
 	"github.com/asticode/go-astikit"
 	"github.com/asticode/goav/avutil"
 )
@@ -83,3 +85,21 @@ func (r *frameRestamperWithModulo) Restamp(f *avutil.Frame) {
 		return astikit.Int64Ptr(f.Pts() - (f.Pts() % r.frameDuration))
 	})
 }
+
+type frameRestamperWithOffset struct {
+	offset int64
+}
+
+// NewFrameRestamperWithOffset creates a new frame restamper that shifts timestamps by offset
+// tb must be the frame time base
+func NewFrameRestamperWithOffset(offset time.Duration, tb avutil.Rational) FrameRestamper {
+	return &frameRestamperWithOffset{offset: avutil.AvRescaleQ(int64(offset), nanosecondRational, tb)}
+}
+
+// Restamp implements the FrameRestamper interface
+func (r *frameRestamperWithOffset) Restamp(f *avutil.Frame) {
+	if f.Pts() == avutil.AV_NOPTS_VALUE {
+		return
+	}
+	f.SetPts(f.Pts() + r.offset)
+}
@@ -2,6 +2,7 @@ package astilibav
 
 import (
 	"testing"
+	"time"
 
 	"github.com/asticode/goav/avutil"
 	"github.com/stretchr/testify/assert"
@@ -50,3 +51,21 @@ func TestFrameRestamperWithModulo(t *testing.T) {
 		assert.Equal(t, ft.output, f.Pts())
 	}
 }
+
+func TestFrameRestamperWithOffset(t *testing.T) {
+	f := avutil.Frame{}
+	r := NewFrameRestamperWithOffset(2*time.Second, avutil.NewRational(1, 100))
+	var last int64 = -1
+	for _, ft := range []frameTest{
+		{input: 0, output: 200},
+		{input: 4, output: 204},
+		{input: 8, output: 208},
+		{input: 12, output: 212},
+	} {
+		f.SetPts(ft.input)
+		r.Restamp(&f)
+		assert.Equal(t, ft.output, f.Pts())
+		assert.Greater(t, f.Pts(), last)
+		last = f.Pts()
+	}
+}
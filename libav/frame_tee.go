@@ -0,0 +1,278 @@
+package astilibav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avutil"
+)
+
+var countFrameTee uint64
+
+// FrameTeeFunc is called with a ref of each frame going through the frame tee.
+// The frame must not be modified and must not be used once the func has returned
+type FrameTeeFunc func(f *avutil.Frame, d Descriptor)
+
+// FrameTee represents an object capable of forwarding frames downstream unchanged while delivering
+// them to a func executed by a bounded pool of workers. When all workers are busy and the queue is full,
+// frames are not delivered to the func but are still forwarded downstream
+type FrameTee struct {
+	*astiencoder.BaseNode
+	c                 *astikit.Chan
+	d                 *frameDispatcher
+	dropped           uint64
+	eh                *astiencoder.EventHandler
+	fn                FrameTeeFunc
+	outputCtx         Context
+	p                 *framePool
+	q                 chan frameTeeItem
+	sampleCount       int
+	sampleInterval    int
+	statDroppedRate   *astikit.CounterRateStat
+	statIncomingRate  *astikit.CounterRateStat
+	statProcessedRate *astikit.CounterRateStat
+	workers           int
+}
+
+type frameTeeItem struct {
+	d Descriptor
+	f *avutil.Frame
+}
+
+// FrameTeeOptions represents frame tee options
+type FrameTeeOptions struct {
+	Func      FrameTeeFunc
+	Node      astiencoder.NodeOptions
+	OutputCtx Context
+	// Max number of frames waiting for a worker. Default is 1
+	QueueSize int
+	// Only 1 frame out of SampleInterval is delivered to Func, which allows controlling its cost. Default is 1
+	SampleInterval int
+	// Number of workers executing Func. Default is 1
+	Workers int
+}
+
+// NewFrameTee creates a new frame tee
+func NewFrameTee(o FrameTeeOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (t *FrameTee, err error) {
+	// Check func
+	if o.Func == nil {
+		err = errors.New("astilibav: no func provided")
+		return
+	}
+
+	// Default options
+	if o.QueueSize <= 0 {
+		o.QueueSize = 1
+	}
+	if o.SampleInterval <= 0 {
+		o.SampleInterval = 1
+	}
+	if o.Workers <= 0 {
+		o.Workers = 1
+	}
+
+	// Extend node metadata
+	count := atomic.AddUint64(&countFrameTee, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("frame_tee_%d", count), fmt.Sprintf("Frame tee #%d", count), "Tees", "frame tee")
+
+	// Create frame tee
+	t = &FrameTee{
+		c:                 astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
+		eh:                eh,
+		fn:                o.Func,
+		outputCtx:         o.OutputCtx,
+		p:                 newFramePool(c),
+		q:                 make(chan frameTeeItem, o.QueueSize),
+		sampleInterval:    o.SampleInterval,
+		statDroppedRate:   astikit.NewCounterRateStat(),
+		statIncomingRate:  astikit.NewCounterRateStat(),
+		statProcessedRate: astikit.NewCounterRateStat(),
+		workers:           o.Workers,
+	}
+
+	// Create base node
+	t.BaseNode = astiencoder.NewBaseNode(o.Node, eh, s, t, astiencoder.EventTypeToNodeEventName)
+
+	// Create frame dispatcher
+	t.d = newFrameDispatcher(t, eh, t.p)
+
+	// Add stats
+	t.addStats()
+	return
+}
+
+func (t *FrameTee) addStats() {
+	// Get stats
+	ss := t.c.Stats()
+	ss = append(ss, t.d.stats()...)
+	ss = append(ss,
+		astikit.StatOptions{
+			Handler: t.statDroppedRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames per second not delivered to the func because workers were busy",
+				Label:       "Dropped rate",
+				Name:        StatNameDroppedRate,
+				Unit:        "fps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: t.statIncomingRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames coming in per second",
+				Label:       "Incoming rate",
+				Name:        StatNameIncomingRate,
+				Unit:        "fps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: t.statProcessedRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames processed per second",
+				Label:       "Processed rate",
+				Name:        StatNameProcessedRate,
+				Unit:        "fps",
+			},
+		},
+	)
+
+	// Add stats
+	t.BaseNode.AddStats(ss...)
+}
+
+// Dropped returns the total number of frames not delivered to the func because workers were busy
+func (t *FrameTee) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// OutputCtx returns the output ctx
+func (t *FrameTee) OutputCtx() Context {
+	return t.outputCtx
+}
+
+// Connect implements the FrameHandlerConnector interface
+func (t *FrameTee) Connect(h FrameHandler) {
+	// Add handler
+	t.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(t, h)
+}
+
+// Disconnect implements the FrameHandlerConnector interface
+func (t *FrameTee) Disconnect(h FrameHandler) {
+	// Delete handler
+	t.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(t, h)
+}
+
+// Drain implements the Drainer interface
+func (t *FrameTee) Drain(ctx context.Context) error {
+	return drainChan(ctx, t.BaseNode, t.c)
+}
+
+// Start starts the frame tee
+func (t *FrameTee) Start(ctx context.Context, ct astiencoder.CreateTaskFunc) {
+	t.BaseNode.Start(ctx, ct, func(tk *astikit.Task) {
+		// Start workers
+		for i := 0; i < t.workers; i++ {
+			tk.NewSubTask().Do(t.work)
+		}
+
+		// Make sure to release frames that haven't been delivered once workers are done
+		defer func() {
+			tk.Wait()
+			for {
+				select {
+				case i := <-t.q:
+					t.p.put(i.f)
+				default:
+					return
+				}
+			}
+		}()
+
+		// Make sure to stop the chan properly
+		defer t.c.Stop()
+
+		// Start chan
+		t.c.Start(t.Context())
+	})
+}
+
+func (t *FrameTee) work() {
+	for {
+		select {
+		case <-t.Context().Done():
+			return
+		case i := <-t.q:
+			t.fn(i.f, i.d)
+			t.p.put(i.f)
+		}
+	}
+}
+
+// HandleFrame implements the FrameHandler interface
+func (t *FrameTee) HandleFrame(p FrameHandlerPayload) {
+	// Increment incoming rate
+	t.statIncomingRate.Add(1)
+
+	// Copy frame
+	fm := t.p.get()
+	if ret := avutil.AvFrameRef(fm, p.Frame); ret < 0 {
+		t.p.put(fm)
+		emitAvError(t, t.eh, ret, "avutil.AvFrameRef failed")
+		return
+	}
+
+	// Add to chan
+	t.c.Add(func() {
+		// Handle pause
+		defer t.HandlePause()
+
+		// Make sure to close frame
+		defer t.p.put(fm)
+
+		// Increment processed rate
+		t.statProcessedRate.Add(1)
+
+		// Tee frame
+		t.tee(fm, p.Descriptor)
+
+		// Dispatch frame
+		t.d.dispatch(fm, p.Descriptor)
+	})
+}
+
+func (t *FrameTee) tee(f *avutil.Frame, d Descriptor) {
+	// Sample
+	t.sampleCount++
+	if (t.sampleCount-1)%t.sampleInterval != 0 {
+		return
+	}
+
+	// Copy frame
+	fm := t.p.get()
+	if ret := avutil.AvFrameRef(fm, f); ret < 0 {
+		t.p.put(fm)
+		emitAvError(t, t.eh, ret, "avutil.AvFrameRef failed")
+		return
+	}
+
+	// Queue frame without blocking
+	select {
+	case t.q <- frameTeeItem{
+		d: d,
+		f: fm,
+	}:
+	default:
+		t.p.put(fm)
+		atomic.AddUint64(&t.dropped, 1)
+		t.statDroppedRate.Add(1)
+	}
+}
@@ -0,0 +1,259 @@
+package astilibav
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testFrameHandler struct {
+	*astiencoder.BaseNode
+	fn func(p FrameHandlerPayload)
+	ms []map[string]string
+	n  int
+	p  *framePool
+}
+
+func newTestFrameHandler(name string, eh *astiencoder.EventHandler, p *framePool) (h *testFrameHandler) {
+	h = &testFrameHandler{p: p}
+	h.BaseNode = astiencoder.NewBaseNode(astiencoder.NodeOptions{Metadata: astiencoder.NodeMetadata{Name: name}}, eh, nil, h, astiencoder.EventTypeToNodeEventName)
+	return
+}
+
+func (h *testFrameHandler) Start(ctx context.Context, tc astiencoder.CreateTaskFunc) {}
+
+func (h *testFrameHandler) HandleFrame(p FrameHandlerPayload) {
+	h.n++
+	if h.fn != nil {
+		h.fn(p)
+	}
+	f := h.p.get()
+	defer h.p.put(f)
+	if ret := avutil.AvFrameRef(f, p.Frame); ret < 0 {
+		return
+	}
+	h.ms = append(h.ms, FrameMetadata(f))
+}
+
+func TestFrameDispatcherMetadata(t *testing.T) {
+	// Create
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	p := newFramePool(c)
+	d := newFrameDispatcher(nil, eh, p)
+	h := newTestFrameHandler("test", eh, p)
+	d.addHandler(h)
+
+	// Create frame
+	p.setBufferCtx(Context{
+		CodecType:   avutil.AVMEDIA_TYPE_VIDEO,
+		Height:      2,
+		PixelFormat: avutil.AV_PIX_FMT_YUV420P,
+		Width:       2,
+	})
+	f, err := p.getWithBuffer()
+	require.NoError(t, err)
+	defer p.put(f)
+	require.NoError(t, SetFrameMetadata(f, "lavfi.cropdetect.w", "1280"))
+
+	// Dispatch
+	d.dispatch(f, nil)
+	assert.Equal(t, []map[string]string{{"lavfi.cropdetect.w": "1280"}}, h.ms)
+}
+
+func TestFrameDispatcherOrder(t *testing.T) {
+	// Create
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	p := newFramePool(c)
+	d := newFrameDispatcher(nil, eh, p)
+	var hs []*testFrameHandler
+	for _, n := range []string{"c", "a", "d", "b"} {
+		h := newTestFrameHandler(n, eh, p)
+		d.addHandler(h)
+		hs = append(hs, h)
+	}
+	d.delHandler(hs[2])
+	d.addHandler(hs[0])
+
+	// Get handlers names
+	var ns []string
+	for _, n := range d.ns {
+		ns = append(ns, d.hs[n].h.Metadata().Name)
+	}
+	assert.Equal(t, []string{"c", "a", "b"}, ns)
+}
+
+func TestFrameDispatcherDelHandler(t *testing.T) {
+	// Create
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	p := newFramePool(c)
+	d := newFrameDispatcher(nil, eh, p)
+	h1 := newTestFrameHandler("1", eh, p)
+	h2 := newTestFrameHandler("2", eh, p)
+	d.addHandler(h1)
+	d.addHandler(h2)
+
+	// A synchronous handler can delete another handler which then doesn't receive the frame
+	h1.fn = func(FrameHandlerPayload) { d.delHandler(h2) }
+	fm := p.get()
+	defer p.put(fm)
+	d.dispatch(fm, nil)
+	assert.Equal(t, 1, h1.n)
+	assert.Equal(t, 0, h2.n)
+
+	// Deleting a handler waits for its in-flight dispatches
+	block, handling := make(chan bool), make(chan bool)
+	h1.fn = func(FrameHandlerPayload) {
+		close(handling)
+		<-block
+	}
+	go d.dispatch(fm, nil)
+	<-handling
+	deleted := make(chan bool)
+	go func() {
+		d.delHandler(h1)
+		close(deleted)
+	}()
+	select {
+	case <-deleted:
+		t.Fatal("handler has been deleted while handling a frame")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(block)
+	select {
+	case <-deleted:
+	case <-time.After(time.Second):
+		t.Fatal("handler has not been deleted")
+	}
+}
+
+func TestFrameDispatcherRefFailure(t *testing.T) {
+	// Create
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	var errs int
+	eh.AddForEventName(astiencoder.EventNameError, func(e astiencoder.Event) bool {
+		errs++
+		return false
+	})
+	p := newFramePool(c)
+	d := newFrameDispatcher(nil, eh, p)
+	f, err := NewForwarder(ForwarderOptions{}, eh, c, nil)
+	require.NoError(t, err)
+	o := newTestFrameHandler("output", eh, p)
+	f.Connect(o)
+	h := newTestFrameHandler("test", eh, p)
+	d.addHandler(f)
+	d.addHandler(h)
+
+	// Start
+	w := astikit.NewWorker(astikit.WorkerOptions{})
+	defer w.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f.Start(ctx, w.NewTask)
+
+	// Frames without buffer nor format can't be referenced
+	fm := p.get()
+	d.dispatch(fm, nil)
+	p.put(fm)
+	assert.Equal(t, 1, errs)
+	assert.Equal(t, 1, h.n)
+	assert.Empty(t, h.ms)
+
+	// Next frames are still dispatched to all handlers
+	p.setBufferCtx(Context{
+		CodecType:   avutil.AVMEDIA_TYPE_VIDEO,
+		Height:      2,
+		PixelFormat: avutil.AV_PIX_FMT_YUV420P,
+		Width:       2,
+	})
+	fm, err = p.getWithBuffer()
+	require.NoError(t, err)
+	require.NoError(t, SetFrameMetadata(fm, "idx", "1"))
+	d.dispatch(fm, testDescriptor{timeBase: avutil.NewRational(1, 1000)})
+	p.put(fm)
+	require.NoError(t, f.Drain(context.Background()))
+	e := []map[string]string{{"idx": "1"}}
+	assert.Equal(t, e, h.ms)
+	assert.Equal(t, e, o.ms)
+	assert.Equal(t, 1, errs)
+
+	// Deleting handlers doesn't hang
+	d.delHandler(f)
+	d.delHandler(h)
+}
+
+func TestFrameDispatcherDoesntWaitForHandlers(t *testing.T) {
+	// Create
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	p := newFramePool(c)
+	p.setBufferCtx(Context{
+		CodecType:   avutil.AVMEDIA_TYPE_VIDEO,
+		Height:      2,
+		PixelFormat: avutil.AV_PIX_FMT_YUV420P,
+		Width:       2,
+	})
+	d := newFrameDispatcher(nil, eh, p)
+
+	// Forwarder is not started and therefore never processes its frames
+	f, err := NewForwarder(ForwarderOptions{}, eh, c, nil)
+	require.NoError(t, err)
+	h := newTestFrameHandler("test", eh, p)
+	d.addHandler(f)
+	d.addHandler(h)
+
+	// Dispatch
+	var e []map[string]string
+	for _, v := range []string{"1", "2", "3"} {
+		fm, err := p.getWithBuffer()
+		require.NoError(t, err)
+		require.NoError(t, SetFrameMetadata(fm, "idx", v))
+		d.dispatch(fm, nil)
+		p.put(fm)
+		e = append(e, map[string]string{"idx": v})
+	}
+	assert.Equal(t, int64(3), f.queued)
+	assert.Equal(t, e, h.ms)
+}
+
+func TestFramePoolGetWithBuffer(t *testing.T) {
+	// Create
+	c := astikit.NewCloser()
+	defer c.Close()
+	p := newFramePool(c)
+
+	// Frames can't be allocated without a complete buffer context
+	_, err := p.getWithBuffer()
+	assert.Error(t, err)
+	p.setBufferCtx(Context{CodecType: avutil.AVMEDIA_TYPE_VIDEO})
+	_, err = p.getWithBuffer()
+	assert.Error(t, err)
+
+	// Success
+	p.setBufferCtx(Context{
+		CodecType:   avutil.AVMEDIA_TYPE_VIDEO,
+		Height:      2,
+		PixelFormat: avutil.AV_PIX_FMT_YUV420P,
+		Width:       2,
+	})
+	f, err := p.getWithBuffer()
+	require.NoError(t, err)
+	defer p.put(f)
+	assert.Equal(t, 2, f.Width())
+	assert.Greater(t, f.Linesize(), 0)
+}
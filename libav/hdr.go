@@ -0,0 +1,70 @@
+package astilibav
+
+import (
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avformat"
+	"github.com/asticode/goav/avutil"
+)
+
+// ColorProperties represents color properties that need to be preserved for HDR content
+type ColorProperties struct {
+	Primaries avcodec.AvColorPrimaries
+	Range     avcodec.AvColorRange
+	Space     avcodec.AvColorSpace
+	TRC       avcodec.AvColorTransferCharacteristic
+}
+
+// HDRMetadata represents HDR side data. Nil fields mean the side data is missing
+type HDRMetadata struct {
+	ContentLightLevel *ContentLightLevel
+	MasteringDisplay  *MasteringDisplay
+}
+
+// ContentLightLevel represents content light level side data
+type ContentLightLevel struct {
+	// Max content light level in cd/m²
+	MaxCLL uint
+	// Max frame average light level in cd/m²
+	MaxFALL uint
+}
+
+// MasteringDisplay represents mastering display side data
+type MasteringDisplay struct {
+	// CIE 1931 xy chromaticity coords of red, green and blue
+	DisplayPrimaries [3][2]avutil.Rational
+	// Luminances in cd/m². Zero values mean luminances are unknown
+	MaxLuminance avutil.Rational
+	MinLuminance avutil.Rational
+	// CIE 1931 xy chromaticity coords of white point. Zero values mean primaries are unknown
+	WhitePoint [2]avutil.Rational
+}
+
+// StreamColorProperties returns the stream color properties or nil if they're all unspecified
+func StreamColorProperties(s *avformat.Stream) *ColorProperties {
+	p, specified := codecParametersColorProperties(s.CodecParameters())
+	if !specified {
+		return nil
+	}
+	return &p
+}
+
+// FrameHDRMetadata returns the HDR side data of the frame
+func FrameHDRMetadata(f *avutil.Frame) HDRMetadata {
+	return frameHDRMetadata(f)
+}
+
+// SetFrameHDRMetadata adds the HDR side data to the frame
+func SetFrameHDRMetadata(f *avutil.Frame, m HDRMetadata) error {
+	return setFrameHDRMetadata(f, m)
+}
+
+// StreamHDRMetadata returns the HDR side data of the stream
+func StreamHDRMetadata(s *avformat.Stream) HDRMetadata {
+	return streamHDRMetadata(s)
+}
+
+// SetStreamHDRMetadata adds the HDR side data to the stream so that muxers write it.
+// It must be set before the header is written
+func SetStreamHDRMetadata(s *avformat.Stream, m HDRMetadata) error {
+	return setStreamHDRMetadata(s, m)
+}
@@ -0,0 +1,80 @@
+package astilibav
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avformat"
+	"github.com/asticode/goav/avutil"
+)
+
+// ImageDemuxerOptions represents image demuxer options
+type ImageDemuxerOptions struct {
+	// Clock used to emulate rate. Default is RealClock
+	Clock Clock
+	// Additional options of the image2 demuxer. They take precedence over the ones set by the other options
+	Dict *Dict
+	// If true, the demuxer will sleep between frames
+	EmulateRate bool
+	// Default is 25/1
+	FrameRate avutil.Rational
+	// If true, images are read indefinitely which allows generating a video out of a single still image.
+	// Use EmulateRate or stop the demuxer to control the output duration
+	Loop bool
+	Node astiencoder.NodeOptions
+	// Index of the first image of a sequence. Default is detected by the demuxer
+	StartNumber *int
+	// Either a single image path or a numbered sequence pattern such as "frame_%04d.png"
+	URL string
+}
+
+// NewImageDemuxer creates a demuxer reading a single image or a numbered image sequence at a given frame rate
+func NewImageDemuxer(o ImageDemuxerOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (d *Demuxer, err error) {
+	// Find input format
+	f := avformat.AvFindInputFormat("image2")
+	if f == nil {
+		err = errors.New("astilibav: image2 input format not found")
+		return
+	}
+
+	// Default frame rate
+	if o.FrameRate.Num() <= 0 || o.FrameRate.Den() <= 0 {
+		o.FrameRate = avutil.NewRational(25, 1)
+	}
+
+	// Create dict
+	ss := []string{"framerate=" + strconv.Itoa(o.FrameRate.Num()) + "/" + strconv.Itoa(o.FrameRate.Den())}
+	if o.Loop {
+		ss = append(ss, "loop=1")
+	}
+	if o.StartNumber != nil {
+		ss = append(ss, "start_number="+strconv.Itoa(*o.StartNumber))
+	}
+
+	// Create demuxer
+	if d, err = NewDemuxer(DemuxerOptions{
+		Clock:       o.Clock,
+		Dict:        NewDictWithDefaults(NewDefaultDict(strings.Join(ss, ",")), o.Dict),
+		EmulateRate: o.EmulateRate,
+		Format:      f,
+		Node:        o.Node,
+		URL:         o.URL,
+	}, eh, c, s); err != nil {
+		err = fmt.Errorf("astilibav: creating demuxer failed: %w", err)
+		return
+	}
+	return
+}
+
+// VideoCtx returns the context of the first video stream
+func (d *Demuxer) VideoCtx() (Context, bool) {
+	ss := d.StreamsByCodecType(avutil.AVMEDIA_TYPE_VIDEO)
+	if len(ss) == 0 {
+		return Context{}, false
+	}
+	return d.StreamCtx(ss[0].Index())
+}
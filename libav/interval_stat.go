@@ -0,0 +1,136 @@
+package astilibav
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avutil"
+)
+
+// ptsIntervalStats computes the distribution of intervals between consecutive timestamps since the node
+// has started. A constant frame rate output has min, max and mean equal and a std dev of 0
+type ptsIntervalStats struct {
+	count    int
+	hasPts   bool
+	lastPts  int64
+	m        *sync.Mutex // Locks count, hasPts, lastPts, m2, max, mean and min
+	m2       float64
+	max      float64
+	mean     float64
+	min      float64
+	nodeType string
+}
+
+func newPTSIntervalStats(nodeType string) *ptsIntervalStats {
+	return &ptsIntervalStats{
+		m:        &sync.Mutex{},
+		nodeType: nodeType,
+	}
+}
+
+// add adds a timestamp expressed in the time base
+func (s *ptsIntervalStats) add(pts int64, timeBase avutil.Rational) {
+	// Timestamps that are not set are ignored
+	if pts == avutil.AV_NOPTS_VALUE {
+		return
+	}
+
+	// Lock
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Get previous pts
+	lastPts, hasPts := s.lastPts, s.hasPts
+	s.lastPts, s.hasPts = pts, true
+	if !hasPts {
+		return
+	}
+
+	// Get interval in ms
+	v := float64(pts-lastPts) * timeBase.ToDouble() * 1000
+
+	// Update min and max
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+
+	// Update mean and variance using Welford's algorithm
+	s.count++
+	delta := v - s.mean
+	s.mean += delta / float64(s.count)
+	s.m2 += delta * (v - s.mean)
+}
+
+func (s *ptsIntervalStats) values() (min, max, mean, stdDev float64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.count > 1 {
+		stdDev = math.Sqrt(s.m2 / float64(s.count))
+	}
+	return s.min, s.max, s.mean, stdDev
+}
+
+func (s *ptsIntervalStats) stats() []astikit.StatOptions {
+	return []astikit.StatOptions{
+		{
+			Handler: newPTSIntervalStatHandler(func() float64 { _, v, _, _ := s.values(); return v }),
+			Metadata: &astikit.StatMetadata{
+				Description: "Max interval between timestamps of " + s.nodeType + " since start",
+				Label:       "Max interval",
+				Name:        StatNameMaxInterval,
+				Unit:        "ms",
+			},
+		},
+		{
+			Handler: newPTSIntervalStatHandler(func() float64 { _, _, v, _ := s.values(); return v }),
+			Metadata: &astikit.StatMetadata{
+				Description: "Mean interval between timestamps of " + s.nodeType + " since start",
+				Label:       "Mean interval",
+				Name:        StatNameMeanInterval,
+				Unit:        "ms",
+			},
+		},
+		{
+			Handler: newPTSIntervalStatHandler(func() float64 { v, _, _, _ := s.values(); return v }),
+			Metadata: &astikit.StatMetadata{
+				Description: "Min interval between timestamps of " + s.nodeType + " since start",
+				Label:       "Min interval",
+				Name:        StatNameMinInterval,
+				Unit:        "ms",
+			},
+		},
+		{
+			Handler: newPTSIntervalStatHandler(func() float64 { _, _, _, v := s.values(); return v }),
+			Metadata: &astikit.StatMetadata{
+				Description: "Standard deviation of intervals between timestamps of " + s.nodeType + " since start",
+				Label:       "Interval jitter",
+				Name:        StatNameIntervalJitter,
+				Unit:        "ms",
+			},
+		},
+	}
+}
+
+type ptsIntervalStatHandler struct {
+	fn func() float64
+}
+
+func newPTSIntervalStatHandler(fn func() float64) *ptsIntervalStatHandler {
+	return &ptsIntervalStatHandler{fn: fn}
+}
+
+// Start implements the astikit.StatHandler interface
+func (h *ptsIntervalStatHandler) Start() {}
+
+// Stop implements the astikit.StatHandler interface
+func (h *ptsIntervalStatHandler) Stop() {}
+
+// Value implements the astikit.StatHandler interface
+func (h *ptsIntervalStatHandler) Value(_ time.Duration) interface{} {
+	return h.fn()
+}
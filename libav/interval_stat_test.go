@@ -0,0 +1,28 @@
+package astilibav
+
+import (
+	"testing"
+
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPTSIntervalStats(t *testing.T) {
+	s := newPTSIntervalStats("frames")
+	tb := avutil.NewRational(1, 1000)
+	for _, pts := range []int64{0, 40, avutil.AV_NOPTS_VALUE, 80, 120} {
+		s.add(pts, tb)
+	}
+	min, max, mean, stdDev := s.values()
+	assert.Equal(t, 40.0, min)
+	assert.Equal(t, 40.0, max)
+	assert.Equal(t, 40.0, mean)
+	assert.Equal(t, 0.0, stdDev)
+	s.add(140, tb)
+	s.add(200, tb)
+	min, max, mean, stdDev = s.values()
+	assert.Equal(t, 20.0, min)
+	assert.Equal(t, 60.0, max)
+	assert.Equal(t, 40.0, mean)
+	assert.InDelta(t, 12.649, stdDev, 0.001)
+}
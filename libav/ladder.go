@@ -0,0 +1,238 @@
+package astilibav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avformat"
+	"github.com/asticode/goav/avutil"
+)
+
+// LadderSource represents the node whose frames are shared by all renditions of a ladder. It's usually a decoder
+type LadderSource interface {
+	astiencoder.Node
+	FrameHandlerConnector
+	OutputContexter
+}
+
+// Ladder represents a bitrate ladder: frames of one source are fanned out to a scaler, an encoder and a muxer
+// per rendition
+type Ladder struct {
+	rs []*LadderRendition
+	s  LadderSource
+}
+
+// LadderOptions represents ladder options
+type LadderOptions struct {
+	Renditions []LadderRenditionOptions
+	Source     LadderSource
+}
+
+// LadderRenditionOptions represents ladder rendition options
+type LadderRenditionOptions struct {
+	BitRate   int
+	CodecName string
+	Dict      *Dict
+	// Ctx is overwritten by the rendition output ctx
+	Encoder EncoderOptions
+	// Default is the source gop size
+	GopSize int
+	// If only one of Height and Width is set, the other one is computed to keep the source aspect ratio.
+	// If none is set, the source dimensions are kept
+	Height int
+	Muxer  MuxerOptions
+	// Name is added as a "rendition:<name>" tag to the rendition nodes so that their stats can be grouped
+	Name  string
+	Width int
+}
+
+// LadderRendition represents a ladder rendition
+type LadderRendition struct {
+	Encoder *Encoder
+	// Nil if the rendition has the source dimensions
+	Filterer *Filterer
+	Muxer    *Muxer
+	Name     string
+	Stream   *avformat.Stream
+	h        *MuxerPktHandler
+}
+
+// NewLadder creates the nodes of all renditions and connects them to the source
+// Muxers share the closer, therefore all outputs are finalized together when it's closed
+func NewLadder(o LadderOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (l *Ladder, err error) {
+	// No source
+	if o.Source == nil {
+		err = errors.New("astilibav: no ladder source provided")
+		return
+	}
+
+	// No renditions
+	if len(o.Renditions) == 0 {
+		err = errors.New("astilibav: no ladder renditions provided")
+		return
+	}
+
+	// Create ladder
+	l = &Ladder{s: o.Source}
+
+	// Loop through renditions
+	for idx, ro := range o.Renditions {
+		// Default name
+		if ro.Name == "" {
+			ro.Name = fmt.Sprintf("%d", idx)
+		}
+
+		// Create rendition
+		var r *LadderRendition
+		if r, err = l.newRendition(ro, eh, c, s); err != nil {
+			err = fmt.Errorf("astilibav: creating rendition %s failed: %w", ro.Name, err)
+			return
+		}
+		l.rs = append(l.rs, r)
+	}
+
+	// Connect renditions once they've all been created successfully
+	for _, r := range l.rs {
+		if r.Filterer != nil {
+			l.s.Connect(r.Filterer)
+			r.Filterer.Connect(r.Encoder)
+		} else {
+			l.s.Connect(r.Encoder)
+		}
+		r.Encoder.Connect(r.h)
+	}
+	return
+}
+
+func (l *Ladder) newRendition(o LadderRenditionOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (r *LadderRendition, err error) {
+	// Create rendition
+	r = &LadderRendition{Name: o.Name}
+	tag := "rendition:" + o.Name
+
+	// Create muxer
+	o.Muxer.Node.Metadata = o.Muxer.Node.Metadata.Extend("", "", "", tag)
+	if r.Muxer, err = NewMuxer(o.Muxer, eh, c, s); err != nil {
+		err = fmt.Errorf("astilibav: creating muxer failed: %w", err)
+		return
+	}
+
+	// Create output ctx
+	inCtx := l.s.OutputCtx()
+	outCtx := ladderRenditionOutputCtx(o, inCtx, r.Muxer.GlobalHeader())
+
+	// Create filterer
+	if outCtx.Height != inCtx.Height || outCtx.Width != inCtx.Width {
+		if r.Filterer, err = NewFilterer(FiltererOptions{
+			Content:   fmt.Sprintf("scale='w=%d:h=%d'", outCtx.Width, outCtx.Height),
+			Inputs:    map[string]astiencoder.Node{"in": l.s},
+			Node:      astiencoder.NodeOptions{Metadata: astiencoder.NodeMetadata{Tags: []string{tag}}},
+			OutputCtx: outCtx,
+		}, eh, c, s); err != nil {
+			err = fmt.Errorf("astilibav: creating filterer failed: %w", err)
+			return
+		}
+	}
+
+	// Create encoder
+	o.Encoder.Ctx = outCtx
+	o.Encoder.Node.Metadata = o.Encoder.Node.Metadata.Extend("", "", "", tag)
+	if r.Encoder, err = NewEncoder(o.Encoder, eh, c, s); err != nil {
+		err = fmt.Errorf("astilibav: creating encoder failed: %w", err)
+		return
+	}
+
+	// Add stream
+	if r.Stream, err = r.Encoder.AddStream(r.Muxer.CtxFormat()); err != nil {
+		err = fmt.Errorf("astilibav: adding stream failed: %w", err)
+		return
+	}
+
+	// Create muxer handler
+	if r.h, err = r.Muxer.NewPktHandler(r.Stream); err != nil {
+		err = fmt.Errorf("astilibav: creating muxer handler failed: %w", err)
+		return
+	}
+	return
+}
+
+func ladderRenditionOutputCtx(o LadderRenditionOptions, inCtx Context, globalHeader bool) (outCtx Context) {
+	// Default output ctx is input ctx
+	outCtx = inCtx
+	outCtx.BitRate = o.BitRate
+	outCtx.CodecName = o.CodecName
+	outCtx.Dict = o.Dict
+	outCtx.GlobalHeader = globalHeader
+	if o.GopSize > 0 {
+		outCtx.GopSize = o.GopSize
+	}
+
+	// Set dimensions
+	switch {
+	case o.Height > 0 && o.Width > 0:
+		outCtx.Height = o.Height
+		outCtx.Width = o.Width
+	case o.Height > 0 && inCtx.Height > 0:
+		outCtx.Height = o.Height
+		outCtx.Width = ladderEvenDimension(inCtx.Width * o.Height / inCtx.Height)
+	case o.Width > 0 && inCtx.Width > 0:
+		outCtx.Height = ladderEvenDimension(inCtx.Height * o.Width / inCtx.Width)
+		outCtx.Width = o.Width
+	}
+
+	// Set time base
+	if outCtx.FrameRate.Num() > 0 && outCtx.FrameRate.Den() > 0 {
+		outCtx.TimeBase = avutil.NewRational(outCtx.FrameRate.Den(), outCtx.FrameRate.Num())
+	}
+	return
+}
+
+// Most encoders require even dimensions with subsampled pixel formats
+func ladderEvenDimension(i int) int {
+	if i%2 != 0 {
+		i++
+	}
+	return i
+}
+
+// Renditions returns the ladder renditions in the order they were provided
+func (l *Ladder) Renditions() []*LadderRendition {
+	return append([]*LadderRendition{}, l.rs...)
+}
+
+// Nodes returns the rendition nodes, from the filterer, if any, to the muxer
+func (r *LadderRendition) Nodes() (ns []astiencoder.Node) {
+	if r.Filterer != nil {
+		ns = append(ns, r.Filterer)
+	}
+	return append(ns, r.Encoder, r.Muxer)
+}
+
+// Drain implements the Drainer interface
+// Each stage of all renditions is drained before moving on to the next one so that all outputs have
+// received the same frames once it returns
+func (l *Ladder) Drain(ctx context.Context) (err error) {
+	for idx := 0; idx < 3; idx++ {
+		for _, r := range l.rs {
+			var n astiencoder.Node
+			switch idx {
+			case 0:
+				if r.Filterer == nil {
+					continue
+				}
+				n = r.Filterer
+			case 1:
+				n = r.Encoder
+			default:
+				n = r.Muxer
+			}
+			if err = DrainHandler(ctx, n); err != nil {
+				err = fmt.Errorf("astilibav: draining %s of rendition %s failed: %w", n.Metadata().Name, r.Name, err)
+				return
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,29 @@
+package astilibav
+
+import (
+	"testing"
+
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLadderRenditionOutputCtx(t *testing.T) {
+	in := Context{
+		BitRate:   8e6,
+		FrameRate: avutil.NewRational(25, 1),
+		GopSize:   50,
+		Height:    1080,
+		Width:     1920,
+	}
+	c := ladderRenditionOutputCtx(LadderRenditionOptions{BitRate: 3e6, CodecName: "libx264", Height: 720}, in, true)
+	assert.Equal(t, 3000000, c.BitRate)
+	assert.Equal(t, "libx264", c.CodecName)
+	assert.Equal(t, 50, c.GopSize)
+	assert.True(t, c.GlobalHeader)
+	assert.Equal(t, 720, c.Height)
+	assert.Equal(t, 1280, c.Width)
+	assert.Equal(t, avutil.NewRational(1, 25), c.TimeBase)
+	c = ladderRenditionOutputCtx(LadderRenditionOptions{Width: 426}, in, false)
+	assert.Equal(t, 240, c.Height)
+	assert.Equal(t, 426, c.Width)
+}
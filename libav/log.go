@@ -1,7 +1,10 @@
 package astilibav
 
 import (
+	"fmt"
 	"strings"
+	"sync"
+	"unsafe"
 
 	"github.com/asticode/go-astiencoder"
 	"github.com/asticode/go-astikit"
@@ -14,7 +17,10 @@ type EventLog struct {
 	Parent string
 }
 
-// TODO Process parent and update event's target
+// HandleLogs bridges libav logs to EventNameLog events
+// When the log comes from a context owned by a node (format or codec context), the event's target is the node
+// The callback may be executed from several C threads at the same time which is why the registry of contexts
+// is protected and why events are emitted through the thread-safe event handler
 func HandleLogs(eh *astiencoder.EventHandler) {
 	avutil.AvLogSetCallback(func(level int, msg, parent string) {
 		// Emit event
@@ -25,11 +31,45 @@ func HandleLogs(eh *astiencoder.EventHandler) {
 				Msg:    msg,
 				Parent: parent,
 			},
+			Target: logParents.get(parent),
 		})
 	})
 }
 
+type logParentRegistry struct {
+	m  *sync.RWMutex // Locks ts
+	ts map[string]interface{}
+}
+
+var logParents = &logParentRegistry{
+	m:  &sync.RWMutex{},
+	ts: make(map[string]interface{}),
+}
+
+// add registers the target owning the libav context so that its logs are attributed to it
+// The returned func must be called before the context is freed
+func (r *logParentRegistry) add(ctx unsafe.Pointer, target interface{}) (del func() error) {
+	k := fmt.Sprintf("%p", ctx)
+	r.m.Lock()
+	r.ts[k] = target
+	r.m.Unlock()
+	return func() error {
+		r.m.Lock()
+		delete(r.ts, k)
+		r.m.Unlock()
+		return nil
+	}
+}
+
+func (r *logParentRegistry) get(parent string) interface{} {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.ts[parent]
+}
+
 type LoggerEventHandlerAdapterOptions struct {
+	// If set, logs above its package level are not logged
+	Controller         *LogController
 	IgnoredLogMessages []string
 }
 
@@ -53,6 +93,9 @@ func LoggerEventHandlerAdapter(o LoggerEventHandlerAdapterOptions, i astikit.Std
 			if _, ok := is[msg]; ok {
 				return false
 			}
+			if o.Controller != nil && !o.Controller.packageLevelEnabled(v.Level) {
+				return false
+			}
 			msg = "astilibav: " + msg
 			if strings.Index(v.Parent, "0x") == 0 {
 				msg += " (" + v.Parent + ")"
@@ -0,0 +1,137 @@
+package astilibav
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avutil"
+)
+
+// Logger names used by the log controller
+const (
+	// Level above which libav doesn't send logs
+	LogControllerNameLibav = "libav"
+	// Level above which libav logs received by LoggerEventHandlerAdapter are not logged
+	LogControllerNamePackage = "astilibav"
+)
+
+var logLevels = map[string]int{
+	"quiet":   avutil.AV_LOG_QUIET,
+	"panic":   avutil.AV_LOG_PANIC,
+	"fatal":   avutil.AV_LOG_FATAL,
+	"error":   avutil.AV_LOG_ERROR,
+	"warning": avutil.AV_LOG_WARNING,
+	"info":    avutil.AV_LOG_INFO,
+	"verbose": avutil.AV_LOG_VERBOSE,
+	"debug":   avutil.AV_LOG_DEBUG,
+	"trace":   avLogTrace,
+}
+
+func logLevelName(l int) string {
+	for n, v := range logLevels {
+		if v == l {
+			return n
+		}
+	}
+	return fmt.Sprintf("%d", l)
+}
+
+// LogController allows updating log levels at runtime and keeps a bounded capture of recent libav logs
+// It implements the astiencoder.ServerLogController interface
+type LogController struct {
+	ls           []string
+	m            *sync.Mutex // Locks ls and packageLevel
+	packageLevel int
+	size         int
+}
+
+// LogControllerOptions represents log controller options
+type LogControllerOptions struct {
+	// Number of recent logs kept. Default is 100
+	BufferSize int
+}
+
+// NewLogController creates a new log controller
+func NewLogController(o LogControllerOptions) *LogController {
+	if o.BufferSize <= 0 {
+		o.BufferSize = 100
+	}
+	return &LogController{
+		m:            &sync.Mutex{},
+		packageLevel: avutil.AV_LOG_DEBUG,
+		size:         o.BufferSize,
+	}
+}
+
+// Adapt captures libav logs emitted through HandleLogs
+func (c *LogController) Adapt(eh *astiencoder.EventHandler) {
+	eh.AddForEventName(EventNameLog, func(e astiencoder.Event) bool {
+		if v, ok := e.Payload.(EventLog); ok {
+			if msg := strings.TrimSpace(v.Msg); msg != "" {
+				c.m.Lock()
+				c.ls = append(c.ls, "["+logLevelName(v.Level)+"] "+msg)
+				if len(c.ls) > c.size {
+					c.ls = c.ls[len(c.ls)-c.size:]
+				}
+				c.m.Unlock()
+			}
+		}
+		return false
+	})
+}
+
+// LogLevels implements the astiencoder.ServerLogController interface
+func (c *LogController) LogLevels() map[string]string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return map[string]string{
+		LogControllerNameLibav:   logLevelName(avutil.AvLogGetLevel()),
+		LogControllerNamePackage: logLevelName(c.packageLevel),
+	}
+}
+
+// RecentLogs implements the astiencoder.ServerLogController interface
+func (c *LogController) RecentLogs() []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return append([]string{}, c.ls...)
+}
+
+// SetLogLevels implements the astiencoder.ServerLogController interface
+func (c *LogController) SetLogLevels(ls map[string]string) error {
+	// Check levels first so that nothing is updated if one of them is invalid
+	vs := make(map[string]int)
+	for n, l := range ls {
+		v, ok := logLevels[l]
+		if !ok {
+			return fmt.Errorf("astilibav: invalid log level %s", l)
+		}
+		switch n {
+		case LogControllerNameLibav, LogControllerNamePackage:
+			vs[n] = v
+		default:
+			return fmt.Errorf("astilibav: invalid logger name %s", n)
+		}
+	}
+
+	// Set levels
+	for n, v := range vs {
+		switch n {
+		case LogControllerNameLibav:
+			avutil.AvLogSetLevel(v)
+		case LogControllerNamePackage:
+			c.m.Lock()
+			c.packageLevel = v
+			c.m.Unlock()
+		}
+	}
+	return nil
+}
+
+func (c *LogController) packageLevelEnabled(l int) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return l <= c.packageLevel
+}
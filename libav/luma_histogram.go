@@ -0,0 +1,186 @@
+package astilibav
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avutil"
+)
+
+var countLumaHistogram uint64
+
+// LumaHistogram represents luma statistics of a frame. Values are normalized between 0 and 1
+type LumaHistogram struct {
+	// Number of sampled pixels per bin. Bins split the luma range evenly
+	Bins       []uint64
+	Descriptor Descriptor
+	Mean       float64
+	Pts        int64
+	// Mean luma of evenly split columns, from left to right
+	Waveform []float64
+}
+
+// LumaHistogramOptions represents luma histogram options
+type LumaHistogramOptions struct {
+	// Default is 16
+	Bins int
+	Func func(h LumaHistogram)
+	Node astiencoder.NodeOptions
+	// Options of the frame tee computing histograms. Its Func is ignored
+	Tee FrameTeeOptions
+	// Only 1 pixel out of Step is sampled, both horizontally and vertically. Default is 1
+	Step int
+	// Number of waveform columns. 0 means the waveform is not computed
+	WaveformColumns int
+}
+
+// NewLumaHistogram creates a frame tee computing the luma histogram of frames on its workers.
+// Frames are forwarded downstream unchanged and the mean luma is exposed as a stat
+func NewLumaHistogram(o LumaHistogramOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (t *FrameTee, err error) {
+	// Check func
+	if o.Func == nil {
+		err = errors.New("astilibav: no func provided")
+		return
+	}
+
+	// Default options
+	if o.Bins <= 0 {
+		o.Bins = 16
+	}
+	if o.Step <= 0 {
+		o.Step = 1
+	}
+
+	// Extend node metadata
+	count := atomic.AddUint64(&countLumaHistogram, uint64(1))
+	o.Tee.Node = o.Node
+	o.Tee.Node.Metadata = o.Tee.Node.Metadata.Extend(fmt.Sprintf("luma_histogram_%d", count), fmt.Sprintf("Luma histogram #%d", count), "Computes luma histograms", "luma histogram")
+
+	// Create stat
+	statMean := astikit.NewCounterAvgStat()
+
+	// Create frame tee
+	var tp *FrameTee
+	o.Tee.Func = func(f *avutil.Frame, d Descriptor) {
+		// Compute histogram
+		h, err := lumaHistogram(f, o.Bins, o.Step, o.WaveformColumns)
+		if err != nil {
+			eh.Emit(astiencoder.EventError(tp, fmt.Errorf("astilibav: computing luma histogram failed: %w", err)))
+			return
+		}
+		h.Descriptor = d
+
+		// Update stat
+		statMean.Add(h.Mean)
+
+		// Custom
+		o.Func(h)
+	}
+	if t, err = NewFrameTee(o.Tee, eh, c, s); err != nil {
+		err = fmt.Errorf("astilibav: creating frame tee failed: %w", err)
+		return
+	}
+	tp = t
+
+	// Add stats
+	t.BaseNode.AddStats(astikit.StatOptions{
+		Handler: statMean,
+		Metadata: &astikit.StatMetadata{
+			Description: "Mean luma of sampled frames, between 0 and 1",
+			Label:       "Mean luma",
+			Name:        StatNameMeanLuma,
+		},
+	})
+	return
+}
+
+func lumaHistogram(f *avutil.Frame, bins, step, columns int) (h LumaHistogram, err error) {
+	// Get descriptor
+	desc := pixFmtDescGet(avutil.PixelFormat(f.Format()))
+	if desc == nil {
+		err = fmt.Errorf("astilibav: no descriptor found for pixel format %d", f.Format())
+		return
+	}
+
+	// Check flags
+	if desc.flags&(avPixFmtFlagRGB|avPixFmtFlagPal|avPixFmtFlagBitstream|avPixFmtFlagHWAccel) > 0 || len(desc.comps) == 0 {
+		err = fmt.Errorf("astilibav: pixel format %s is not yuv nor gray", desc.name)
+		return
+	}
+
+	// Get luma component
+	comp := desc.comps[0]
+	depth, stp, offset, shift := comp.depth, comp.step, comp.offset, uint(comp.shift)
+	if depth > 16 {
+		err = fmt.Errorf("astilibav: depth %d of pixel format %s is not supported", depth, desc.name)
+		return
+	}
+	be := desc.flags&avPixFmtFlagBE > 0
+	mask, max := (1<<uint(depth))-1, float64((int(1)<<uint(depth))-1)
+
+	// Get dimensions
+	width, height := f.Width(), f.Height()
+
+	// Create histogram
+	h.Bins = make([]uint64, bins)
+	h.Pts = f.Pts()
+	var ws []float64
+	var wcs []int
+	if columns > 0 {
+		ws = make([]float64, columns)
+		wcs = make([]int, columns)
+	}
+
+	// Loop through pixels
+	var sum float64
+	var n int
+	for y := 0; y < height; y += step {
+		row := frameRow(f, comp.plane, 0, y, width*stp)
+		for x := 0; x < width; x += step {
+			// Get value
+			p := x*stp + offset
+			var v int
+			if depth <= 8 {
+				v = int(row[p])
+			} else if b0, b1 := int(row[p]), int(row[p+1]); be {
+				v = b0<<8 | b1
+			} else {
+				v = b1<<8 | b0
+			}
+			l := float64((v>>shift)&mask) / max
+
+			// Update histogram
+			b := int(l * float64(bins))
+			if b >= bins {
+				b = bins - 1
+			}
+			h.Bins[b]++
+			sum += l
+			n++
+
+			// Update waveform
+			if columns > 0 {
+				c := x * columns / width
+				ws[c] += l
+				wcs[c]++
+			}
+		}
+	}
+
+	// Compute means
+	if n > 0 {
+		h.Mean = sum / float64(n)
+	}
+	if columns > 0 {
+		h.Waveform = make([]float64, columns)
+		for i := range ws {
+			if wcs[i] > 0 {
+				h.Waveform[i] = ws[i] / float64(wcs[i])
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,58 @@
+package astilibav
+
+import (
+	"fmt"
+
+	"github.com/asticode/goav/avformat"
+	"github.com/asticode/goav/avutil"
+)
+
+func dictionaryToMap(d *avutil.Dictionary) (m map[string]string) {
+	m = make(map[string]string)
+	var e *avutil.DictionaryEntry
+	for {
+		if e = avutil.AvDictGet(d, "", e, avDictIgnoreSuffix); e == nil {
+			return
+		}
+		m[e.Key()] = e.Value()
+	}
+}
+
+func dictionarySet(d **avutil.Dictionary, key, value string) (err error) {
+	if ret := avutil.AvDictSet(d, key, value, 0); ret < 0 {
+		err = fmt.Errorf("astilibav: av_dict_set for key %s and value %s failed: %w", key, value, NewAvError(ret))
+		return
+	}
+	return
+}
+
+// FrameMetadata returns the frame metadata such as the ones added by filters (cropdetect, loudnorm, etc.)
+func FrameMetadata(f *avutil.Frame) map[string]string {
+	return dictionaryToMap(*frameMetadataDict(f))
+}
+
+// SetFrameMetadata sets a frame metadata
+// Frame metadata are copied by AvFrameRef and therefore survive dispatching
+func SetFrameMetadata(f *avutil.Frame, key, value string) error {
+	return dictionarySet(frameMetadataDict(f), key, value)
+}
+
+// StreamMetadata returns the stream metadata
+func StreamMetadata(s *avformat.Stream) map[string]string {
+	return dictionaryToMap(s.Metadata())
+}
+
+// SetStreamMetadata sets a stream metadata. For output streams, it must be called before the header is written
+func SetStreamMetadata(s *avformat.Stream, key, value string) error {
+	return dictionarySet(streamMetadataDict(s), key, value)
+}
+
+// FormatMetadata returns the format ctx metadata
+func FormatMetadata(ctxFormat *avformat.Context) map[string]string {
+	return dictionaryToMap(ctxFormat.Metadata())
+}
+
+// SetFormatMetadata sets a format ctx metadata. For outputs, it must be called before the header is written
+func SetFormatMetadata(ctxFormat *avformat.Context, key, value string) error {
+	return dictionarySet(formatMetadataDict(ctxFormat), key, value)
+}
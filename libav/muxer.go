@@ -2,39 +2,218 @@ package astilibav
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/asticode/go-astiencoder"
 	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avcodec"
 	"github.com/asticode/goav/avformat"
+	"github.com/asticode/goav/avutil"
 )
 
 var countMuxer uint64
 
+// Muxer format flags
+const (
+	MuxerFormatFlagBitexact     = avFmtFlagBitexact
+	MuxerFormatFlagFlushPackets = avFmtFlagFlushPackets
+)
+
+// MuxerFormatFlags are the format context flags that can safely be added once the format context has been allocated.
+// Output format flags such as AVFMT_GLOBALHEADER or AVFMT_NOFILE are read-only and can't be changed, and flags such
+// as AVFMT_FLAG_GENPTS only apply to demuxing.
+const MuxerFormatFlags = MuxerFormatFlagBitexact | MuxerFormatFlagFlushPackets
+
+// MuxerPausedQueueOverflowPolicy represents what happens when the paused queue reaches its max size
+type MuxerPausedQueueOverflowPolicy string
+
+// Muxer paused queue overflow policies
+const (
+	// Incoming packets are dropped
+	MuxerPausedQueueOverflowPolicyDrop MuxerPausedQueueOverflowPolicy = "drop"
+	// Incoming packets block the caller until the muxer is continued
+	MuxerPausedQueueOverflowPolicyBlock MuxerPausedQueueOverflowPolicy = "block"
+)
+
+// MuxerLateStreamPolicy represents what happens to packets of streams added after the header has been written
+type MuxerLateStreamPolicy string
+
+// Muxer late stream policies
+const (
+	// Packets of streams added after the header has been written are dropped
+	MuxerLateStreamPolicyDrop MuxerLateStreamPolicy = "drop"
+	// Packets of streams added after the header has been written are dropped and an error is emitted
+	MuxerLateStreamPolicyError MuxerLateStreamPolicy = "error"
+)
+
 // Muxer represents an object capable of muxing packets into an output
 type Muxer struct {
 	*astiencoder.BaseNode
+	bsfHandlers       []*MuxerPktHandler
+	bytes             int64
 	c                 *astikit.Chan
 	cl                *astikit.Closer
 	ctxFormat         *avformat.Context
+	dict              *Dict
 	eh                *astiencoder.EventHandler
+	expectedStreams   int
+	handlerStreams    map[int]bool
+	headerCh          chan struct{}
+	headerErr         error
+	headerStreams     int
+	headerWritten     bool
+	lateStreamPolicy  MuxerLateStreamPolicy
+	lateStreams       map[int]bool
+	maxPktAt          time.Duration
+	minPktAt          *time.Duration
+	mh                *sync.Mutex // Locks bsfHandlers, handlerStreams, headerWritten, format ctx metadata and stream metadata
+	mp                *sync.Mutex // Locks pausedQueueFull
 	o                 *sync.Once
 	p                 *pktPool
+	pausedQueue       MuxerPausedQueueOptions
+	pausedQueueFull   bool
+	queued            int64
 	restamper         PktRestamper
+	requestedTBs      map[*avformat.Stream]avutil.Rational
+	segmenter         *muxerSegmenter
 	statIncomingRate  *astikit.CounterRateStat
+	statPausedRatio   *astikit.DurationPercentageStat
 	statProcessedRate *astikit.CounterRateStat
+	tmpPath           string
+	trailerWritten    bool
+	url               string
+	verify            *MuxerVerifyOptions
+	w                 *avIOWriter
 }
 
 // MuxerOptions represents muxer options
 type MuxerOptions struct {
-	Format     *avformat.OutputFormat
-	FormatName string
-	Node       astiencoder.NodeOptions
-	Restamper  PktRestamper
-	URL        string
+	// If true and the URL is a local file, the output is written to a temporary file in the same directory
+	// which is renamed to the URL once the trailer has been written successfully, and removed otherwise.
+	// It's ignored for non-file URLs
+	Atomic bool
+	// Options used when opening the output and writing the header (e.g. protocol timeouts or muxer options)
+	Dict *Dict
+	// If > 0, the header is not written when the muxer starts but as soon as pkt handlers have been created for
+	// this number of streams, or when WriteHeader is called. Pkts received in the meantime are queued
+	ExpectedStreams int
+	// If true, "faststart" is added to the header "movflags" so that mov/mp4 muxers move the index to the
+	// beginning of the file once the trailer has been written, which is needed for progressive download.
+	// libav re-reads the output through its URL to do so, therefore it can't be used with Atomic, Writer or Segment
+	FastStart bool
+	// Output format. If nil, it's guessed from FormatName or, if empty, from the URL extension.
+	// FormatName is required for URLs without extension such as pipes or custom schemes
+	Format *avformat.OutputFormat
+	// Additional MuxerFormatFlag* flags added to the format context before the header is written
+	// Only flags present in MuxerFormatFlags are allowed
+	// MuxerFormatFlagFlushPackets is useful for low latency live outputs
+	FormatFlags int
+	FormatName  string
+	// Options only used when writing the header, parsed with "=" and "," as separators. They override Dict
+	// (e.g. "movflags=frag_keyframe+empty_moov")
+	HeaderOptions string
+	// What happens to packets of streams added after the header has been written, which libav muxers
+	// don't support. See constants with pattern MuxerLateStreamPolicy*. Default is MuxerLateStreamPolicyDrop
+	LateStreamPolicy MuxerLateStreamPolicy
+	// If > 0, a new output file is started on the first keyframe (see MuxerSegmentOptions.Duration) received once
+	// the current one has reached this size in bytes. Files are written to Segment.PatternURL if set, otherwise
+	// the first file is written to URL and the following ones get an incrementing suffix (e.g. "out_1.mp4").
+	// It's handled like Segment and closed files trigger the same events and callbacks
+	MaxBytes int64
+	// Container metadata (e.g. title or artist) written with the header
+	Metadata map[string]string
+	Node     astiencoder.NodeOptions
+	// Options used to retry opening the output when it's not ready yet (e.g. a live server starting late)
+	OpenRetry MuxerOpenRetryOptions
+	// Options of the queue filled with incoming packets while the muxer is paused
+	PausedQueue MuxerPausedQueueOptions
+	Restamper   PktRestamper
+	// If Segment.Duration > 0, the output is split into segments written to Segment.PatternURL. Atomic and
+	// VerifyOnFinish are ignored and Writer can't be set. URL is only used to guess the output format and
+	// defaults to Segment.PatternURL
+	Segment MuxerSegmentOptions
+	URL     string
+	// If true, the output is re-opened and probed once the trailer has been written. It's ignored
+	// if Writer is set
+	VerifyOnFinish bool
+	// Verify options. Only used if VerifyOnFinish is true
+	Verify MuxerVerifyOptions
+	// If set, the output is written into it instead of URL. FormatName or Format must be provided.
+	// Formats seeking into the output (e.g. non-fragmented mp4) require it to implement io.Seeker.
+	// If it has a Flush method (e.g. *bufio.Writer or http.ResponseWriter), it's called once the trailer has been written
+	Writer io.Writer
+	// Size of the buffer used when writing into Writer. Default is 32KB
+	WriterBufferSize int
+}
+
+// MuxerOpenRetryOptions represents muxer open retry options
+type MuxerOpenRetryOptions struct {
+	// Delay before the first retry. It's doubled after each failed attempt, up to MaxBackoff if > 0
+	Backoff time.Duration
+	// Context used to cancel retries. Default is context.Background()
+	Ctx context.Context
+	// Max number of attempts. 0 or 1 means the output is opened only once
+	MaxAttempts int
+	MaxBackoff  time.Duration
+}
+
+// MuxerOpenAttempt represents an attempt to open the muxer output
+type MuxerOpenAttempt struct {
+	Attempt     int
+	Err         error
+	MaxAttempts int
+	URL         string
+}
+
+// MuxerPausedQueueOptions represents muxer paused queue options
+type MuxerPausedQueueOptions struct {
+	// Max number of packets queued while the muxer is paused. 0 means unbounded
+	MaxSize int
+	// What happens when the max size is reached. See constants with pattern MuxerPausedQueueOverflowPolicy*
+	// Default is MuxerPausedQueueOverflowPolicyDrop
+	OverflowPolicy MuxerPausedQueueOverflowPolicy
+}
+
+// MuxerVerifyOptions represents muxer verify options
+type MuxerVerifyOptions struct {
+	// Maximum accepted difference between the probed duration and the duration of packets written.
+	// Default is 1s
+	DurationTolerance time.Duration
+}
+
+// MuxerClosed represents a muxer output whose trailer has been written successfully
+type MuxerClosed struct {
+	// Number of bytes written, including all segments. It's 0 for outputs not written into avio
+	Bytes int64
+	// Duration between the start of the first pkt and the end of the last pkt
+	Duration time.Duration
+	URL      string
+}
+
+// MuxerTimeBaseMismatch represents a stream whose time base has been changed by libav when writing the header
+type MuxerTimeBaseMismatch struct {
+	Actual      avutil.Rational
+	Requested   avutil.Rational
+	StreamIndex int
+}
+
+// MuxerVerification represents the result of a muxer verification
+type MuxerVerification struct {
+	Duration         time.Duration
+	Err              error
+	ExpectedDuration time.Duration
+	StreamsCount     int
+	URL              string
 }
 
 // NewMuxer creates a new muxer
@@ -47,12 +226,51 @@ func NewMuxer(o MuxerOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s
 	m = &Muxer{
 		c:                 astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
 		cl:                c,
+		dict:              o.Dict,
 		eh:                eh,
+		expectedStreams:   o.ExpectedStreams,
+		handlerStreams:    make(map[int]bool),
+		headerCh:          make(chan struct{}),
+		lateStreamPolicy:  o.LateStreamPolicy,
+		lateStreams:       make(map[int]bool),
+		mh:                &sync.Mutex{},
+		mp:                &sync.Mutex{},
 		o:                 &sync.Once{},
 		p:                 newPktPool(c),
+		pausedQueue:       o.PausedQueue,
+		requestedTBs:      make(map[*avformat.Stream]avutil.Rational),
 		restamper:         o.Restamper,
 		statIncomingRate:  astikit.NewCounterRateStat(),
+		statPausedRatio:   astikit.NewDurationPercentageStat(),
 		statProcessedRate: astikit.NewCounterRateStat(),
+		url:               o.URL,
+	}
+
+	// Segment
+	if o.Segment.Duration > 0 || o.MaxBytes > 0 {
+		if o.Segment.Duration > 0 && o.Segment.PatternURL == "" {
+			err = errors.New("astilibav: segment pattern url is empty")
+			return
+		} else if o.URL == "" && o.Segment.PatternURL == "" {
+			err = errors.New("astilibav: url is empty")
+			return
+		} else if o.Writer != nil {
+			err = errors.New("astilibav: segments can't be written into a writer")
+			return
+		}
+		if o.URL == "" {
+			o.URL = o.Segment.PatternURL
+		}
+		m.segmenter = newMuxerSegmenter(o)
+		m.url = m.segmenter.url
+	}
+
+	// Verify
+	if o.VerifyOnFinish && o.Writer == nil && m.segmenter == nil {
+		m.verify = &o.Verify
+		if m.verify.DurationTolerance <= 0 {
+			m.verify.DurationTolerance = time.Second
+		}
 	}
 
 	// Create base node
@@ -61,6 +279,71 @@ func NewMuxer(o MuxerOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s
 	// Add stats
 	m.addStats()
 
+	// Check paused queue overflow policy
+	switch m.pausedQueue.OverflowPolicy {
+	case "":
+		m.pausedQueue.OverflowPolicy = MuxerPausedQueueOverflowPolicyDrop
+	case MuxerPausedQueueOverflowPolicyBlock, MuxerPausedQueueOverflowPolicyDrop:
+	default:
+		err = fmt.Errorf("astilibav: invalid paused queue overflow policy %s", m.pausedQueue.OverflowPolicy)
+		return
+	}
+
+	// Check late stream policy
+	switch m.lateStreamPolicy {
+	case "":
+		m.lateStreamPolicy = MuxerLateStreamPolicyDrop
+	case MuxerLateStreamPolicyDrop, MuxerLateStreamPolicyError:
+	default:
+		err = fmt.Errorf("astilibav: invalid late stream policy %s", m.lateStreamPolicy)
+		return
+	}
+
+	// Add header options
+	if o.HeaderOptions != "" {
+		d := NewDefaultDict(o.HeaderOptions)
+		var dict *avutil.Dictionary
+		err = d.Parse(&dict)
+		avutil.AvDictFree(&dict)
+		if err != nil {
+			err = fmt.Errorf("astilibav: parsing header options failed: %w", err)
+			return
+		}
+		m.dict = NewDictWithDefaults(o.Dict, d)
+	}
+
+	// Add faststart
+	if o.FastStart {
+		if o.Writer != nil {
+			err = errors.New("astilibav: faststart can't be used with a writer")
+			return
+		} else if m.segmenter != nil {
+			err = errors.New("astilibav: faststart can't be used with segments")
+			return
+		} else if o.Atomic {
+			err = errors.New("astilibav: faststart can't be used with atomic writes")
+			return
+		}
+		if m.dict, err = muxerFastStartDict(m.dict); err != nil {
+			err = fmt.Errorf("astilibav: adding faststart failed: %w", err)
+			return
+		}
+	}
+
+	// Check format flags
+	if o.FormatFlags&^MuxerFormatFlags != 0 {
+		err = fmt.Errorf("astilibav: format flags 0x%x are not allowed", o.FormatFlags&^MuxerFormatFlags)
+		return
+	}
+
+	// Get output format
+	if o.Format == nil {
+		if o.Format, err = GuessOutputFormat(o.URL, o.FormatName); err != nil {
+			err = fmt.Errorf("astilibav: getting output format failed: %w", err)
+			return
+		}
+	}
+
 	// Alloc format context
 	// We need to create an intermediate variable to avoid "cgo argument has Go pointer to Go pointer" errors
 	var ctxFormat *avformat.Context
@@ -76,12 +359,94 @@ func NewMuxer(o MuxerOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s
 		return nil
 	})
 
+	// Attribute logs
+	c.Add(logParents.add(unsafe.Pointer(m.ctxFormat), m))
+
+	// Set metadata
+	for k, v := range o.Metadata {
+		if err = SetFormatMetadata(m.ctxFormat, k, v); err != nil {
+			err = fmt.Errorf("astilibav: setting metadata failed: %w", err)
+			return
+		}
+	}
+
+	// Set format flags
+	if o.FormatFlags != 0 {
+		setFormatContextFlags(m.ctxFormat, m.ctxFormat.Flags()|o.FormatFlags)
+	}
+
+	// Output is a writer
+	if o.Writer != nil {
+		// Format doesn't write into avio
+		if m.ctxFormat.Oformat().Flags()&avformat.AVFMT_NOFILE > 0 {
+			err = errors.New("astilibav: output format doesn't support writers")
+			return
+		}
+
+		// Format needs to seek into the output
+		if _, ok := o.Writer.(io.Seeker); !ok {
+			var requiresSeek bool
+			if requiresSeek, err = avIOWriterRequiresSeek(m.ctxFormat, m.dict); err != nil {
+				err = fmt.Errorf("astilibav: checking whether output format requires seek failed: %w", err)
+				return
+			} else if requiresSeek {
+				err = errors.New("astilibav: output format requires a writer implementing io.Seeker unless it's fragmented")
+				return
+			}
+		}
+
+		// Get buffer size
+		if o.WriterBufferSize <= 0 {
+			o.WriterBufferSize = 32 * 1024
+		}
+
+		// Create avio writer
+		var w *avIOWriter
+		if w, err = newAvIOWriter(o.Writer, o.WriterBufferSize); err != nil {
+			err = fmt.Errorf("astilibav: creating avio writer failed: %w", err)
+			return
+		}
+
+		// Set pb
+		m.ctxFormat.SetPb(w.avIOContext())
+		m.w = w
+
+		// Make sure the avio writer is properly closed
+		c.Add(func() error {
+			w.close()
+			return nil
+		})
+		return
+	}
+
+	// Format doesn't write into avio
+	if m.segmenter != nil && m.ctxFormat.Oformat().Flags()&avformat.AVFMT_NOFILE > 0 {
+		err = errors.New("astilibav: output format doesn't support segments")
+		return
+	}
+
+	// Make sure the format ctx of the last segment is properly freed
+	if m.segmenter != nil {
+		c.Add(func() error {
+			m.segmenter.free()
+			return nil
+		})
+	}
+
 	// This is a file
-	if m.ctxFormat.Flags()&avformat.AVFMT_NOFILE == 0 {
+	if m.ctxFormat.Oformat().Flags()&avformat.AVFMT_NOFILE == 0 {
+		// Get url written to
+		url := o.URL
+		if m.segmenter != nil {
+			url = m.segmenter.url
+		} else if p, ok := localFilePath(o.URL); ok && o.Atomic {
+			m.tmpPath = filepath.Join(filepath.Dir(p), "."+filepath.Base(p)+".tmp")
+			url = m.tmpPath
+		}
+
 		// Open
 		var ctxAvIO *avformat.AvIOContext
-		if ret := avformat.AvIOOpen(&ctxAvIO, o.URL, avformat.AVIO_FLAG_WRITE); ret < 0 {
-			err = fmt.Errorf("astilibav: avformat.AvIOOpen on %+v failed: %w", o, NewAvError(ret))
+		if ctxAvIO, err = m.openAvIO(o, url); err != nil {
 			return
 		}
 
@@ -89,16 +454,306 @@ func NewMuxer(o MuxerOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s
 		m.ctxFormat.SetPb(ctxAvIO)
 
 		// Make sure the avio ctx is properly closed
+		// The avio ctx is read from the output format ctx since it's replaced every time a new segment starts
 		c.Add(func() error {
+			ctxFormat := m.outputCtxFormat()
+			ctxAvIO := ctxFormat.Pb()
+			if ctxAvIO == nil {
+				return nil
+			}
+			ctxFormat.SetPb(nil)
 			if ret := avformat.AvIOClosep(&ctxAvIO); ret < 0 {
 				return fmt.Errorf("astilibav: avformat.AvIOClosep on %+v failed: %w", o, NewAvError(ret))
 			}
+
+			// Finalize atomic write
+			if m.tmpPath != "" {
+				return m.finalizeAtomicWrite()
+			}
 			return nil
 		})
 	}
 	return
 }
 
+// muxerFastStartDict adds "faststart" to the "movflags" of the dict while keeping existing flags
+func muxerFastStartDict(d *Dict) (*Dict, error) {
+	// Get existing flags
+	flags := "+faststart"
+	if d != nil {
+		// Parse dict
+		var dict *avutil.Dictionary
+		defer avutil.AvDictFree(&dict)
+		if err := d.Parse(&dict); err != nil {
+			return nil, fmt.Errorf("astilibav: parsing dict failed: %w", err)
+		}
+
+		// Append flag
+		if e := avutil.AvDictGet(dict, "movflags", nil, 0); e != nil && e.Value() != "" {
+			flags = e.Value() + flags
+		}
+	}
+	return NewDictWithDefaults(d, NewDefaultDict("movflags="+flags)), nil
+}
+
+var urlProtocolRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]+:`)
+
+// localFilePath returns the local path of the url if it's handled by libav's file protocol
+func localFilePath(url string) (string, bool) {
+	if strings.HasPrefix(url, "file:") {
+		return strings.TrimPrefix(url, "file:"), true
+	}
+	if urlProtocolRegexp.MatchString(url) {
+		return "", false
+	}
+	return url, true
+}
+
+func (m *Muxer) finalizeAtomicWrite() error {
+	// Trailer has not been written successfully
+	if !m.trailerWritten {
+		if err := os.Remove(m.tmpPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("astilibav: removing %s failed: %w", m.tmpPath, err)
+		}
+		return nil
+	}
+
+	// Rename
+	p, _ := localFilePath(m.url)
+	if err := os.Rename(m.tmpPath, p); err != nil {
+		return fmt.Errorf("astilibav: renaming %s to %s failed: %w", m.tmpPath, p, err)
+	}
+	return nil
+}
+
+func (m *Muxer) openAvIO(o MuxerOptions, url string) (ctxAvIO *avformat.AvIOContext, err error) {
+	// Get retry options
+	r := o.OpenRetry
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = 1
+	}
+	if r.Ctx == nil {
+		r.Ctx = context.Background()
+	}
+	backoff := r.Backoff
+
+	// Loop
+	for attempt := 1; ; attempt++ {
+		// Open
+		err = m.openAvIOOnce(&ctxAvIO, o, url)
+
+		// Send attempt event
+		m.eh.Emit(astiencoder.Event{
+			Name: EventNameMuxerOpenAttempt,
+			Payload: MuxerOpenAttempt{
+				Attempt:     attempt,
+				Err:         err,
+				MaxAttempts: r.MaxAttempts,
+				URL:         o.URL,
+			},
+			Target: m,
+		})
+
+		// Success or no more attempts
+		if err == nil || attempt >= r.MaxAttempts {
+			return
+		}
+
+		// Sleep
+		if errSleep := astikit.Sleep(r.Ctx, backoff); errSleep != nil {
+			err = fmt.Errorf("astilibav: retrying to open %s has been cancelled: %w", o.URL, errSleep)
+			return
+		}
+
+		// Increase backoff
+		if backoff *= 2; r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+			backoff = r.MaxBackoff
+		}
+	}
+}
+
+func (m *Muxer) openAvIOOnce(ctxAvIO **avformat.AvIOContext, o MuxerOptions, url string) (err error) {
+	// Dict
+	var dict *avutil.Dictionary
+	defer avutil.AvDictFree(&dict)
+	if o.Dict != nil {
+		if err = o.Dict.Parse(&dict); err != nil {
+			err = fmt.Errorf("astilibav: parsing dict failed: %w", err)
+			return
+		}
+	}
+
+	// Open
+	if ret := avIOOpen2(ctxAvIO, url, avformat.AVIO_FLAG_WRITE, &dict); ret < 0 {
+		err = fmt.Errorf("astilibav: avio_open2 on %+v failed: %w", o, NewAvError(ret))
+		return
+	}
+	return
+}
+
+// SetMetadata sets a container metadata. It must be called before the header is written, i.e. before the muxer
+// is started
+func (m *Muxer) SetMetadata(key, value string) error {
+	m.mh.Lock()
+	defer m.mh.Unlock()
+	if m.headerWritten {
+		return errors.New("astilibav: header has already been written")
+	}
+	return SetFormatMetadata(m.ctxFormat, key, value)
+}
+
+// SetStreamMetadata sets metadata of an output stream (e.g. language or title). It must be called before the
+// header is written, i.e. before the muxer is started
+func (m *Muxer) SetStreamMetadata(s *avformat.Stream, md map[string]string) error {
+	m.mh.Lock()
+	defer m.mh.Unlock()
+	if m.headerWritten {
+		return errors.New("astilibav: header has already been written")
+	}
+	for k, v := range md {
+		if err := SetStreamMetadata(s, k, v); err != nil {
+			return fmt.Errorf("astilibav: setting stream metadata failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// WriteHeader writes the header if it hasn't been written yet. It's only needed when ExpectedStreams is set and
+// fewer streams than expected are eventually muxed. Streams added afterwards are handled based on LateStreamPolicy
+func (m *Muxer) WriteHeader() error {
+	m.mh.Lock()
+	defer m.mh.Unlock()
+	return m.writeFirstHeader()
+}
+
+// writeFirstHeader must be called with mh locked
+func (m *Muxer) writeFirstHeader() error {
+	m.o.Do(func() {
+		// Make sure to unblock start
+		defer close(m.headerCh)
+
+		// Write header
+		m.headerWritten = true
+		if m.headerErr = m.writeHeader(m.ctxFormat); m.headerErr != nil {
+			return
+		}
+
+		// Store number of streams known by the header
+		m.headerStreams = len(m.ctxFormat.Streams())
+
+		// Check time bases
+		m.checkTimeBases()
+
+		// Init segmenter
+		if m.segmenter != nil {
+			m.segmenter.init(m.ctxFormat)
+		}
+
+		// Write trailer once everything is done
+		m.cl.Add(m.writeTrailer)
+	})
+	return m.headerErr
+}
+
+// writeTrailer emits errors targeting the muxer instead of returning them so that listeners of muxer events know the
+// output is corrupt, and so that they're not reported a second time by whoever closes the closer
+func (m *Muxer) writeTrailer() error {
+	// Last segment has not been opened successfully
+	if m.segmenter != nil && !m.segmenter.opened {
+		return nil
+	}
+
+	// Flush bitstream filters
+	m.mh.Lock()
+	hs := append([]*MuxerPktHandler{}, m.bsfHandlers...)
+	m.mh.Unlock()
+	for _, h := range hs {
+		if err := h.bsf.filter(nil, h.o.TimeBase(), h.o.TimeBase(), h.write); err != nil {
+			m.eh.Emit(astiencoder.EventError(m, fmt.Errorf("astilibav: flushing bitstream filters of stream %d failed: %w", h.o.Index(), err)))
+		}
+	}
+
+	// Write trailer
+	ctxFormat := m.outputCtxFormat()
+	if ret := ctxFormat.AvWriteTrailer(); ret < 0 {
+		emitAvError(m, m.eh, ret, "ctxFormat.AvWriteTrailer on %s failed", ctxFormat.Filename())
+		return nil
+	}
+	m.trailerWritten = true
+
+	// Get closed payload before the output is closed
+	c := MuxerClosed{
+		Bytes: m.bytes,
+		URL:   m.url,
+	}
+	if p := avIOPosition(ctxFormat); p > 0 {
+		c.Bytes += p
+	}
+	if m.minPktAt != nil {
+		c.Duration = m.maxPktAt - *m.minPktAt
+	}
+
+	// Flush writer
+	if m.w != nil {
+		if err := m.w.flush(); err != nil {
+			m.eh.Emit(astiencoder.EventError(m, fmt.Errorf("astilibav: flushing writer failed: %w", err)))
+			return nil
+		}
+	}
+
+	// Close last segment
+	if m.segmenter != nil {
+		m.segmenter.opened = false
+		if m.segmenter.started {
+			m.segmenter.emit(m, m.segmenter.end)
+		}
+	}
+
+	// Verify output
+	if m.verify != nil && m.ctxFormat.Oformat().Flags()&avformat.AVFMT_NOFILE == 0 {
+		if err := m.verifyOutput(); err != nil {
+			m.eh.Emit(astiencoder.EventError(m, fmt.Errorf("astilibav: verifying %s failed: %w", m.url, err)))
+			return nil
+		}
+	}
+
+	// Emit event once everything has succeeded
+	m.eh.Emit(astiencoder.Event{
+		Name:    EventNameMuxerClosed,
+		Payload: c,
+		Target:  m,
+	})
+	return nil
+}
+
+// outputCtxFormat returns the format ctx pkts are written to, which is not the muxer format ctx once the first segment
+// has been closed
+func (m *Muxer) outputCtxFormat() *avformat.Context {
+	if m.segmenter != nil && m.segmenter.ctxFormat != nil {
+		return m.segmenter.ctxFormat
+	}
+	return m.ctxFormat
+}
+
+func (m *Muxer) writeHeader(ctxFormat *avformat.Context) (err error) {
+	// Dict
+	var dict *avutil.Dictionary
+	defer avutil.AvDictFree(&dict)
+	if m.dict != nil {
+		if err = m.dict.Parse(&dict); err != nil {
+			err = fmt.Errorf("astilibav: parsing dict failed: %w", err)
+			return
+		}
+	}
+
+	// Write header
+	if ret := ctxFormat.AvformatWriteHeader(&dict); ret < 0 {
+		err = fmt.Errorf("astilibav: ctxFormat.AvformatWriteHeader on %s failed: %w", ctxFormat.Filename(), NewAvError(ret))
+		return
+	}
+	return
+}
+
 func (m *Muxer) addStats() {
 	// Get stats
 	ss := m.c.Stats()
@@ -112,6 +767,15 @@ func (m *Muxer) addStats() {
 				Unit:        "pps",
 			},
 		},
+		astikit.StatOptions{
+			Handler: m.statPausedRatio,
+			Metadata: &astikit.StatMetadata{
+				Description: "Percentage of time spent paused",
+				Label:       "Paused ratio",
+				Name:        StatNamePausedRatio,
+				Unit:        "%",
+			},
+		},
 		astikit.StatOptions{
 			Handler: m.statProcessedRate,
 			Metadata: &astikit.StatMetadata{
@@ -132,24 +796,37 @@ func (m *Muxer) CtxFormat() *avformat.Context {
 	return m.ctxFormat
 }
 
+// GlobalHeader returns whether encoders connected to the muxer need to set AV_CODEC_FLAG_GLOBAL_HEADER
+func (m *Muxer) GlobalHeader() bool {
+	return FormatRequiresGlobalHeader(m.ctxFormat)
+}
+
+// Drain implements the Drainer interface
+func (m *Muxer) Drain(ctx context.Context) error {
+	return drainChan(ctx, m.BaseNode, m.c)
+}
+
 // Start starts the muxer
 func (m *Muxer) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 	m.BaseNode.Start(ctx, t, func(t *astikit.Task) {
-		// Make sure to write header once
-		var ret int
-		m.o.Do(func() { ret = m.ctxFormat.AvformatWriteHeader(nil) })
-		if ret < 0 {
-			emitAvError(m, m.eh, ret, "m.ctxFormat.AvformatWriteHeader on %s failed", m.ctxFormat.Filename())
+		// Write header unless it's waiting for streams
+		// Errors are handled once the header is ready
+		if m.expectedStreams <= 0 {
+			m.WriteHeader()
+		}
+
+		// Wait for the header
+		select {
+		case <-m.headerCh:
+		case <-m.Context().Done():
 			return
 		}
 
-		// Write trailer once everything is done
-		m.cl.Add(func() error {
-			if ret := m.ctxFormat.AvWriteTrailer(); ret < 0 {
-				return fmt.Errorf("m.ctxFormat.AvWriteTrailer on %s failed: %w", m.ctxFormat.Filename(), NewAvError(ret))
-			}
-			return nil
-		})
+		// Header has not been written successfully
+		if m.headerErr != nil {
+			m.eh.Emit(astiencoder.EventError(m, m.headerErr))
+			return
+		}
 
 		// Make sure to stop the chan properly
 		defer m.c.Stop()
@@ -159,18 +836,231 @@ func (m *Muxer) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 	})
 }
 
+// Flush writes pkts buffered for interleaving. It's executed in the muxer goroutine after pkts already received
+// and blocks until then, which is why the muxer must be started. Pkts received afterwards must keep increasing
+// timestamps per stream
+func (m *Muxer) Flush() error {
+	// Muxer is not started
+	ctx := m.Context()
+	if s := m.Status(); ctx == nil || (s != astiencoder.StatusRunning && s != astiencoder.StatusPaused) {
+		return errors.New("astilibav: muxer is not started")
+	}
+
+	// Add to chan
+	ch := make(chan error, 1)
+	m.c.Add(func() {
+		// Header has not been written successfully or last segment has not been opened successfully
+		if m.headerErr != nil || (m.segmenter != nil && !m.segmenter.opened) {
+			ch <- errors.New("astilibav: output is not opened")
+			return
+		}
+
+		// Flush
+		if ret := m.ctxFormat.AvInterleavedWriteFrame(nil); ret < 0 {
+			ch <- fmt.Errorf("astilibav: m.ctxFormat.AvInterleavedWriteFrame failed: %w", NewAvError(ret))
+			return
+		}
+		ch <- nil
+	})
+
+	// Wait
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("astilibav: flushing has been cancelled: %w", ctx.Err())
+	}
+}
+
+// Pause implements the Starter interface
+func (m *Muxer) Pause() {
+	// Not running
+	if m.Status() != astiencoder.StatusRunning {
+		return
+	}
+
+	// Pause
+	m.BaseNode.Pause()
+
+	// Not paused
+	if m.Status() != astiencoder.StatusPaused {
+		return
+	}
+
+	// Update stat
+	m.statPausedRatio.Begin()
+}
+
+// Continue implements the Starter interface
+func (m *Muxer) Continue() {
+	// Not paused
+	if m.Status() != astiencoder.StatusPaused {
+		return
+	}
+
+	// Continue
+	m.BaseNode.Continue()
+
+	// Update stat
+	m.statPausedRatio.End()
+
+	// Reset paused queue
+	m.mp.Lock()
+	m.pausedQueueFull = false
+	m.mp.Unlock()
+}
+
+// pausedQueueOverflows checks whether the paused queue has reached its max size and emits an event
+// the first time it happens during a pause
+func (m *Muxer) pausedQueueOverflows() bool {
+	// Paused queue is unbounded or muxer is not paused
+	if m.pausedQueue.MaxSize <= 0 || m.Status() != astiencoder.StatusPaused {
+		return false
+	}
+
+	// Paused queue is not full
+	if atomic.LoadInt64(&m.queued) < int64(m.pausedQueue.MaxSize) {
+		return false
+	}
+
+	// Emit event once
+	m.mp.Lock()
+	emit := !m.pausedQueueFull
+	m.pausedQueueFull = true
+	m.mp.Unlock()
+	if emit {
+		m.eh.Emit(astiencoder.Event{
+			Name:    EventNameMuxerPausedQueueFull,
+			Payload: m.pausedQueue.MaxSize,
+			Target:  m,
+		})
+	}
+	return true
+}
+
+// SetStreamTimeBase requests a time base for the stream. It must be called before the muxer is started.
+// libav may pick another time base when writing the header (e.g. 1/1000 for MKV), in which case an
+// EventNameMuxerTimeBaseMismatch event is emitted and the actual time base can be read on the stream
+func (m *Muxer) SetStreamTimeBase(s *avformat.Stream, tb avutil.Rational) {
+	s.SetTimeBase(tb)
+	m.requestedTBs[s] = tb
+}
+
+func (m *Muxer) checkTimeBases() {
+	for s, tb := range m.requestedTBs {
+		// Time base has been kept
+		a := s.TimeBase()
+		if a.Num() == tb.Num() && a.Den() == tb.Den() {
+			continue
+		}
+
+		// Send event
+		m.eh.Emit(astiencoder.Event{
+			Name: EventNameMuxerTimeBaseMismatch,
+			Payload: MuxerTimeBaseMismatch{
+				Actual:      a,
+				Requested:   tb,
+				StreamIndex: s.Index(),
+			},
+			Target: m,
+		})
+	}
+}
+
 // MuxerPktHandler is an object that can handle a pkt for the muxer
 type MuxerPktHandler struct {
 	*Muxer
-	o *avformat.Stream
+	bsf                *bitstreamFilter
+	o                  *avformat.Stream
+	preserveTimestamps bool
+	transform          MuxerPktTransformFunc
+}
+
+// MuxerPktTransformFunc transforms a pkt right before it's written. The pkt has already been rescaled to the
+// stream time base and its stream index has been set.
+// The stream index must be left untouched and timestamps must remain valid in the stream time base (dts
+// increasing, pts >= dts). If drop is true, the pkt is not written
+type MuxerPktTransformFunc func(pkt *avcodec.Packet, s *avformat.Stream) (drop bool)
+
+// MuxerPktHandlerOptions represents muxer pkt handler options
+type MuxerPktHandlerOptions struct {
+	// Bitstream filter chain applied right before pkts are written, as you would use in ffmpeg
+	// (e.g. "h264_mp4toannexb"). Input codec parameters are copied from Stream, whose codec parameters are
+	// then replaced with the output ones
+	BitstreamFilters string
+	// If true, timestamps are not rescaled from the descriptor time base and are written verbatim. Pkts must
+	// already be in the stream time base, which may be changed when writing the header (see
+	// EventNameMuxerTimeBaseMismatch)
+	PreserveTimestamps bool
+	Stream             *avformat.Stream
+	// If set, it runs after the default rescale and stream index logic
+	Transform MuxerPktTransformFunc
 }
 
-// NewHandler creates
-func (m *Muxer) NewPktHandler(o *avformat.Stream) *MuxerPktHandler {
-	return &MuxerPktHandler{
-		Muxer: m,
-		o:     o,
+// NewPktHandler creates a pkt handler writing pkts in the stream
+func (m *Muxer) NewPktHandler(o *avformat.Stream) (*MuxerPktHandler, error) {
+	return m.NewPktHandlerWithOptions(MuxerPktHandlerOptions{Stream: o})
+}
+
+// NewPktHandlerWithOptions creates a pkt handler based on options. If MuxerOptions.ExpectedStreams is reached, the
+// header is written. Handlers can still be created once the header has been written, but pkts of streams unknown to
+// the header are handled based on MuxerOptions.LateStreamPolicy, and bitstream filters are not allowed since they may
+// rewrite extradata
+func (m *Muxer) NewPktHandlerWithOptions(o MuxerPktHandlerOptions) (h *MuxerPktHandler, err error) {
+	// Lock
+	m.mh.Lock()
+	defer m.mh.Unlock()
+
+	// Header has already been written
+	if m.headerWritten && o.BitstreamFilters != "" {
+		err = fmt.Errorf("astilibav: bitstream filters can't be added once the header of %s has been written", m.url)
+		return
+	}
+
+	// Create handler
+	ph := &MuxerPktHandler{
+		Muxer:              m,
+		o:                  o.Stream,
+		preserveTimestamps: o.PreserveTimestamps,
+		transform:          o.Transform,
+	}
+
+	// Create bitstream filters
+	if o.BitstreamFilters != "" {
+		if ph.bsf, err = newBitstreamFilter(bitstreamFilterOptions{
+			CodecParams: o.Stream.CodecParameters(),
+			Filters:     o.BitstreamFilters,
+			TimeBase:    o.Stream.TimeBase(),
+		}, m.p, m.cl); err != nil {
+			err = fmt.Errorf("astilibav: creating bitstream filters failed: %w", err)
+			return
+		}
+
+		// Filters may rewrite extradata, which must be written with the header
+		if err = ph.bsf.copyOutputCodecParameters(o.Stream.CodecParameters()); err != nil {
+			err = fmt.Errorf("astilibav: copying bitstream filters output codec parameters failed: %w", err)
+			return
+		}
+	}
+
+	// Header has not been written yet
+	if !m.headerWritten {
+		// Write header once all expected streams have a handler
+		m.handlerStreams[o.Stream.Index()] = true
+		if m.expectedStreams > 0 && len(m.handlerStreams) >= m.expectedStreams {
+			if err = m.writeFirstHeader(); err != nil {
+				err = fmt.Errorf("astilibav: writing header failed: %w", err)
+				return
+			}
+		}
+	}
+
+	// Bitstream filters need to be flushed
+	if ph.bsf != nil {
+		m.bsfHandlers = append(m.bsfHandlers, ph)
 	}
+	h = ph
+	return
 }
 
 // HandlePkt implements the PktHandler interface
@@ -178,14 +1068,26 @@ func (h *MuxerPktHandler) HandlePkt(p PktHandlerPayload) {
 	// Increment incoming rate
 	h.statIncomingRate.Add(1)
 
+	// Handle paused queue overflow
+	if h.pausedQueueOverflows() {
+		switch h.pausedQueue.OverflowPolicy {
+		case MuxerPausedQueueOverflowPolicyBlock:
+			h.HandlePause()
+		default:
+			return
+		}
+	}
+
 	// Copy pkt
 	pkt := h.p.get()
 	if ret := pkt.AvPacketRef(p.Pkt); ret < 0 {
+		h.p.put(pkt)
 		emitAvError(h, h.eh, ret, "AvPacketRef failed")
 		return
 	}
 
 	// Add to chan
+	atomic.AddInt64(&h.queued, 1)
 	h.c.Add(func() {
 		// Handle pause
 		defer h.HandlePause()
@@ -193,24 +1095,168 @@ func (h *MuxerPktHandler) HandlePkt(p PktHandlerPayload) {
 		// Make sure to close pkt
 		defer h.p.put(pkt)
 
+		// Update queued count
+		atomic.AddInt64(&h.queued, -1)
+
 		// Increment processed rate
 		h.statProcessedRate.Add(1)
 
+		// Stream has been added after the header has been written
+		if h.o.Index() >= h.headerStreams {
+			h.handleLateStream()
+			return
+		}
+
 		// Rescale timestamps
-		pkt.AvPacketRescaleTs(p.Descriptor.TimeBase(), h.o.TimeBase())
+		if !h.preserveTimestamps {
+			pkt.AvPacketRescaleTs(p.Descriptor.TimeBase(), h.o.TimeBase())
+		}
 
 		// Set stream index
 		pkt.SetStreamIndex(h.o.Index())
 
+		// Transform
+		if h.transform != nil {
+			if drop := h.transform(pkt, h.o); drop {
+				return
+			}
+
+			// Stream index has been modified
+			if pkt.StreamIndex() != h.o.Index() {
+				h.eh.Emit(astiencoder.EventError(h, fmt.Errorf("astilibav: transform has changed stream index from %d to %d", h.o.Index(), pkt.StreamIndex())))
+				return
+			}
+		}
+
 		// Restamp
 		if h.restamper != nil {
 			h.restamper.Restamp(pkt)
 		}
 
-		// Write frame
-		if ret := h.ctxFormat.AvInterleavedWriteFrame((*avformat.Packet)(unsafe.Pointer(pkt))); ret < 0 {
-			emitAvError(h, h.eh, ret, "h.ctxFormat.AvInterleavedWriteFrame failed")
+		// Apply bitstream filters
+		if h.bsf != nil {
+			if err := h.bsf.filter(pkt, h.o.TimeBase(), h.o.TimeBase(), h.write); err != nil {
+				h.eh.Emit(astiencoder.EventError(h, fmt.Errorf("astilibav: applying bitstream filters failed: %w", err)))
+			}
 			return
 		}
+
+		// Write
+		h.write(pkt)
 	})
 }
+
+// write is called in the chan goroutine once the pkt is ready to be written
+func (h *MuxerPktHandler) write(pkt *avcodec.Packet) {
+	// Bitstream filters don't know about the stream index
+	pkt.SetStreamIndex(h.o.Index())
+
+	// Handle segments
+	if h.segmenter != nil {
+		if err := h.segmenter.handlePkt(h.Muxer, pkt, h.o); err != nil {
+			h.eh.Emit(astiencoder.EventError(h, err))
+			return
+		}
+	}
+
+	// Store min and max pkt times
+	if pkt.Pts() != avutil.AV_NOPTS_VALUE {
+		if d := time.Duration(avutil.AvRescaleQ(pkt.Pts(), h.o.TimeBase(), nanosecondRational)); h.minPktAt == nil || d < *h.minPktAt {
+			h.minPktAt = &d
+		}
+		if d := time.Duration(avutil.AvRescaleQ(pkt.Pts()+pkt.Duration(), h.o.TimeBase(), nanosecondRational)); d > h.maxPktAt {
+			h.maxPktAt = d
+		}
+	}
+
+	// Segment streams may have a different time base than the muxer streams once their header has been written
+	ctxFormat := h.outputCtxFormat()
+	if ctxFormat != h.ctxFormat {
+		pkt.AvPacketRescaleTs(h.o.TimeBase(), ctxFormat.Streams()[h.o.Index()].TimeBase())
+	}
+
+	// Write frame
+	if ret := ctxFormat.AvInterleavedWriteFrame((*avformat.Packet)(unsafe.Pointer(pkt))); ret < 0 {
+		emitAvError(h, h.eh, ret, "ctxFormat.AvInterleavedWriteFrame failed")
+		return
+	}
+}
+
+// handleLateStream is called in the chan goroutine when a pkt of a stream unknown to the header is received
+func (h *MuxerPktHandler) handleLateStream() {
+	// Event has already been emitted
+	if h.lateStreams[h.o.Index()] {
+		return
+	}
+	h.lateStreams[h.o.Index()] = true
+
+	// Emit event
+	h.eh.Emit(astiencoder.Event{
+		Name:    EventNameMuxerLateStream,
+		Payload: h.o.Index(),
+		Target:  h.Muxer,
+	})
+
+	// Emit error
+	if h.lateStreamPolicy == MuxerLateStreamPolicyError {
+		h.eh.Emit(astiencoder.EventError(h.Muxer, fmt.Errorf("astilibav: stream %d has been added after the header of %s has been written and its packets are dropped", h.o.Index(), h.url)))
+	}
+}
+
+func (m *Muxer) verifyOutput() (err error) {
+	// Create verification
+	v := MuxerVerification{URL: m.url}
+
+	// Outputs may not start at 0
+	if m.minPktAt != nil {
+		v.ExpectedDuration = m.maxPktAt - *m.minPktAt
+	}
+
+	// Make sure to emit verification
+	defer func() {
+		v.Err = err
+		m.eh.Emit(astiencoder.Event{
+			Name:    EventNameMuxerVerified,
+			Payload: v,
+			Target:  m,
+		})
+	}()
+
+	// The output may have been written to a temporary file that has not been renamed yet
+	url := m.url
+	if m.tmpPath != "" {
+		url = m.tmpPath
+	}
+
+	// Open input
+	// We need to create an intermediate variable to avoid "cgo argument has Go pointer to Go pointer" errors
+	ctxFormat := avformat.AvformatAllocContext()
+	if ret := avformat.AvformatOpenInput(&ctxFormat, url, nil, nil); ret < 0 {
+		err = fmt.Errorf("astilibav: avformat.AvformatOpenInput on %s failed: %w", m.url, NewAvError(ret))
+		return
+	}
+	defer avformat.AvformatCloseInput(ctxFormat)
+
+	// Retrieve stream information
+	if ret := ctxFormat.AvformatFindStreamInfo(nil); ret < 0 {
+		err = fmt.Errorf("astilibav: ctxFormat.AvformatFindStreamInfo on %s failed: %w", m.url, NewAvError(ret))
+		return
+	}
+
+	// Check streams count
+	v.StreamsCount = len(ctxFormat.Streams())
+	if e := len(m.ctxFormat.Streams()); v.StreamsCount != e {
+		err = fmt.Errorf("astilibav: %d streams found, expected %d", v.StreamsCount, e)
+		return
+	}
+
+	// Check duration
+	if d := ctxFormat.Duration(); d > 0 {
+		v.Duration = time.Duration(avutil.AvRescaleQ(d, avutil.NewRational(1, avutil.AV_TIME_BASE), nanosecondRational))
+	}
+	if delta := v.Duration - v.ExpectedDuration; delta > m.verify.DurationTolerance || -delta > m.verify.DurationTolerance {
+		err = fmt.Errorf("astilibav: duration is %s, expected %s", v.Duration, v.ExpectedDuration)
+		return
+	}
+	return
+}
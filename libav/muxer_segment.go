@@ -0,0 +1,292 @@
+package astilibav
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avformat"
+	"github.com/asticode/goav/avutil"
+)
+
+// MuxerSegmentOptions represents muxer segment options
+type MuxerSegmentOptions struct {
+	// Min duration of a segment. A new segment starts on the first video keyframe received once it's reached,
+	// or on the first keyframe of any stream if there's no video stream. 0 means the output is not segmented
+	Duration time.Duration
+	// If set, it's called in the muxer goroutine every time a segment has been closed, including the last
+	// partial segment which is closed with the muxer. Path is the local path for file URLs and the URL otherwise.
+	// Timestamps are in nanoseconds
+	Func MuxerSegmentFunc
+	// URL of segments formatted with fmt and the segment index (e.g. "/tmp/segment-%05d.ts")
+	PatternURL string
+}
+
+// MuxerSegmentFunc is called every time a segment has been closed
+type MuxerSegmentFunc func(path string, startTS, endTS int64)
+
+// MuxerSegment represents a segment written by the muxer
+type MuxerSegment struct {
+	End   time.Duration
+	Index int
+	Start time.Duration
+	URL   string
+}
+
+type muxerSegmenter struct {
+	// Format ctx of the current segment. The first segment is written in the muxer format ctx, and following
+	// segments are written in a fresh format ctx since a format ctx can't be reused once its trailer is written
+	ctxFormat    *avformat.Context
+	delLogParent func() error
+	end          time.Duration
+	index        int
+	o            MuxerOptions
+	opened       bool
+	start        time.Duration
+	started      bool
+	url          string
+	videoCut     bool
+}
+
+func newMuxerSegmenter(o MuxerOptions) (s *muxerSegmenter) {
+	s = &muxerSegmenter{o: o}
+	s.url = s.segmentURL(0)
+	return
+}
+
+func (s *muxerSegmenter) segmentURL(idx int) string {
+	// Pattern
+	if s.o.Segment.PatternURL != "" {
+		return fmt.Sprintf(s.o.Segment.PatternURL, idx)
+	}
+
+	// First file is written to the URL and the following ones get an incrementing suffix
+	if idx == 0 {
+		return s.o.URL
+	}
+	ext := filepath.Ext(s.o.URL)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(s.o.URL, ext), idx, ext)
+}
+
+// shouldCut is called on pkts where a segment can be cut
+func (s *muxerSegmenter) shouldCut(m *Muxer, t time.Duration) bool {
+	// Duration has been reached
+	if s.o.Segment.Duration > 0 && t-s.start >= s.o.Segment.Duration {
+		return true
+	}
+
+	// Size has been reached
+	if s.o.MaxBytes > 0 && avIOPosition(m.outputCtxFormat()) >= s.o.MaxBytes {
+		return true
+	}
+	return false
+}
+
+// init is called once the header of the first segment has been written
+func (s *muxerSegmenter) init(ctxFormat *avformat.Context) {
+	s.opened = true
+	for _, st := range ctxFormat.Streams() {
+		if st.CodecParameters().CodecType() == avutil.AVMEDIA_TYPE_VIDEO {
+			s.videoCut = true
+			break
+		}
+	}
+}
+
+// handlePkt is called in the chan goroutine right before the pkt is written and cuts the current segment if needed
+func (s *muxerSegmenter) handlePkt(m *Muxer, pkt *avcodec.Packet, st *avformat.Stream) (err error) {
+	// A previous cut has failed
+	if !s.opened {
+		err = fmt.Errorf("astilibav: segment %s is not opened", s.url)
+		return
+	}
+
+	// Get pkt times
+	ts := pkt.Pts()
+	if ts == avutil.AV_NOPTS_VALUE {
+		ts = pkt.Dts()
+	}
+	t := time.Duration(avutil.AvRescaleQ(ts, st.TimeBase(), nanosecondRational))
+	end := time.Duration(avutil.AvRescaleQ(ts+pkt.Duration(), st.TimeBase(), nanosecondRational))
+
+	// First pkt
+	if !s.started {
+		s.end = end
+		s.start = t
+		s.started = true
+		return
+	}
+
+	// Make sure to update end
+	defer func() {
+		if end > s.end {
+			s.end = end
+		}
+	}()
+
+	// Segment can't be cut on this pkt
+	if pkt.Flags()&avcodec.AV_PKT_FLAG_KEY == 0 ||
+		(s.videoCut && st.CodecParameters().CodecType() != avutil.AVMEDIA_TYPE_VIDEO) ||
+		!s.shouldCut(m, t) {
+		return
+	}
+
+	// Close segment
+	if err = s.close(m, t); err != nil {
+		err = fmt.Errorf("astilibav: closing segment %s failed: %w", s.url, err)
+		return
+	}
+
+	// Open next segment
+	s.index++
+	s.url = s.segmentURL(s.index)
+	if err = s.open(m); err != nil {
+		err = fmt.Errorf("astilibav: opening segment %s failed: %w", s.url, err)
+		return
+	}
+	s.end = end
+	s.start = t
+	return
+}
+
+func (s *muxerSegmenter) close(m *Muxer, end time.Duration) error {
+	// Update status
+	s.opened = false
+
+	// Write trailer
+	ctxFormat := m.outputCtxFormat()
+	if ret := ctxFormat.AvWriteTrailer(); ret < 0 {
+		return fmt.Errorf("astilibav: ctxFormat.AvWriteTrailer failed: %w", NewAvError(ret))
+	}
+
+	// Update bytes written
+	if p := avIOPosition(ctxFormat); p > 0 {
+		m.bytes += p
+	}
+
+	// Close avio ctx
+	ctxAvIO := ctxFormat.Pb()
+	ctxFormat.SetPb(nil)
+	if ret := avformat.AvIOClosep(&ctxAvIO); ret < 0 {
+		return fmt.Errorf("astilibav: avformat.AvIOClosep failed: %w", NewAvError(ret))
+	}
+
+	// Free format ctx
+	s.free()
+
+	// Emit event
+	s.emit(m, end)
+	return nil
+}
+
+func (s *muxerSegmenter) open(m *Muxer) (err error) {
+	// Alloc format ctx
+	// We need to create an intermediate variable to avoid "cgo argument has Go pointer to Go pointer" errors
+	var ctxFormat *avformat.Context
+	if ret := avformat.AvformatAllocOutputContext2(&ctxFormat, m.ctxFormat.Oformat(), "", s.url); ret < 0 {
+		err = fmt.Errorf("astilibav: avformat.AvformatAllocOutputContext2 on %s failed: %w", s.url, NewAvError(ret))
+		return
+	}
+	s.ctxFormat = ctxFormat
+	s.delLogParent = logParents.add(unsafe.Pointer(ctxFormat), m)
+
+	// Make sure to free the format ctx in case of error
+	defer func() {
+		if err != nil {
+			s.free()
+		}
+	}()
+
+	// Copy format ctx
+	if err = s.copyFormatContext(m.ctxFormat, ctxFormat); err != nil {
+		err = fmt.Errorf("astilibav: copying format ctx failed: %w", err)
+		return
+	}
+
+	// Open avio ctx
+	var ctxAvIO *avformat.AvIOContext
+	if ctxAvIO, err = m.openAvIO(s.o, s.url); err != nil {
+		return
+	}
+	ctxFormat.SetPb(ctxAvIO)
+
+	// Write header
+	if err = m.writeHeader(ctxFormat); err != nil {
+		ctxFormat.SetPb(nil)
+		avformat.AvIOClosep(&ctxAvIO)
+		return
+	}
+	s.opened = true
+	return
+}
+
+// copyFormatContext copies what the header needs from the muxer format ctx, as libav's segment muxer does
+func (s *muxerSegmenter) copyFormatContext(i, o *avformat.Context) (err error) {
+	// Copy flags
+	setFormatContextFlags(o, i.Flags())
+
+	// Copy metadata
+	for k, v := range FormatMetadata(i) {
+		if err = SetFormatMetadata(o, k, v); err != nil {
+			err = fmt.Errorf("astilibav: setting metadata failed: %w", err)
+			return
+		}
+	}
+
+	// Copy streams
+	for _, ist := range i.Streams() {
+		// Clone stream
+		var ost *avformat.Stream
+		if ost, err = CloneStream(ist, o); err != nil {
+			err = fmt.Errorf("astilibav: cloning stream %d failed: %w", ist.Index(), err)
+			return
+		}
+		ost.SetTimeBase(ist.TimeBase())
+
+		// Copy metadata
+		for k, v := range StreamMetadata(ist) {
+			if err = SetStreamMetadata(ost, k, v); err != nil {
+				err = fmt.Errorf("astilibav: setting metadata of stream %d failed: %w", ist.Index(), err)
+				return
+			}
+		}
+	}
+	return
+}
+
+// free frees the format ctx of the current segment, if any
+func (s *muxerSegmenter) free() {
+	if s.ctxFormat == nil {
+		return
+	}
+	s.delLogParent()
+	s.ctxFormat.AvformatFreeContext()
+	s.ctxFormat = nil
+}
+
+func (s *muxerSegmenter) emit(m *Muxer, end time.Duration) {
+	// Callback
+	if s.o.Segment.Func != nil {
+		path := s.url
+		if p, ok := localFilePath(s.url); ok {
+			path = p
+		}
+		s.o.Segment.Func(path, int64(s.start), int64(end))
+	}
+
+	// Emit event
+	m.eh.Emit(astiencoder.Event{
+		Name: EventNameMuxerSegmentClosed,
+		Payload: MuxerSegment{
+			End:   end,
+			Index: s.index,
+			Start: s.start,
+			URL:   s.url,
+		},
+		Target: m,
+	})
+}
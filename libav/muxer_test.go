@@ -0,0 +1,167 @@
+package astilibav
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMuxerLateStream(t *testing.T) {
+	// Create temp dir
+	dir, err := ioutil.TempDir("", "astilibav-muxer-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Create closer and event handler
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	var errs, lates int
+	eh.AddForEventName(astiencoder.EventNameError, func(e astiencoder.Event) bool {
+		errs++
+		return false
+	})
+	eh.AddForEventName(EventNameMuxerLateStream, func(e astiencoder.Event) bool {
+		lates++
+		return false
+	})
+
+	// Create muxer
+	m, err := NewMuxer(MuxerOptions{
+		ExpectedStreams:  1,
+		FormatName:       "nut",
+		LateStreamPolicy: MuxerLateStreamPolicyError,
+		URL:              filepath.Join(dir, "output.nut"),
+	}, eh, c, nil)
+	require.NoError(t, err)
+
+	// Create encoder
+	e, err := NewEncoder(EncoderOptions{Ctx: Context{
+		CodecID:      avcodec.AV_CODEC_ID_MPEG4,
+		CodecType:    avutil.AVMEDIA_TYPE_VIDEO,
+		FrameRate:    avutil.NewRational(25, 1),
+		GlobalHeader: m.GlobalHeader(),
+		Height:       16,
+		PixelFormat:  avutil.AV_PIX_FMT_YUV420P,
+		TimeBase:     avutil.NewRational(1, 25),
+		Width:        16,
+	}}, eh, c, nil)
+	require.NoError(t, err)
+
+	// Header is written once the expected stream has a handler
+	s1, err := e.AddStream(m.CtxFormat())
+	require.NoError(t, err)
+	_, err = m.NewPktHandler(s1)
+	require.NoError(t, err)
+
+	// Bitstream filters are not allowed once the header has been written
+	s2, err := e.AddStream(m.CtxFormat())
+	require.NoError(t, err)
+	h, err := m.NewPktHandlerWithOptions(MuxerPktHandlerOptions{
+		BitstreamFilters: "null",
+		Stream:           s2,
+	})
+	assert.Error(t, err)
+	assert.Nil(t, h)
+
+	// Handlers can be created once the header has been written
+	h, err = m.NewPktHandler(s2)
+	require.NoError(t, err)
+
+	// Start
+	w := astikit.NewWorker(astikit.WorkerOptions{})
+	defer w.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.Start(ctx, w.NewTask)
+
+	// Pkts of late streams are dropped and reported once
+	pkt := avcodec.AvPacketAlloc()
+	defer avcodec.AvPacketFree(pkt)
+	require.GreaterOrEqual(t, pkt.AvNewPacket(1), 0)
+	for i := 0; i < 2; i++ {
+		h.HandlePkt(PktHandlerPayload{Pkt: pkt})
+	}
+	require.NoError(t, m.Drain(context.Background()))
+	assert.Equal(t, 1, lates)
+	assert.Equal(t, 1, errs)
+}
+
+func TestMuxerVerifyOnFinish(t *testing.T) {
+	// Create temp dir
+	dir, err := ioutil.TempDir("", "astilibav-muxer-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Create closer and event handler
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	var closed int
+	var errs []error
+	var vs []MuxerVerification
+	eh.AddForEventName(astiencoder.EventNameError, func(e astiencoder.Event) bool {
+		errs = append(errs, e.Payload.(error))
+		return false
+	})
+	eh.AddForEventName(EventNameMuxerClosed, func(e astiencoder.Event) bool {
+		closed++
+		return false
+	})
+	eh.AddForEventName(EventNameMuxerVerified, func(e astiencoder.Event) bool {
+		vs = append(vs, e.Payload.(MuxerVerification))
+		return false
+	})
+
+	// Create muxer
+	m, err := NewMuxer(MuxerOptions{
+		ExpectedStreams: 1,
+		FormatName:      "nut",
+		URL:             filepath.Join(dir, "output.nut"),
+		VerifyOnFinish:  true,
+	}, eh, c, nil)
+	require.NoError(t, err)
+
+	// Create encoder
+	e, err := NewEncoder(EncoderOptions{Ctx: Context{
+		CodecID:      avcodec.AV_CODEC_ID_MPEG4,
+		CodecType:    avutil.AVMEDIA_TYPE_VIDEO,
+		FrameRate:    avutil.NewRational(25, 1),
+		GlobalHeader: m.GlobalHeader(),
+		Height:       16,
+		PixelFormat:  avutil.AV_PIX_FMT_YUV420P,
+		TimeBase:     avutil.NewRational(1, 25),
+		Width:        16,
+	}}, eh, c, nil)
+	require.NoError(t, err)
+
+	// Write header
+	s, err := e.AddStream(m.CtxFormat())
+	require.NoError(t, err)
+	_, err = m.NewPktHandler(s)
+	require.NoError(t, err)
+
+	// Output is shorter than what has been written
+	minPktAt := time.Minute
+	m.minPktAt = &minPktAt
+	m.maxPktAt = time.Hour
+
+	// Close
+	require.NoError(t, c.Close())
+	require.Len(t, vs, 1)
+	assert.Error(t, vs[0].Err)
+	require.Len(t, errs, 1)
+	assert.True(t, errors.Is(errs[0], vs[0].Err))
+	assert.Equal(t, 0, closed)
+}
@@ -0,0 +1,358 @@
+package astilibav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avutil"
+)
+
+var countPadder uint64
+
+// Padder represents an object capable of padding frames to target dimensions, which is useful
+// to letterbox or pillarbox frames
+type Padder struct {
+	*astiencoder.BaseNode
+	c                 *astikit.Chan
+	d                 *frameDispatcher
+	eh                *astiencoder.EventHandler
+	o                 PadderOptions
+	outputCtx         Context
+	p                 *framePool
+	statIncomingRate  *astikit.CounterRateStat
+	statProcessedRate *astikit.CounterRateStat
+}
+
+// PadderColor represents a padder fill color
+type PadderColor struct {
+	B uint8
+	G uint8
+	R uint8
+}
+
+// PadderOptions represents padder options
+type PadderOptions struct {
+	// If true, X and Y are ignored and frames are centered
+	Center bool
+	// Color of the padded area. Default is black
+	Color PadderColor
+	// Height of output frames
+	Height int
+	// Context of the frames coming in
+	InputCtx Context
+	Node     astiencoder.NodeOptions
+	// Width of output frames
+	Width int
+	// Position of incoming frames in output frames
+	X int
+	Y int
+}
+
+// NewPadder creates a new padder
+func NewPadder(o PadderOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (pd *Padder, err error) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countPadder, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("padder_%d", count), fmt.Sprintf("Padder #%d", count), "Pads", "padder")
+
+	// Create padder
+	pd = &Padder{
+		c:                 astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
+		eh:                eh,
+		o:                 o,
+		outputCtx:         o.InputCtx,
+		p:                 newFramePool(c),
+		statIncomingRate:  astikit.NewCounterRateStat(),
+		statProcessedRate: astikit.NewCounterRateStat(),
+	}
+
+	// Create base node
+	pd.BaseNode = astiencoder.NewBaseNode(o.Node, eh, s, pd, astiencoder.EventTypeToNodeEventName)
+
+	// Create frame dispatcher
+	pd.d = newFrameDispatcher(pd, eh, pd.p)
+
+	// Add stats
+	pd.addStats()
+
+	// Check input ctx
+	if o.InputCtx.CodecType != avutil.AVMEDIA_TYPE_VIDEO {
+		err = errors.New("astilibav: input ctx is not video")
+		return
+	}
+
+	// Validate position
+	if o.InputCtx.Width > 0 && o.InputCtx.Height > 0 {
+		if _, _, err = pd.position(o.InputCtx.Width, o.InputCtx.Height, o.InputCtx.PixelFormat); err != nil {
+			err = fmt.Errorf("astilibav: validating position failed: %w", err)
+			return
+		}
+	}
+
+	// Update output ctx
+	pd.outputCtx.Height = o.Height
+	pd.outputCtx.Width = o.Width
+
+	// Frames are written into buffers allocated by the pool
+	pd.p.setBufferCtx(pd.outputCtx)
+	return
+}
+
+func (pd *Padder) addStats() {
+	// Get stats
+	ss := pd.c.Stats()
+	ss = append(ss, pd.d.stats()...)
+	ss = append(ss,
+		astikit.StatOptions{
+			Handler: pd.statIncomingRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames coming in per second",
+				Label:       "Incoming rate",
+				Name:        StatNameIncomingRate,
+				Unit:        "fps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: pd.statProcessedRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames processed per second",
+				Label:       "Processed rate",
+				Name:        StatNameProcessedRate,
+				Unit:        "fps",
+			},
+		},
+	)
+
+	// Add stats
+	pd.BaseNode.AddStats(ss...)
+}
+
+// position returns the position of incoming frames in output frames and makes sure they fit
+func (pd *Padder) position(width, height int, pixFmt avutil.PixelFormat) (x, y int, err error) {
+	// Check dimensions
+	if width > pd.o.Width || height > pd.o.Height {
+		err = fmt.Errorf("astilibav: %dx%d doesn't fit in %dx%d", width, height, pd.o.Width, pd.o.Height)
+		return
+	}
+
+	// Get pixel format descriptor
+	var desc *pixFmtDescriptor
+	if desc, err = padderPixFmtDesc(pixFmt); err != nil {
+		return
+	}
+	alignW, alignH := 1<<uint(desc.log2ChromaW), 1<<uint(desc.log2ChromaH)
+
+	// Get position
+	if pd.o.Center {
+		x = (pd.o.Width - width) / 2 / alignW * alignW
+		y = (pd.o.Height - height) / 2 / alignH * alignH
+	} else {
+		x, y = pd.o.X, pd.o.Y
+	}
+
+	// Check bounds
+	if x < 0 || y < 0 || x+width > pd.o.Width || y+height > pd.o.Height {
+		err = fmt.Errorf("astilibav: %dx%d at position %d:%d is out of %dx%d bounds", width, height, x, y, pd.o.Width, pd.o.Height)
+		return
+	}
+
+	// Check chroma subsampling alignment
+	if x%alignW != 0 || y%alignH != 0 {
+		err = fmt.Errorf("astilibav: position %d:%d is not aligned with chroma subsampling %dx%d", x, y, alignW, alignH)
+		return
+	}
+	return
+}
+
+// padderPixFmtDesc returns the pixel format descriptor if the pixel format can be padded.
+// Only 8 bits planar YUV and gray formats are handled
+func padderPixFmtDesc(pixFmt avutil.PixelFormat) (desc *pixFmtDescriptor, err error) {
+	// Get descriptor
+	if desc = pixFmtDescGet(pixFmt); desc == nil {
+		err = fmt.Errorf("astilibav: no descriptor found for pixel format %v", pixFmt)
+		return
+	}
+
+	// Check flags
+	if desc.flags&(avPixFmtFlagRGB|avPixFmtFlagPal|avPixFmtFlagBitstream|avPixFmtFlagHWAccel) > 0 ||
+		(len(desc.comps) > 1 && desc.flags&avPixFmtFlagPlanar == 0) {
+		err = fmt.Errorf("astilibav: pixel format %s is not planar yuv", desc.name)
+		return
+	}
+
+	// Check components
+	for i, c := range desc.comps {
+		if c.depth != 8 || c.step != 1 || c.offset != 0 || c.shift != 0 || c.plane != i {
+			err = fmt.Errorf("astilibav: pixel format %s is not 8 bits planar", desc.name)
+			return
+		}
+	}
+	return
+}
+
+// OutputCtx returns the output ctx
+func (pd *Padder) OutputCtx() Context {
+	return pd.outputCtx
+}
+
+// Connect implements the FrameHandlerConnector interface
+func (pd *Padder) Connect(h FrameHandler) {
+	// Add handler
+	pd.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(pd, h)
+}
+
+// Disconnect implements the FrameHandlerConnector interface
+func (pd *Padder) Disconnect(h FrameHandler) {
+	// Delete handler
+	pd.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(pd, h)
+}
+
+// Drain implements the Drainer interface
+func (pd *Padder) Drain(ctx context.Context) error {
+	return drainChan(ctx, pd.BaseNode, pd.c)
+}
+
+// Start starts the padder
+func (pd *Padder) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	pd.BaseNode.Start(ctx, t, func(t *astikit.Task) {
+		// Make sure to stop the chan properly
+		defer pd.c.Stop()
+
+		// Start chan
+		pd.c.Start(pd.Context())
+	})
+}
+
+// HandleFrame implements the FrameHandler interface
+func (pd *Padder) HandleFrame(p FrameHandlerPayload) {
+	// Increment incoming rate
+	pd.statIncomingRate.Add(1)
+
+	// Copy frame
+	f := pd.p.get()
+	if ret := avutil.AvFrameRef(f, p.Frame); ret < 0 {
+		pd.p.put(f)
+		emitAvError(pd, pd.eh, ret, "avutil.AvFrameRef failed")
+		return
+	}
+
+	// Add to chan
+	pd.c.Add(func() {
+		// Handle pause
+		defer pd.HandlePause()
+
+		// Make sure to close frame
+		defer pd.p.put(f)
+
+		// Increment processed rate
+		pd.statProcessedRate.Add(1)
+
+		// Pad
+		o, err := pd.pad(f)
+		if err != nil {
+			pd.eh.Emit(astiencoder.EventError(pd, fmt.Errorf("astilibav: padding frame failed: %w", err)))
+			return
+		}
+
+		// Make sure to close output frame
+		defer pd.p.put(o)
+
+		// Dispatch frame
+		pd.d.dispatch(o, p.Descriptor)
+	})
+}
+
+func (pd *Padder) pad(f *avutil.Frame) (o *avutil.Frame, err error) {
+	// Get position
+	var x, y int
+	if x, y, err = pd.position(f.Width(), f.Height(), avutil.PixelFormat(f.Format())); err != nil {
+		err = fmt.Errorf("astilibav: validating position failed: %w", err)
+		return
+	}
+
+	// Get output frame
+	if o, err = pd.p.getWithBuffer(); err != nil {
+		err = fmt.Errorf("astilibav: getting frame failed: %w", err)
+		return
+	}
+
+	// Pad
+	if err = padFrame(o, f, x, y, pd.o.Color); err != nil {
+		pd.p.put(o)
+		o = nil
+		return
+	}
+	return
+}
+
+// padFrame fills the dst frame with the color and copies the src frame at position x:y
+func padFrame(dst, src *avutil.Frame, x, y int, c PadderColor) (err error) {
+	// Check pixel formats
+	if dst.Format() != src.Format() {
+		err = fmt.Errorf("astilibav: output pixel format %d is different from input pixel format %d", dst.Format(), src.Format())
+		return
+	}
+
+	// Copy props
+	if ret := avutil.AvFrameCopyProps(dst, src); ret < 0 {
+		err = fmt.Errorf("astilibav: av_frame_copy_props failed: %w", NewAvError(ret))
+		return
+	}
+
+	// Get pixel format descriptor
+	var desc *pixFmtDescriptor
+	if desc, err = padderPixFmtDesc(avutil.PixelFormat(src.Format())); err != nil {
+		return
+	}
+
+	// Loop through planes
+	vs := c.yuva()
+	for i := range desc.comps {
+		// Get dimensions
+		dw, dh, sw, sh, px, py := dst.Width(), dst.Height(), src.Width(), src.Height(), x, y
+		if i == 1 || i == 2 {
+			dw, sw, px = ceilRShift(dw, desc.log2ChromaW), ceilRShift(sw, desc.log2ChromaW), px>>uint(desc.log2ChromaW)
+			dh, sh, py = ceilRShift(dh, desc.log2ChromaH), ceilRShift(sh, desc.log2ChromaH), py>>uint(desc.log2ChromaH)
+		}
+
+		// Fill
+		fill := make([]byte, dw)
+		for j := range fill {
+			fill[j] = vs[i]
+		}
+		for row := 0; row < dh; row++ {
+			copy(frameRow(dst, i, 0, row, dw), fill)
+		}
+
+		// Copy
+		for row := 0; row < sh; row++ {
+			copy(frameRow(dst, i, px, py+row, sw), frameRow(src, i, 0, row, sw))
+		}
+	}
+	return
+}
+
+func ceilRShift(a, b int) int {
+	return -((-a) >> uint(b))
+}
+
+// yuva converts the color to limited range BT.601 YUV with an opaque alpha
+func (c PadderColor) yuva() [4]uint8 {
+	r, g, b := float64(c.R), float64(c.G), float64(c.B)
+	return [4]uint8{
+		uint8(math.Round(16 + (65.481*r+128.553*g+24.966*b)/255)),
+		uint8(math.Round(128 + (-37.797*r-74.203*g+112*b)/255)),
+		uint8(math.Round(128 + (112*r-93.786*g-18.214*b)/255)),
+		255,
+	}
+}
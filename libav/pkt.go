@@ -31,17 +31,23 @@ type PktHandlerPayload struct {
 
 type pktDispatcher struct {
 	eh               *astiencoder.EventHandler
-	hs               map[string]PktHandler
-	m                *sync.Mutex
+	hs               map[string]*pktDispatcherHandler
+	m                *sync.Mutex // Locks hs and ns
+	ns               []string    // Handler names in connect order
 	n                astiencoder.Node
 	p                *pktPool
 	statOutgoingRate *astikit.CounterRateStat
 }
 
+type pktDispatcherHandler struct {
+	h  PktHandler
+	wg *sync.WaitGroup // Counts in-flight dispatches to this handler
+}
+
 func newPktDispatcher(n astiencoder.Node, eh *astiencoder.EventHandler, p *pktPool) *pktDispatcher {
 	return &pktDispatcher{
 		eh:               eh,
-		hs:               make(map[string]PktHandler),
+		hs:               make(map[string]*pktDispatcherHandler),
 		m:                &sync.Mutex{},
 		n:                n,
 		p:                p,
@@ -52,43 +58,91 @@ func newPktDispatcher(n astiencoder.Node, eh *astiencoder.EventHandler, p *pktPo
 func (d *pktDispatcher) addHandler(h PktHandler) {
 	d.m.Lock()
 	defer d.m.Unlock()
-	d.hs[h.Metadata().Name] = h
+	if _, ok := d.hs[h.Metadata().Name]; !ok {
+		d.ns = append(d.ns, h.Metadata().Name)
+	}
+	d.hs[h.Metadata().Name] = &pktDispatcherHandler{
+		h:  h,
+		wg: &sync.WaitGroup{},
+	}
 }
 
+// delHandler returns once in-flight dispatches to the handler are done so that it doesn't receive new items
+// afterwards. Dispatches to other handlers are not waited for, therefore a synchronous handler can delete any
+// handler but itself
 func (d *pktDispatcher) delHandler(h PktHandler) {
+	// Lock
 	d.m.Lock()
-	defer d.m.Unlock()
+
+	// Get handler
+	dh, ok := d.hs[h.Metadata().Name]
+	if !ok {
+		d.m.Unlock()
+		return
+	}
+
+	// Delete
 	delete(d.hs, h.Metadata().Name)
+	for idx, n := range d.ns {
+		if n == h.Metadata().Name {
+			d.ns = append(d.ns[:idx], d.ns[idx+1:]...)
+			break
+		}
+	}
+
+	// Unlock
+	d.m.Unlock()
+
+	// Wait for in-flight dispatches
+	dh.wg.Wait()
+}
+
+// acquire returns false if the handler has been deleted or replaced since the handlers have been retrieved.
+// Otherwise the dispatch is counted as in-flight until release is called
+func (d *pktDispatcher) acquire(n string, dh *pktDispatcherHandler) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.hs[n] != dh {
+		return false
+	}
+	dh.wg.Add(1)
+	return true
 }
 
 func (d *pktDispatcher) dispatch(pkt *avcodec.Packet, descriptor Descriptor) {
 	// Increment outgoing rate
 	d.statOutgoingRate.Add(1)
 
-	// Get handlers
+	// Get handlers in connect order
 	d.m.Lock()
-	var hs []PktHandler
-	for _, h := range d.hs {
-		v, ok := h.(PktCond)
-		if !ok || v.UsePkt(pkt) {
-			hs = append(hs, h)
+	var ns []string
+	var dhs []*pktDispatcherHandler
+	for _, n := range d.ns {
+		dh := d.hs[n]
+		if v, ok := dh.h.(PktCond); ok && !v.UsePkt(pkt) {
+			continue
 		}
+		ns = append(ns, n)
+		dhs = append(dhs, dh)
 	}
 	d.m.Unlock()
 
-	// No handlers
-	if len(hs) == 0 {
-		return
-	}
-
 	// Loop through handlers
-	for _, h := range hs {
+	for idx, dh := range dhs {
+		// Handler has been deleted in the meantime
+		if !d.acquire(ns[idx], dh) {
+			continue
+		}
+
 		// Handle pkt
-		h.HandlePkt(PktHandlerPayload{
+		dh.h.HandlePkt(PktHandlerPayload{
 			Descriptor: descriptor,
 			Node:       d.n,
 			Pkt:        pkt,
 		})
+
+		// Release
+		dh.wg.Done()
 	}
 }
 
@@ -113,12 +167,13 @@ type PktCond interface {
 
 type pktCond struct {
 	PktHandler
-	i *avformat.Stream
+	// The stream index is stored instead of the stream since the stream is freed when the input is reopened
+	idx int
 }
 
 func newPktCond(i *avformat.Stream, h PktHandler) *pktCond {
 	return &pktCond{
-		i:          i,
+		idx:        i.Index(),
 		PktHandler: h,
 	}
 }
@@ -126,13 +181,13 @@ func newPktCond(i *avformat.Stream, h PktHandler) *pktCond {
 // Metadata implements the NodeDescriptor interface
 func (c *pktCond) Metadata() astiencoder.NodeMetadata {
 	m := c.PktHandler.Metadata()
-	m.Name = fmt.Sprintf("%s_%d", c.PktHandler.Metadata().Name, c.i.Index())
+	m.Name = fmt.Sprintf("%s_%d", c.PktHandler.Metadata().Name, c.idx)
 	return m
 }
 
 // UsePkt implements the PktCond interface
 func (c *pktCond) UsePkt(pkt *avcodec.Packet) bool {
-	return pkt.StreamIndex() == c.i.Index()
+	return pkt.StreamIndex() == c.idx
 }
 
 type pktPool struct {
@@ -103,6 +103,11 @@ func (d *PktDumper) addStats() {
 	d.BaseNode.AddStats(ss...)
 }
 
+// Drain implements the Drainer interface
+func (d *PktDumper) Drain(ctx context.Context) error {
+	return drainChan(ctx, d.BaseNode, d.c)
+}
+
 // Start starts the pkt dumper
 func (d *PktDumper) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 	d.BaseNode.Start(ctx, t, func(t *astikit.Task) {
@@ -122,6 +127,7 @@ func (d *PktDumper) HandlePkt(p PktHandlerPayload) {
 	// Copy pkt
 	pkt := d.p.get()
 	if ret := pkt.AvPacketRef(p.Pkt); ret < 0 {
+		d.p.put(pkt)
 		emitAvError(d, d.eh, ret, "AvPacketRef failed")
 		return
 	}
@@ -0,0 +1,226 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avutil"
+)
+
+var countPktPacer uint64
+
+// PktPacer represents an object capable of dispatching packets at real time based on their dts
+// It is useful to pace an output independently from the speed at which the input is read
+type PktPacer struct {
+	*astiencoder.BaseNode
+	c                 *astikit.Chan
+	clock             Clock
+	d                 *pktDispatcher
+	eh                *astiencoder.EventHandler
+	p                 *pktPool
+	r                 *pktPacerReference
+	statIncomingRate  *astikit.CounterRateStat
+	statProcessedRate *astikit.CounterRateStat
+	statSleepAvg      *astikit.CounterAvgStat
+}
+
+// PktPacerOptions represents pkt pacer options
+type PktPacerOptions struct {
+	// Clock used to sleep. Default is RealClock
+	Clock Clock
+	// Dts deltas bigger than this duration are considered as discontinuities and reset pacing. Default is 10s
+	MaxGap time.Duration
+	Node   astiencoder.NodeOptions
+	// Speed multiplier: 2 dispatches packets twice as fast as real time. Default is 1
+	Speed float64
+}
+
+// NewPktPacer creates a new pkt pacer
+func NewPktPacer(o PktPacerOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (p *PktPacer) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countPktPacer, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("pkt_pacer_%d", count), fmt.Sprintf("Pkt Pacer #%d", count), "Paces packets", "pkt pacer")
+
+	// Default options
+	if o.MaxGap <= 0 {
+		o.MaxGap = 10 * time.Second
+	}
+	if o.Speed <= 0 {
+		o.Speed = 1
+	}
+
+	// Create pkt pacer
+	p = &PktPacer{
+		c:                 astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
+		clock:             clockOrDefault(o.Clock),
+		eh:                eh,
+		p:                 newPktPool(c),
+		r:                 newPktPacerReference(o.MaxGap, o.Speed),
+		statIncomingRate:  astikit.NewCounterRateStat(),
+		statProcessedRate: astikit.NewCounterRateStat(),
+		statSleepAvg:      astikit.NewCounterAvgStat(),
+	}
+
+	// Create base node
+	p.BaseNode = astiencoder.NewBaseNode(o.Node, eh, s, p, astiencoder.EventTypeToNodeEventName)
+
+	// Create pkt dispatcher
+	p.d = newPktDispatcher(p, eh, p.p)
+
+	// Add stats
+	p.addStats()
+	return
+}
+
+func (p *PktPacer) addStats() {
+	// Get stats
+	ss := p.c.Stats()
+	ss = append(ss, p.d.stats()...)
+	ss = append(ss,
+		astikit.StatOptions{
+			Handler: p.statIncomingRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of packets coming in per second",
+				Label:       "Incoming rate",
+				Name:        StatNameIncomingRate,
+				Unit:        "pps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: p.statProcessedRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of packets processed per second",
+				Label:       "Processed rate",
+				Name:        StatNameProcessedRate,
+				Unit:        "pps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: p.statSleepAvg,
+			Metadata: &astikit.StatMetadata{
+				Description: "Average time spent sleeping before dispatching a packet",
+				Label:       "Average sleep",
+				Name:        StatNameAverageSleep,
+				Unit:        "ns",
+			},
+		},
+	)
+
+	// Add stats
+	p.BaseNode.AddStats(ss...)
+}
+
+// Connect implements the PktHandlerConnector interface
+func (p *PktPacer) Connect(h PktHandler) {
+	// Add handler
+	p.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(p, h)
+}
+
+// Disconnect implements the PktHandlerConnector interface
+func (p *PktPacer) Disconnect(h PktHandler) {
+	// Delete handler
+	p.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(p, h)
+}
+
+// Drain implements the Drainer interface
+func (p *PktPacer) Drain(ctx context.Context) error {
+	return drainChan(ctx, p.BaseNode, p.c)
+}
+
+// Start starts the pkt pacer
+func (p *PktPacer) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	p.BaseNode.Start(ctx, t, func(t *astikit.Task) {
+		// Make sure to stop the chan properly
+		defer p.c.Stop()
+
+		// Start chan
+		p.c.Start(p.Context())
+	})
+}
+
+// HandlePkt implements the PktHandler interface
+func (p *PktPacer) HandlePkt(pl PktHandlerPayload) {
+	// Increment incoming rate
+	p.statIncomingRate.Add(1)
+
+	// Copy pkt
+	pkt := p.p.get()
+	if ret := pkt.AvPacketRef(pl.Pkt); ret < 0 {
+		p.p.put(pkt)
+		emitAvError(p, p.eh, ret, "AvPacketRef failed")
+		return
+	}
+
+	// Add to chan
+	p.c.Add(func() {
+		// Handle pause
+		defer p.HandlePause()
+
+		// Make sure to close pkt
+		defer p.p.put(pkt)
+
+		// Increment processed rate
+		p.statProcessedRate.Add(1)
+
+		// Sleep until the packet is due
+		if pkt.Dts() != avutil.AV_NOPTS_VALUE {
+			delay := p.r.delay(p.clock.Now(), time.Duration(avutil.AvRescaleQ(pkt.Dts(), pl.Descriptor.TimeBase(), nanosecondRational)))
+			if delay > 0 {
+				p.clock.Sleep(p.Context(), delay)
+				p.statSleepAvg.Add(float64(delay))
+			} else {
+				p.statSleepAvg.Add(0)
+			}
+		}
+
+		// Context has been cancelled while sleeping
+		if p.Context().Err() != nil {
+			return
+		}
+
+		// Dispatch pkt
+		p.d.dispatch(pkt, pl.Descriptor)
+	})
+}
+
+// pktPacerReference maps dts to wall clock times
+type pktPacerReference struct {
+	at     time.Time
+	dts    time.Duration
+	maxGap time.Duration
+	ok     bool
+	speed  float64
+}
+
+func newPktPacerReference(maxGap time.Duration, speed float64) *pktPacerReference {
+	return &pktPacerReference{
+		maxGap: maxGap,
+		speed:  speed,
+	}
+}
+
+// delay returns how long to wait, from now, before the packet with the provided dts is due
+func (r *pktPacerReference) delay(now time.Time, dts time.Duration) (d time.Duration) {
+	// Get delay
+	if r.ok && dts >= r.dts-r.maxGap {
+		if d = r.at.Add(time.Duration(float64(dts-r.dts) / r.speed)).Sub(now); d <= r.maxGap {
+			return
+		}
+	}
+
+	// Reset reference on first packet and on discontinuities
+	r.at = now
+	r.dts = dts
+	r.ok = true
+	return 0
+}
@@ -0,0 +1,20 @@
+package astilibav
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPktPacerReference(t *testing.T) {
+	r := newPktPacerReference(10*time.Second, 2)
+	n := time.Unix(100, 0)
+	assert.Equal(t, time.Duration(0), r.delay(n, time.Second))
+	assert.Equal(t, time.Second, r.delay(n, 3*time.Second))
+	assert.Equal(t, 500*time.Millisecond, r.delay(n.Add(500*time.Millisecond), 3*time.Second))
+	assert.Equal(t, -500*time.Millisecond, r.delay(n.Add(time.Second), 2*time.Second))
+	assert.Equal(t, time.Duration(0), r.delay(n.Add(time.Second), time.Minute))
+	assert.Equal(t, time.Second, r.delay(n.Add(time.Second), time.Minute+2*time.Second))
+	assert.Equal(t, time.Duration(0), r.delay(n.Add(2*time.Second), 0))
+}
@@ -100,3 +100,42 @@ func (r *pktRestamperWithPktDuration) Restamp(pkt *avcodec.Packet) {
 	pkt.SetDts(item.dts)
 	pkt.SetPts(item.dts + delta)
 }
+
+type pktRestamperMonotonic struct {
+	lastDts map[int]int64
+	m       *sync.Mutex
+}
+
+// NewPktRestamperMonotonic creates a new pkt restamper that makes sure DTS are strictly increasing per stream by
+// clamping regressions to the previous DTS + 1. The PTS-DTS delta is preserved, and PTS are never lower than DTS.
+// PTS are not forced to increase since they legitimately don't with B-frames
+func NewPktRestamperMonotonic() PktRestamper {
+	return &pktRestamperMonotonic{
+		lastDts: make(map[int]int64),
+		m:       &sync.Mutex{},
+	}
+}
+
+// Restamp implements the Restamper interface
+func (r *pktRestamperMonotonic) Restamp(pkt *avcodec.Packet) {
+	// Lock
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	// Compute dts
+	dts := pkt.Dts()
+	if lastDts, ok := r.lastDts[pkt.StreamIndex()]; ok && dts <= lastDts {
+		dts = lastDts + 1
+	}
+	r.lastDts[pkt.StreamIndex()] = dts
+
+	// Compute pts
+	delta := pkt.Pts() - pkt.Dts()
+	if delta < 0 {
+		delta = 0
+	}
+
+	// Restamp
+	pkt.SetDts(dts)
+	pkt.SetPts(dts + delta)
+}
@@ -56,3 +56,23 @@ func TestPktRestamperWithPktDuration(t *testing.T) {
 		assert.Equal(t, ft.outputPts, pkt.Pts())
 	}
 }
+
+func TestPktRestamperMonotonic(t *testing.T) {
+	pkt := avcodec.Packet{}
+	r := NewPktRestamperMonotonic()
+	for _, ft := range []pktTest{
+		{inputDts: 100, inputPts: 102, outputDts: 100, outputPts: 102, streamIdx: 1},
+		{inputDts: 90, inputPts: 90, outputDts: 90, outputPts: 90, streamIdx: 2},
+		{inputDts: 80, inputPts: 83, outputDts: 101, outputPts: 104, streamIdx: 1},
+		{inputDts: 70, inputPts: 70, outputDts: 102, outputPts: 102, streamIdx: 1},
+		{inputDts: 60, inputPts: 60, outputDts: 91, outputPts: 91, streamIdx: 2},
+		{inputDts: 110, inputPts: 108, outputDts: 110, outputPts: 110, streamIdx: 1},
+	} {
+		pkt.SetDts(ft.inputDts)
+		pkt.SetPts(ft.inputPts)
+		pkt.SetStreamIndex(ft.streamIdx)
+		r.Restamp(&pkt)
+		assert.Equal(t, ft.outputDts, pkt.Dts())
+		assert.Equal(t, ft.outputPts, pkt.Pts())
+	}
+}
@@ -20,6 +20,7 @@ type RateEnforcer struct {
 	*astiencoder.BaseNode
 	buf                []*rateEnforcerItem
 	c                  *astikit.Chan
+	clock              Clock
 	d                  *frameDispatcher
 	eh                 *astiencoder.EventHandler
 	f                  RateEnforcerFiller
@@ -58,6 +59,8 @@ type rateEnforcerDescriptor struct {
 
 // RateEnforcerOptions represents rate enforcer options
 type RateEnforcerOptions struct {
+	// Clock used to tick. Default is RealClock
+	Clock Clock
 	// This is expressed in number of frames in the desired FrameRate
 	Delay     uint
 	Filler    RateEnforcerFiller
@@ -76,6 +79,7 @@ func NewRateEnforcer(o RateEnforcerOptions, eh *astiencoder.EventHandler, c *ast
 	// Create rate enforcer
 	r = &RateEnforcer{
 		c:                 astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
+		clock:             clockOrDefault(o.Clock),
 		eh:                eh,
 		f:                 o.Filler,
 		m:                 &sync.Mutex{},
@@ -185,6 +189,11 @@ func (r *RateEnforcer) Disconnect(h FrameHandler) {
 	astiencoder.DisconnectNodes(r, h)
 }
 
+// Drain implements the Drainer interface
+func (r *RateEnforcer) Drain(ctx context.Context) error {
+	return drainChan(ctx, r.BaseNode, r.c)
+}
+
 // Start starts the rate enforcer
 func (r *RateEnforcer) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 	r.BaseNode.Start(ctx, t, func(t *astikit.Task) {
@@ -211,6 +220,7 @@ func (r *RateEnforcer) HandleFrame(p FrameHandlerPayload) {
 	// Copy frame
 	f := r.p.get()
 	if ret := avutil.AvFrameRef(f, p.Frame); ret < 0 {
+		r.p.put(f)
 		emitAvError(r, r.eh, ret, "avutil.AvFrameRef failed")
 		return
 	}
@@ -260,6 +270,7 @@ func (r *RateEnforcer) HandleFrame(p FrameHandlerPayload) {
 		// Copy frame
 		i.f = r.p.get()
 		if ret := avutil.AvFrameRef(i.f, f); ret < 0 {
+			r.p.put(i.f)
 			emitAvError(r, r.eh, ret, "avutil.AvFrameRef failed")
 			return
 		}
@@ -315,7 +326,7 @@ func (r *RateEnforcer) startTick(parentCtx context.Context) (ctx context.Context
 		defer cancel()
 
 		// Loop
-		nextAt := time.Now()
+		nextAt := r.clock.Now()
 		var previousNode astiencoder.Node
 		for {
 			if stop := r.tickFunc(parentCtx, &nextAt, &previousNode); stop {
@@ -331,8 +342,8 @@ func (r *RateEnforcer) tickFunc(ctx context.Context, nextAt *time.Time, previous
 	*nextAt = nextAt.Add(r.period)
 
 	// Sleep until next at
-	if delta := time.Until(*nextAt); delta > 0 {
-		astikit.Sleep(ctx, delta)
+	if delta := nextAt.Sub(r.clock.Now()); delta > 0 {
+		r.clock.Sleep(ctx, delta)
 	}
 
 	// Check context
@@ -0,0 +1,201 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avformat"
+)
+
+var countRecorder uint64
+
+// Recorder represents an object capable of encoding frames and muxing them into an output
+// It hides the encoder and muxer wiring
+type Recorder struct {
+	*astiencoder.BaseNode
+	c                *astikit.Closer
+	e                *Encoder
+	eh               *astiencoder.EventHandler
+	m                *Muxer
+	statIncomingRate *astikit.CounterRateStat
+}
+
+// RecorderOptions represents recorder options
+type RecorderOptions struct {
+	// Context of the encoder. Global header is set automatically based on the output format
+	Ctx        Context
+	Format     *avformat.OutputFormat
+	FormatName string
+	Node       astiencoder.NodeOptions
+	URL        string
+	// If set, encoded data is muxed into it instead of URL. FormatName or Format must be provided
+	Writer io.Writer
+}
+
+// NewRecorder creates a new recorder
+func NewRecorder(o RecorderOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (r *Recorder, err error) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countRecorder, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("recorder_%d", count), fmt.Sprintf("Recorder #%d", count), fmt.Sprintf("Records to %s", o.URL), "recorder")
+
+	// Create recorder
+	r = &Recorder{
+		c:                c.NewChild(),
+		eh:               eh,
+		statIncomingRate: astikit.NewCounterRateStat(),
+	}
+
+	// Create base node
+	r.BaseNode = astiencoder.NewBaseNode(o.Node, eh, s, r, astiencoder.EventTypeToNodeEventName)
+
+	// Add stats
+	r.addStats()
+
+	// Create muxer
+	if r.m, err = NewMuxer(MuxerOptions{
+		Format:     o.Format,
+		FormatName: o.FormatName,
+		URL:        o.URL,
+		Writer:     o.Writer,
+	}, eh, r.c, s); err != nil {
+		err = fmt.Errorf("astilibav: creating muxer failed: %w", err)
+		return
+	}
+
+	// Create encoder
+	o.Ctx.GlobalHeader = r.m.GlobalHeader()
+	if r.e, err = NewEncoder(EncoderOptions{Ctx: o.Ctx}, eh, r.c, s); err != nil {
+		err = fmt.Errorf("astilibav: creating encoder failed: %w", err)
+		return
+	}
+
+	// Add stream
+	var st *avformat.Stream
+	if st, err = r.e.AddStream(r.m.CtxFormat()); err != nil {
+		err = fmt.Errorf("astilibav: adding stream failed: %w", err)
+		return
+	}
+
+	// Create muxer handler
+	var h *MuxerPktHandler
+	if h, err = r.m.NewPktHandler(st); err != nil {
+		err = fmt.Errorf("astilibav: creating muxer handler failed: %w", err)
+		return
+	}
+
+	// Connect encoder to muxer
+	r.e.Connect(h)
+
+	// Add encoder to the graph so that the workflow and its server see it, the muxer being the encoder's child
+	// already. The workflow may therefore start the encoder and the muxer before the recorder
+	astiencoder.ConnectNodes(r, r.e)
+	return
+}
+
+func (r *Recorder) addStats() {
+	r.BaseNode.AddStats(astikit.StatOptions{
+		Handler: r.statIncomingRate,
+		Metadata: &astikit.StatMetadata{
+			Description: "Number of frames coming in per second",
+			Label:       "Incoming rate",
+			Name:        StatNameIncomingRate,
+			Unit:        "fps",
+		},
+	})
+}
+
+// Encoder returns the recorder's encoder
+func (r *Recorder) Encoder() *Encoder {
+	return r.e
+}
+
+// Muxer returns the recorder's muxer
+func (r *Recorder) Muxer() *Muxer {
+	return r.m
+}
+
+// Drain implements the Drainer interface
+func (r *Recorder) Drain(ctx context.Context) error {
+	// Encoder dispatches pkts to the muxer, therefore it needs to be drained first
+	if err := r.e.Drain(ctx); err != nil {
+		return err
+	}
+	return r.m.Drain(ctx)
+}
+
+// Start starts the recorder
+func (r *Recorder) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	r.BaseNode.Start(ctx, t, func(t *astikit.Task) {
+		// Nodes may have been started by the workflow already, in which case starting them here does nothing and
+		// their tasks are not ours. Therefore we wait for the nodes themselves to be stopped
+		waitEncoder := r.waitForStopped(r.e)
+		waitMuxer := r.waitForStopped(r.m)
+
+		// The muxer is stopped by the encoder once the encoder is stopped so that
+		// packets created while flushing the encoder are written as well
+		r.m.Start(context.Background(), t.NewSubTask)
+
+		// Start encoder
+		r.e.Start(r.Context(), t.NewSubTask)
+
+		// Wait for the recorder to be stopped
+		<-r.Context().Done()
+
+		// Stop encoder in case it has not been started with the recorder's context
+		r.e.Stop()
+
+		// Wait for the encoder to be flushed and the muxer to be stopped
+		waitEncoder()
+		waitMuxer()
+		t.Wait()
+
+		// Write trailer and free resources
+		if err := r.c.Close(); err != nil {
+			r.eh.Emit(astiencoder.EventError(r, fmt.Errorf("astilibav: closing recorder failed: %w", err)))
+		}
+	})
+}
+
+// waitForStopped returns a func blocking until the node is stopped
+func (r *Recorder) waitForStopped(n astiencoder.Node) func() {
+	c := make(chan struct{})
+	o := &sync.Once{}
+	del := r.eh.AddDeletable(n, astiencoder.EventNameNodeStopped, func(e astiencoder.Event) bool {
+		o.Do(func() { close(c) })
+		return true
+	})
+	return func() {
+		defer del()
+		if n.Status() != astiencoder.StatusStopped {
+			<-c
+		}
+	}
+}
+
+// Pause implements the Starter interface
+func (r *Recorder) Pause() {
+	r.BaseNode.Pause()
+	r.e.Pause()
+	r.m.Pause()
+}
+
+// Continue implements the Starter interface
+func (r *Recorder) Continue() {
+	r.BaseNode.Continue()
+	r.e.Continue()
+	r.m.Continue()
+}
+
+// HandleFrame implements the FrameHandler interface
+func (r *Recorder) HandleFrame(p FrameHandlerPayload) {
+	// Increment incoming rate
+	r.statIncomingRate.Add(1)
+
+	// Encode
+	r.e.HandleFrame(p)
+}
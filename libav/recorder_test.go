@@ -0,0 +1,101 @@
+package astilibav
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorderGraph(t *testing.T) {
+	// Create temp dir
+	dir, err := ioutil.TempDir("", "astilibav-recorder-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Create recorder
+	c := astikit.NewCloser()
+	defer c.Close()
+	r, err := NewRecorder(RecorderOptions{
+		Ctx: Context{
+			CodecID:     avcodec.AV_CODEC_ID_MPEG4,
+			CodecType:   avutil.AVMEDIA_TYPE_VIDEO,
+			FrameRate:   avutil.NewRational(25, 1),
+			Height:      16,
+			PixelFormat: avutil.AV_PIX_FMT_YUV420P,
+			TimeBase:    avutil.NewRational(1, 25),
+			Width:       16,
+		},
+		FormatName: "nut",
+		URL:        filepath.Join(dir, "output.nut"),
+	}, astiencoder.NewEventHandler(), c, nil)
+	require.NoError(t, err)
+
+	// Encoder and muxer are part of the graph
+	assert.Equal(t, []astiencoder.Node{r.Encoder()}, r.Children())
+	assert.Equal(t, []astiencoder.Node{r}, r.Encoder().Parents())
+	cs := r.Encoder().Children()
+	require.Len(t, cs, 1)
+	assert.Equal(t, r.Muxer().Metadata().Name, cs[0].Metadata().Name)
+}
+
+func TestRecorderStartedByWorkflow(t *testing.T) {
+	// Create temp dir
+	dir, err := ioutil.TempDir("", "astilibav-recorder-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Create workflow
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	wk := astikit.NewWorker(astikit.WorkerOptions{})
+	defer wk.Stop()
+	w := astiencoder.NewWorkflow(context.Background(), "w", eh, wk.NewTask, c)
+
+	// Create recorder
+	r, err := NewRecorder(RecorderOptions{
+		Ctx: Context{
+			CodecID:     avcodec.AV_CODEC_ID_MPEG4,
+			CodecType:   avutil.AVMEDIA_TYPE_VIDEO,
+			FrameRate:   avutil.NewRational(25, 1),
+			Height:      16,
+			PixelFormat: avutil.AV_PIX_FMT_YUV420P,
+			TimeBase:    avutil.NewRational(1, 25),
+			Width:       16,
+		},
+		FormatName: "nut",
+		URL:        filepath.Join(dir, "output.nut"),
+	}, eh, c, nil)
+	require.NoError(t, err)
+	w.AddChild(r)
+
+	// Recorder is stopped once its encoder and its muxer are stopped
+	var ss []string
+	stopped := make(chan struct{})
+	eh.Add(r, astiencoder.EventNameNodeStopped, func(e astiencoder.Event) bool {
+		ss = []string{r.Encoder().Status(), r.Muxer().Status()}
+		close(stopped)
+		return true
+	})
+
+	// Encoder and muxer are started by the workflow before the recorder
+	started := make(chan struct{})
+	eh.Add(r, astiencoder.EventNameNodeStarted, func(e astiencoder.Event) bool {
+		close(started)
+		return true
+	})
+	w.StartWithOptions(astiencoder.WorkflowStartOptions{ChildrenFirst: true})
+	<-started
+	w.Stop()
+	<-stopped
+	assert.Equal(t, []string{astiencoder.StatusStopped, astiencoder.StatusStopped}, ss)
+}
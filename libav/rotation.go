@@ -0,0 +1,45 @@
+package astilibav
+
+import (
+	"errors"
+	"math"
+
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avformat"
+)
+
+// StreamRotation returns the rotation stored in the stream's display matrix side data.
+// It's expressed in degrees counterclockwise in the [-180, 180] range and is 0 if there's no display matrix
+func StreamRotation(s *avformat.Stream) float64 {
+	// Get side data
+	sd := streamSideData(s, avcodec.AV_PKT_DATA_DISPLAYMATRIX)
+	if sd == nil {
+		return 0
+	}
+
+	// Get rotation
+	r := displayRotationGet(sd)
+	if math.IsNaN(r) {
+		return 0
+	}
+	return r
+}
+
+// SetStreamRotation writes the rotation in the stream's display matrix side data so that players apply it.
+// It's expressed in degrees counterclockwise and must be set before the header is written
+func SetStreamRotation(s *avformat.Stream, rotation float64) error {
+	// Nothing to do
+	if rotation == 0 {
+		return nil
+	}
+
+	// Create side data
+	sd := streamNewSideData(s, avcodec.AV_PKT_DATA_DISPLAYMATRIX, 9*4)
+	if sd == nil {
+		return errors.New("astilibav: av_stream_new_side_data failed")
+	}
+
+	// Set rotation
+	displayRotationSet(sd, rotation)
+	return nil
+}
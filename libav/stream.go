@@ -7,6 +7,30 @@ import (
 	"github.com/asticode/goav/avformat"
 )
 
+// FormatRequiresGlobalHeader checks whether the output format of the format ctx requires encoders to
+// set AV_CODEC_FLAG_GLOBAL_HEADER
+func FormatRequiresGlobalHeader(ctxFormat *avformat.Context) bool {
+	return ctxFormat.Oformat() != nil && ctxFormat.Oformat().Flags()&avformat.AVFMT_GLOBALHEADER > 0
+}
+
+// GuessOutputFormat returns the output format matching the format name or, if empty, the url extension
+// It returns a descriptive error when no format matches
+func GuessOutputFormat(url, formatName string) (f *avformat.OutputFormat, err error) {
+	// Format name has been provided
+	if formatName != "" {
+		if f = avformat.AvGuessFormat(formatName, "", ""); f == nil {
+			err = fmt.Errorf("astilibav: no output format with name %s", formatName)
+		}
+		return
+	}
+
+	// Guess from url
+	if f = avformat.AvGuessFormat("", url, ""); f == nil {
+		err = fmt.Errorf("astilibav: output format can't be guessed from url %s since its extension is missing or unknown, a format name must be provided", url)
+	}
+	return
+}
+
 // AddStream adds a stream to the format ctx
 func AddStream(ctxFormat *avformat.Context) *avformat.Stream {
 	return ctxFormat.AvformatNewStream(nil)
@@ -25,5 +49,17 @@ func CloneStream(i *avformat.Stream, ctxFormat *avformat.Context) (o *avformat.S
 
 	// Reset codec tag as shown in https://github.com/FFmpeg/FFmpeg/blob/n4.1.1/doc/examples/remuxing.c#L122
 	o.CodecParameters().SetCodecTag(0)
+
+	// Copy rotation
+	if err = SetStreamRotation(o, StreamRotation(i)); err != nil {
+		err = fmt.Errorf("astilibav: setting stream rotation failed: %w", err)
+		return
+	}
+
+	// Copy HDR metadata
+	if err = SetStreamHDRMetadata(o, StreamHDRMetadata(i)); err != nil {
+		err = fmt.Errorf("astilibav: setting stream hdr metadata failed: %w", err)
+		return
+	}
 	return
 }
@@ -0,0 +1,43 @@
+package astilibav
+
+import (
+	"fmt"
+
+	"github.com/asticode/goav/avformat"
+)
+
+// StreamOptions represents output stream options that players rely on to pick and display tracks
+type StreamOptions struct {
+	// If true, the stream is flagged with AV_DISPOSITION_DEFAULT, otherwise the flag is removed
+	Default bool
+	// ISO 639-2 language code (e.g. "eng" or "spa")
+	Language string
+	Title    string
+}
+
+// SetStreamOptions sets the stream disposition and metadata. It must be called before the header is written
+func SetStreamOptions(s *avformat.Stream, o StreamOptions) (err error) {
+	// Set disposition
+	if o.Default {
+		setStreamDisposition(s, s.Disposition()|avDispositionDefault)
+	} else {
+		setStreamDisposition(s, s.Disposition()&^avDispositionDefault)
+	}
+
+	// Set language
+	if o.Language != "" {
+		if err = SetStreamMetadata(s, "language", o.Language); err != nil {
+			err = fmt.Errorf("astilibav: setting language failed: %w", err)
+			return
+		}
+	}
+
+	// Set title
+	if o.Title != "" {
+		if err = SetStreamMetadata(s, "title", o.Title); err != nil {
+			err = fmt.Errorf("astilibav: setting title failed: %w", err)
+			return
+		}
+	}
+	return
+}
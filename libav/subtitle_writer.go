@@ -0,0 +1,252 @@
+package astilibav
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avformat"
+	"github.com/asticode/goav/avutil"
+)
+
+var countSubtitleWriter uint64
+
+// Default tx3g sample entry used as mov_text extradata, as written by libav's mov_text encoder
+var movTextSampleEntry = []byte{
+	0x00, 0x00, 0x00, 0x00, // Display flags
+	0x01,                   // Horizontal justification
+	0xff,                   // Vertical justification
+	0x00, 0x00, 0x00, 0x00, // Background color
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Box record
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x12, 0xff, 0xff, 0xff, 0xff, // Style record
+	0x00, 0x00, 0x00, 0x12, 'f', 't', 'a', 'b', 0x00, 0x01, 0x00, 0x01, 0x05, 'S', 'e', 'r', 'i', 'f', // Font table
+}
+
+// SubtitleCue represents a timed text cue
+type SubtitleCue struct {
+	End   time.Duration
+	Start time.Duration
+	Text  string
+}
+
+// SubtitleWriter represents an object capable of creating a subtitle stream and dispatching timed text cues
+// as subtitle packets, for instance to a muxer handler created for that stream.
+// WebVTT streams written by the hls muxer are written in sidecar segments by the muxer itself
+type SubtitleWriter struct {
+	*astiencoder.BaseNode
+	c                *astikit.Chan
+	codecID          avcodec.CodecId
+	d                *pktDispatcher
+	eh               *astiencoder.EventHandler
+	p                *pktPool
+	statIncomingRate *astikit.CounterRateStat
+	timeBase         avutil.Rational
+}
+
+// SubtitleWriterOptions represents subtitle writer options
+type SubtitleWriterOptions struct {
+	// Either avcodec.AV_CODEC_ID_MOV_TEXT or avcodec.AV_CODEC_ID_WEBVTT. If 0, it's guessed
+	// from the output format when adding the stream
+	CodecID avcodec.CodecId
+	Node    astiencoder.NodeOptions
+	// Default is 1/1000
+	TimeBase avutil.Rational
+}
+
+// NewSubtitleWriter creates a new subtitle writer
+func NewSubtitleWriter(o SubtitleWriterOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (w *SubtitleWriter, err error) {
+	// Check codec id
+	switch o.CodecID {
+	case 0, avcodec.CodecId(avcodec.AV_CODEC_ID_MOV_TEXT), avcodec.CodecId(avcodec.AV_CODEC_ID_WEBVTT):
+	default:
+		err = fmt.Errorf("astilibav: codec id %v is not supported", o.CodecID)
+		return
+	}
+
+	// Default time base
+	if o.TimeBase.Num() <= 0 || o.TimeBase.Den() <= 0 {
+		o.TimeBase = avutil.NewRational(1, 1000)
+	}
+
+	// Extend node metadata
+	count := atomic.AddUint64(&countSubtitleWriter, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("subtitle_writer_%d", count), fmt.Sprintf("Subtitle writer #%d", count), "Writes subtitles", "subtitle writer")
+
+	// Create subtitle writer
+	w = &SubtitleWriter{
+		c:                astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
+		codecID:          o.CodecID,
+		eh:               eh,
+		p:                newPktPool(c),
+		statIncomingRate: astikit.NewCounterRateStat(),
+		timeBase:         o.TimeBase,
+	}
+
+	// Create base node
+	w.BaseNode = astiencoder.NewBaseNode(o.Node, eh, s, w, astiencoder.EventTypeToNodeEventName)
+
+	// Create pkt dispatcher
+	w.d = newPktDispatcher(w, eh, w.p)
+
+	// Add stats
+	w.addStats()
+	return
+}
+
+func (w *SubtitleWriter) addStats() {
+	// Get stats
+	ss := w.c.Stats()
+	ss = append(ss, w.d.stats()...)
+	ss = append(ss, astikit.StatOptions{
+		Handler: w.statIncomingRate,
+		Metadata: &astikit.StatMetadata{
+			Description: "Number of cues coming in per second",
+			Label:       "Incoming rate",
+			Name:        StatNameIncomingRate,
+			Unit:        "cps",
+		},
+	})
+
+	// Add stats
+	w.BaseNode.AddStats(ss...)
+}
+
+// SubtitleCodecIDForFormat returns the subtitle codec id suited for the output format
+func SubtitleCodecIDForFormat(ctxFormat *avformat.Context) avcodec.CodecId {
+	switch outputFormatName(ctxFormat) {
+	case "ipod", "mov", "mp4":
+		return avcodec.CodecId(avcodec.AV_CODEC_ID_MOV_TEXT)
+	default:
+		return avcodec.CodecId(avcodec.AV_CODEC_ID_WEBVTT)
+	}
+}
+
+// AddStream adds a subtitle stream to the output format
+// It must be called before the header is written
+func (w *SubtitleWriter) AddStream(ctxFormat *avformat.Context) (o *avformat.Stream, err error) {
+	// Get codec id
+	if w.codecID == 0 {
+		w.codecID = SubtitleCodecIDForFormat(ctxFormat)
+	}
+
+	// Add stream
+	o = AddStream(ctxFormat)
+
+	// Set codec parameters
+	setCodecParametersCodec(o.CodecParameters(), avutil.AVMEDIA_TYPE_SUBTITLE, w.codecID)
+
+	// Set extradata
+	if w.codecID == avcodec.CodecId(avcodec.AV_CODEC_ID_MOV_TEXT) {
+		if err = setCodecParametersExtradata(o.CodecParameters(), movTextSampleEntry); err != nil {
+			return
+		}
+	}
+
+	// Set time base
+	o.SetTimeBase(w.timeBase)
+	return
+}
+
+// Connect implements the PktHandlerConnector interface
+func (w *SubtitleWriter) Connect(h PktHandler) {
+	// Add handler
+	w.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(w, h)
+}
+
+// Disconnect implements the PktHandlerConnector interface
+func (w *SubtitleWriter) Disconnect(h PktHandler) {
+	// Delete handler
+	w.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(w, h)
+}
+
+// Drain implements the Drainer interface
+func (w *SubtitleWriter) Drain(ctx context.Context) error {
+	return drainChan(ctx, w.BaseNode, w.c)
+}
+
+// Start starts the subtitle writer
+func (w *SubtitleWriter) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
+	w.BaseNode.Start(ctx, t, func(t *astikit.Task) {
+		// Make sure to stop the chan properly
+		defer w.c.Stop()
+
+		// Start chan
+		w.c.Start(w.Context())
+	})
+}
+
+// TimeBase implements the Descriptor interface
+func (w *SubtitleWriter) TimeBase() avutil.Rational {
+	return w.timeBase
+}
+
+// WriteCue writes a cue. Cues must be written in start order
+func (w *SubtitleWriter) WriteCue(c SubtitleCue) {
+	// Increment incoming rate
+	w.statIncomingRate.Add(1)
+
+	// Add to chan
+	w.c.Add(func() {
+		// Handle pause
+		defer w.HandlePause()
+
+		// Check timing
+		if c.End < c.Start {
+			w.eh.Emit(astiencoder.EventError(w, fmt.Errorf("astilibav: cue end %s is before its start %s", c.End, c.Start)))
+			return
+		}
+
+		// Get pkt from pool
+		pkt := w.p.get()
+		defer w.p.put(pkt)
+
+		// Get payload
+		b := w.payload(c.Text)
+
+		// Alloc pkt
+		if ret := pkt.AvNewPacket(len(b)); ret < 0 {
+			emitAvError(w, w.eh, ret, "pkt.AvNewPacket failed")
+			return
+		}
+
+		// Copy payload
+		if len(b) > 0 {
+			copy(cBytes(unsafe.Pointer(pkt.Data()), len(b)), b)
+		}
+
+		// Set timing
+		pts := avutil.AvRescaleQ(int64(c.Start), nanosecondRational, w.timeBase)
+		pkt.SetPts(pts)
+		pkt.SetDts(pts)
+		pkt.SetDuration(avutil.AvRescaleQ(int64(c.End-c.Start), nanosecondRational, w.timeBase))
+		pkt.SetFlags(int64(pkt.Flags() | avcodec.AV_PKT_FLAG_KEY))
+
+		// Dispatch pkt
+		w.d.dispatch(pkt, w)
+	})
+}
+
+func (w *SubtitleWriter) payload(text string) []byte {
+	switch w.codecID {
+	case avcodec.CodecId(avcodec.AV_CODEC_ID_MOV_TEXT):
+		// tx3g samples are prefixed with the text length
+		b := make([]byte, 2+len(text))
+		binary.BigEndian.PutUint16(b, uint16(len(text)))
+		copy(b[2:], text)
+		return b
+	default:
+		return []byte(text)
+	}
+}
@@ -0,0 +1,204 @@
+package astilibav
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avcodec"
+)
+
+var countTap uint64
+
+// Tap represents an object capable of observing frames and packets without altering them.
+// Frames and packets are forwarded unchanged and synchronously to the connected handlers
+type Tap struct {
+	*astiencoder.BaseNode
+	eh                    *astiencoder.EventHandler
+	fd                    *frameDispatcher
+	frameFunc             func(v TapFrame)
+	pd                    *pktDispatcher
+	pktFunc               func(v TapPkt)
+	statIncomingFrameRate *astikit.CounterRateStat
+	statIncomingPktRate   *astikit.CounterRateStat
+}
+
+// TapOptions represents tap options
+type TapOptions struct {
+	// Called for each incoming frame
+	FrameFunc func(v TapFrame)
+	Node      astiencoder.NodeOptions
+	// Called for each incoming pkt
+	PktFunc func(v TapPkt)
+}
+
+// TapFrame represents a read-only view of a frame
+type TapFrame struct {
+	Descriptor Descriptor
+	Height     int
+	KeyFrame   bool
+	NbSamples  int
+	Node       astiencoder.Node
+	Pts        int64
+	Width      int
+}
+
+// TapPkt represents a read-only view of a pkt
+type TapPkt struct {
+	Descriptor  Descriptor
+	Dts         int64
+	Duration    int64
+	Flags       int
+	KeyFrame    bool
+	Node        astiencoder.Node
+	Pts         int64
+	Size        int
+	StreamIndex int
+}
+
+// NewTap creates a new tap
+func NewTap(o TapOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (t *Tap) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countTap, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("tap_%d", count), fmt.Sprintf("Tap #%d", count), "Taps", "tap")
+
+	// Create tap
+	t = &Tap{
+		eh:                    eh,
+		frameFunc:             o.FrameFunc,
+		pktFunc:               o.PktFunc,
+		statIncomingFrameRate: astikit.NewCounterRateStat(),
+		statIncomingPktRate:   astikit.NewCounterRateStat(),
+	}
+
+	// Create base node
+	t.BaseNode = astiencoder.NewBaseNode(o.Node, eh, s, t, astiencoder.EventTypeToNodeEventName)
+
+	// Create dispatchers
+	t.fd = newFrameDispatcher(t, eh, newFramePool(c))
+	t.pd = newPktDispatcher(t, eh, newPktPool(c))
+
+	// Add stats
+	t.addStats()
+	return
+}
+
+func (t *Tap) addStats() {
+	// Get stats
+	var ss []astikit.StatOptions
+	ss = append(ss,
+		astikit.StatOptions{
+			Handler: t.statIncomingFrameRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames coming in per second",
+				Label:       "Incoming frame rate",
+				Name:        StatNameIncomingFrameRate,
+				Unit:        "fps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: t.statIncomingPktRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of packets coming in per second",
+				Label:       "Incoming packet rate",
+				Name:        StatNameIncomingPktRate,
+				Unit:        "pps",
+			},
+		},
+	)
+
+	// Add stats
+	t.BaseNode.AddStats(ss...)
+}
+
+// Connect implements the FrameHandlerConnector interface
+func (t *Tap) Connect(h FrameHandler) {
+	// Add handler
+	t.fd.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(t, h)
+}
+
+// Disconnect implements the FrameHandlerConnector interface
+func (t *Tap) Disconnect(h FrameHandler) {
+	// Delete handler
+	t.fd.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(t, h)
+}
+
+// ConnectPkt connects the tap to a PktHandler
+func (t *Tap) ConnectPkt(h PktHandler) {
+	// Add handler
+	t.pd.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(t, h)
+}
+
+// DisconnectPkt disconnects the tap from a PktHandler
+func (t *Tap) DisconnectPkt(h PktHandler) {
+	// Delete handler
+	t.pd.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(t, h)
+}
+
+// Start starts the tap
+func (t *Tap) Start(ctx context.Context, tc astiencoder.CreateTaskFunc) {
+	t.BaseNode.Start(ctx, tc, func(tk *astikit.Task) {
+		// Wait for context to be done
+		<-t.Context().Done()
+	})
+}
+
+// HandleFrame implements the FrameHandler interface
+func (t *Tap) HandleFrame(p FrameHandlerPayload) {
+	// Increment incoming rate
+	t.statIncomingFrameRate.Add(1)
+
+	// Execute callback
+	if t.frameFunc != nil {
+		t.frameFunc(TapFrame{
+			Descriptor: p.Descriptor,
+			Height:     p.Frame.Height(),
+			KeyFrame:   frameKeyFrame(p.Frame),
+			NbSamples:  p.Frame.NbSamples(),
+			Node:       p.Node,
+			Pts:        p.Frame.Pts(),
+			Width:      p.Frame.Width(),
+		})
+	}
+
+	// Dispatch frame
+	t.fd.dispatch(p.Frame, p.Descriptor)
+}
+
+// HandlePkt implements the PktHandler interface
+func (t *Tap) HandlePkt(p PktHandlerPayload) {
+	// Increment incoming rate
+	t.statIncomingPktRate.Add(1)
+
+	// Execute callback
+	if t.pktFunc != nil {
+		t.pktFunc(TapPkt{
+			Descriptor:  p.Descriptor,
+			Dts:         p.Pkt.Dts(),
+			Duration:    p.Pkt.Duration(),
+			Flags:       p.Pkt.Flags(),
+			KeyFrame:    p.Pkt.Flags()&avcodec.AV_PKT_FLAG_KEY > 0,
+			Node:        p.Node,
+			Pts:         p.Pkt.Pts(),
+			Size:        p.Pkt.Size(),
+			StreamIndex: p.Pkt.StreamIndex(),
+		})
+	}
+
+	// Dispatch pkt
+	t.pd.dispatch(p.Pkt, p.Descriptor)
+}
@@ -0,0 +1,215 @@
+package astilibav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+)
+
+var countTeeMuxer uint64
+
+// TeeMuxer represents an object capable of writing the same pkts into several muxers. Pkts are rescaled
+// independently to the time base of each output stream
+type TeeMuxer struct {
+	*astiencoder.BaseNode
+	c                 *astikit.Chan
+	continueOnError   bool
+	eh                *astiencoder.EventHandler
+	os                []*teeMuxerOutput
+	p                 *pktPool
+	statIncomingRate  *astikit.CounterRateStat
+	statProcessedRate *astikit.CounterRateStat
+}
+
+type teeMuxerOutput struct {
+	failed uint32
+	h      *MuxerPktHandler
+}
+
+// TeeMuxerOptions represents tee muxer options
+type TeeMuxerOptions struct {
+	// If true, an output whose muxer emits an error stops receiving pkts while the other outputs keep running.
+	// Otherwise, the tee muxer stops as soon as any output emits an error
+	ContinueOnError bool
+	Node            astiencoder.NodeOptions
+	Outputs         []TeeMuxerOutputOptions
+}
+
+// TeeMuxerOutputOptions represents tee muxer output options
+type TeeMuxerOutputOptions struct {
+	Muxer *Muxer
+	// Options of the pkt handler created in Muxer. Stream is required
+	PktHandler MuxerPktHandlerOptions
+}
+
+// NewTeeMuxer creates a new tee muxer
+func NewTeeMuxer(o TeeMuxerOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (t *TeeMuxer, err error) {
+	// Check outputs
+	if len(o.Outputs) == 0 {
+		err = errors.New("astilibav: no outputs provided")
+		return
+	}
+
+	// Extend node metadata
+	count := atomic.AddUint64(&countTeeMuxer, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("tee_muxer_%d", count), fmt.Sprintf("Tee Muxer #%d", count), fmt.Sprintf("Muxes to %d outputs", len(o.Outputs)), "tee muxer")
+
+	// Create tee muxer
+	t = &TeeMuxer{
+		c:                 astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
+		continueOnError:   o.ContinueOnError,
+		eh:                eh,
+		p:                 newPktPool(c),
+		statIncomingRate:  astikit.NewCounterRateStat(),
+		statProcessedRate: astikit.NewCounterRateStat(),
+	}
+
+	// Create base node
+	t.BaseNode = astiencoder.NewBaseNode(o.Node, eh, s, t, astiencoder.EventTypeToNodeEventName)
+
+	// Add stats
+	t.addStats()
+
+	// Loop through outputs
+	for idx, oo := range o.Outputs {
+		// Create pkt handler
+		var h *MuxerPktHandler
+		if h, err = oo.Muxer.NewPktHandlerWithOptions(oo.PktHandler); err != nil {
+			err = fmt.Errorf("astilibav: creating pkt handler of output #%d failed: %w", idx, err)
+			return
+		}
+
+		// Create output
+		to := &teeMuxerOutput{h: h}
+		t.os = append(t.os, to)
+
+		// Handle errors
+		// Callbacks are deleted once the tee muxer is closed since muxers may outlive it
+		for _, target := range []interface{}{h, oo.Muxer} {
+			del := eh.AddDeletable(target, astiencoder.EventNameError, func(e astiencoder.Event) bool {
+				t.handleOutputError(to, e)
+				return false
+			})
+			c.Add(func() error {
+				del()
+				return nil
+			})
+		}
+
+		// Connect nodes
+		astiencoder.ConnectNodes(t, h)
+	}
+	return
+}
+
+func (t *TeeMuxer) addStats() {
+	// Get stats
+	ss := t.c.Stats()
+	ss = append(ss,
+		astikit.StatOptions{
+			Handler: t.statIncomingRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of packets coming in per second",
+				Label:       "Incoming rate",
+				Name:        StatNameIncomingRate,
+				Unit:        "pps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: t.statProcessedRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of packets processed per second",
+				Label:       "Processed rate",
+				Name:        StatNameProcessedRate,
+				Unit:        "pps",
+			},
+		},
+	)
+
+	// Add stats
+	t.BaseNode.AddStats(ss...)
+}
+
+func (t *TeeMuxer) handleOutputError(o *teeMuxerOutput, e astiencoder.Event) {
+	// Output has already failed
+	if !atomic.CompareAndSwapUint32(&o.failed, 0, 1) {
+		return
+	}
+
+	// Other outputs keep running
+	if t.continueOnError {
+		return
+	}
+
+	// Get error
+	err, ok := e.Payload.(error)
+	if !ok {
+		err = fmt.Errorf("%v", e.Payload)
+	}
+
+	// Stop
+	t.eh.Emit(astiencoder.EventError(t, fmt.Errorf("astilibav: output %s failed, stopping tee muxer: %w", o.h.Metadata().Name, err)))
+	t.Stop()
+}
+
+// Drain implements the Drainer interface
+func (t *TeeMuxer) Drain(ctx context.Context) error {
+	return drainChan(ctx, t.BaseNode, t.c)
+}
+
+// Start starts the tee muxer
+func (t *TeeMuxer) Start(ctx context.Context, tc astiencoder.CreateTaskFunc) {
+	t.BaseNode.Start(ctx, tc, func(tk *astikit.Task) {
+		// Make sure to stop the chan properly
+		defer t.c.Stop()
+
+		// Start chan
+		t.c.Start(t.Context())
+	})
+}
+
+// HandlePkt implements the PktHandler interface
+func (t *TeeMuxer) HandlePkt(p PktHandlerPayload) {
+	// Increment incoming rate
+	t.statIncomingRate.Add(1)
+
+	// Copy pkt
+	pkt := t.p.get()
+	if ret := pkt.AvPacketRef(p.Pkt); ret < 0 {
+		t.p.put(pkt)
+		emitAvError(t, t.eh, ret, "AvPacketRef failed")
+		return
+	}
+
+	// Add to chan
+	t.c.Add(func() {
+		// Handle pause
+		defer t.HandlePause()
+
+		// Make sure to close pkt
+		defer t.p.put(pkt)
+
+		// Increment processed rate
+		t.statProcessedRate.Add(1)
+
+		// Loop through outputs
+		for _, o := range t.os {
+			// Output has failed
+			if atomic.LoadUint32(&o.failed) > 0 {
+				continue
+			}
+
+			// Handle pkt
+			// Each pkt handler copies the pkt and rescales its timestamps to its own stream time base
+			o.h.HandlePkt(PktHandlerPayload{
+				Descriptor: p.Descriptor,
+				Node:       t,
+				Pkt:        pkt,
+			})
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package astilibav
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avcodec"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeeMuxerOutputError(t *testing.T) {
+	// Create temp dir
+	dir, err := ioutil.TempDir("", "astilibav-tee-muxer-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Create event handler
+	eh := astiencoder.NewEventHandler()
+
+	// Create muxers
+	// Muxers are closed after the tee muxer
+	c := astikit.NewCloser()
+	defer c.Close()
+	var ms []*Muxer
+	var oos []TeeMuxerOutputOptions
+	for i := 0; i < 2; i++ {
+		m, err := NewMuxer(MuxerOptions{
+			FormatName: "nut",
+			URL:        filepath.Join(dir, fmt.Sprintf("output-%d.nut", i)),
+		}, eh, c, nil)
+		require.NoError(t, err)
+		e, err := NewEncoder(EncoderOptions{Ctx: Context{
+			CodecID:      avcodec.AV_CODEC_ID_MPEG4,
+			CodecType:    avutil.AVMEDIA_TYPE_VIDEO,
+			FrameRate:    avutil.NewRational(25, 1),
+			GlobalHeader: m.GlobalHeader(),
+			Height:       16,
+			PixelFormat:  avutil.AV_PIX_FMT_YUV420P,
+			TimeBase:     avutil.NewRational(1, 25),
+			Width:        16,
+		}}, eh, c, nil)
+		require.NoError(t, err)
+		s, err := e.AddStream(m.CtxFormat())
+		require.NoError(t, err)
+		ms = append(ms, m)
+		oos = append(oos, TeeMuxerOutputOptions{
+			Muxer:      m,
+			PktHandler: MuxerPktHandlerOptions{Stream: s},
+		})
+	}
+
+	// Create tee muxer
+	tc := astikit.NewCloser()
+	defer tc.Close()
+	tm, err := NewTeeMuxer(TeeMuxerOptions{Outputs: oos}, eh, tc, nil)
+	require.NoError(t, err)
+	var errs []error
+	eh.Add(tm, astiencoder.EventNameError, func(e astiencoder.Event) bool {
+		errs = append(errs, e.Payload.(error))
+		return false
+	})
+
+	// Output error stops the tee muxer
+	err1 := errors.New("test")
+	eh.Emit(astiencoder.EventError(ms[0], err1))
+	require.Len(t, errs, 1)
+	assert.True(t, errors.Is(errs[0], err1))
+	assert.Contains(t, errs[0].Error(), ms[0].Metadata().Name)
+
+	// Errors of outputs are not handled anymore once the tee muxer is closed
+	require.NoError(t, tc.Close())
+	eh.Emit(astiencoder.EventError(ms[1], errors.New("test")))
+	assert.Len(t, errs, 1)
+}
@@ -0,0 +1,264 @@
+package astilibav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avutil"
+)
+
+var countTimestampSmoother uint64
+
+// TimestampSmoother represents an object capable of smoothing jittery frame timestamps with a phase-locked loop:
+// output timestamps follow the expected cadence while drift is slowly corrected
+type TimestampSmoother struct {
+	*astiencoder.BaseNode
+	c                 *astikit.Chan
+	d                 *frameDispatcher
+	eh                *astiencoder.EventHandler
+	outputCtx         Context
+	p                 *framePool
+	pll               *ptsPLL
+	statCorrection    *astikit.CounterAvgStat
+	statIncomingRate  *astikit.CounterRateStat
+	statProcessedRate *astikit.CounterRateStat
+}
+
+// TimestampSmootherOptions represents timestamp smoother options
+type TimestampSmootherOptions struct {
+	// Gain applied to the phase error. Default is 0.1
+	Alpha float64
+	// Gain applied to the frequency error. Default is Alpha²/4
+	Beta float64
+	// Expected duration between frames in frame time base. Default is computed from the input ctx
+	FrameDuration int64
+	// Context of the frames coming in
+	InputCtx Context
+	Node     astiencoder.NodeOptions
+	// Phase error above which the loop is reset to the incoming timestamp. Default is 10 frame durations
+	ResetThreshold int64
+}
+
+// NewTimestampSmoother creates a new timestamp smoother
+func NewTimestampSmoother(o TimestampSmootherOptions, eh *astiencoder.EventHandler, c *astikit.Closer, s *astiencoder.Stater) (t *TimestampSmoother, err error) {
+	// Extend node metadata
+	count := atomic.AddUint64(&countTimestampSmoother, uint64(1))
+	o.Node.Metadata = o.Node.Metadata.Extend(fmt.Sprintf("timestamp_smoother_%d", count), fmt.Sprintf("Timestamp Smoother #%d", count), "Smoothes timestamps", "timestamp smoother")
+
+	// Create timestamp smoother
+	t = &TimestampSmoother{
+		c:                 astikit.NewChan(astikit.ChanOptions{ProcessAll: true}),
+		eh:                eh,
+		outputCtx:         o.InputCtx,
+		p:                 newFramePool(c),
+		statCorrection:    astikit.NewCounterAvgStat(),
+		statIncomingRate:  astikit.NewCounterRateStat(),
+		statProcessedRate: astikit.NewCounterRateStat(),
+	}
+
+	// Create base node
+	t.BaseNode = astiencoder.NewBaseNode(o.Node, eh, s, t, astiencoder.EventTypeToNodeEventName)
+
+	// Create frame dispatcher
+	t.d = newFrameDispatcher(t, eh, t.p)
+
+	// Add stats
+	t.addStats()
+
+	// Get frame duration
+	if o.FrameDuration <= 0 {
+		if o.InputCtx.FrameRate.Num() <= 0 || o.InputCtx.TimeBase.Num() <= 0 {
+			err = errors.New("astilibav: frame duration can't be computed from input ctx")
+			return
+		}
+		o.FrameDuration = avutil.AvRescaleQ(1, avutil.NewRational(o.InputCtx.FrameRate.Den(), o.InputCtx.FrameRate.Num()), o.InputCtx.TimeBase)
+	}
+
+	// Create pll
+	t.pll = newPTSPLL(o.FrameDuration, o.Alpha, o.Beta, o.ResetThreshold)
+	return
+}
+
+func (t *TimestampSmoother) addStats() {
+	// Get stats
+	ss := t.c.Stats()
+	ss = append(ss, t.d.stats()...)
+	ss = append(ss,
+		astikit.StatOptions{
+			Handler: t.statCorrection,
+			Metadata: &astikit.StatMetadata{
+				Description: "Average difference between output and input timestamps",
+				Label:       "Correction",
+				Name:        StatNameCorrection,
+				Unit:        "ms",
+			},
+		},
+		astikit.StatOptions{
+			Handler: t.statIncomingRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames coming in per second",
+				Label:       "Incoming rate",
+				Name:        StatNameIncomingRate,
+				Unit:        "fps",
+			},
+		},
+		astikit.StatOptions{
+			Handler: t.statProcessedRate,
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of frames processed per second",
+				Label:       "Processed rate",
+				Name:        StatNameProcessedRate,
+				Unit:        "fps",
+			},
+		},
+	)
+
+	// Add stats
+	t.BaseNode.AddStats(ss...)
+}
+
+// OutputCtx returns the output ctx
+func (t *TimestampSmoother) OutputCtx() Context {
+	return t.outputCtx
+}
+
+// Connect implements the FrameHandlerConnector interface
+func (t *TimestampSmoother) Connect(h FrameHandler) {
+	// Add handler
+	t.d.addHandler(h)
+
+	// Connect nodes
+	astiencoder.ConnectNodes(t, h)
+}
+
+// Disconnect implements the FrameHandlerConnector interface
+func (t *TimestampSmoother) Disconnect(h FrameHandler) {
+	// Delete handler
+	t.d.delHandler(h)
+
+	// Disconnect nodes
+	astiencoder.DisconnectNodes(t, h)
+}
+
+// Drain implements the Drainer interface
+func (t *TimestampSmoother) Drain(ctx context.Context) error {
+	return drainChan(ctx, t.BaseNode, t.c)
+}
+
+// Start starts the timestamp smoother
+func (t *TimestampSmoother) Start(ctx context.Context, tc astiencoder.CreateTaskFunc) {
+	t.BaseNode.Start(ctx, tc, func(tk *astikit.Task) {
+		// Make sure to stop the chan properly
+		defer t.c.Stop()
+
+		// Start chan
+		t.c.Start(t.Context())
+	})
+}
+
+// HandleFrame implements the FrameHandler interface
+func (t *TimestampSmoother) HandleFrame(p FrameHandlerPayload) {
+	// Increment incoming rate
+	t.statIncomingRate.Add(1)
+
+	// Copy frame
+	f := t.p.get()
+	if ret := avutil.AvFrameRef(f, p.Frame); ret < 0 {
+		t.p.put(f)
+		emitAvError(t, t.eh, ret, "avutil.AvFrameRef failed")
+		return
+	}
+
+	// Add to chan
+	t.c.Add(func() {
+		// Handle pause
+		defer t.HandlePause()
+
+		// Make sure to close frame
+		defer t.p.put(f)
+
+		// Increment processed rate
+		t.statProcessedRate.Add(1)
+
+		// Frames without pts are passed through and don't update the loop
+		if f.Pts() == avutil.AV_NOPTS_VALUE {
+			t.d.dispatch(f, p.Descriptor)
+			return
+		}
+
+		// Smooth pts
+		pts := t.pll.next(f.Pts())
+
+		// Update correction stat
+		t.statCorrection.Add(float64(pts-f.Pts()) * p.Descriptor.TimeBase().ToDouble() * 1000)
+
+		// Restamp
+		f.SetPts(pts)
+
+		// Dispatch frame
+		t.d.dispatch(f, p.Descriptor)
+	})
+}
+
+// ptsPLL is a second order phase-locked loop tracking the phase and the period of incoming timestamps
+type ptsPLL struct {
+	alpha          float64
+	beta           float64
+	lastOutput     *int64
+	period         float64
+	phase          float64
+	resetThreshold float64
+}
+
+func newPTSPLL(frameDuration int64, alpha, beta float64, resetThreshold int64) *ptsPLL {
+	if alpha <= 0 {
+		alpha = 0.1
+	}
+	if beta <= 0 {
+		beta = alpha * alpha / 4
+	}
+	if resetThreshold <= 0 {
+		resetThreshold = 10 * frameDuration
+	}
+	return &ptsPLL{
+		alpha:          alpha,
+		beta:           beta,
+		period:         float64(frameDuration),
+		resetThreshold: float64(resetThreshold),
+	}
+}
+
+func (p *ptsPLL) next(input int64) (output int64) {
+	// Update phase
+	if p.lastOutput == nil {
+		p.phase = float64(input)
+	} else {
+		// Predict
+		p.phase += p.period
+
+		// Compute phase error
+		e := float64(input) - p.phase
+
+		// Phase error is too big, there's probably a discontinuity
+		if math.Abs(e) > p.resetThreshold {
+			p.phase = float64(input)
+		} else {
+			// Correct phase and period
+			p.phase += p.alpha * e
+			p.period += p.beta * e
+		}
+	}
+
+	// Make sure output is strictly increasing
+	output = int64(math.Round(p.phase))
+	if p.lastOutput != nil && output <= *p.lastOutput {
+		output = *p.lastOutput + 1
+	}
+	p.lastOutput = astikit.Int64Ptr(output)
+	return
+}
@@ -0,0 +1,76 @@
+package astilibav
+
+import (
+	"context"
+	"testing"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/goav/avutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPTSPLL(t *testing.T) {
+	// Jitter is smoothed
+	p := newPTSPLL(10, 0, 0, 0)
+	var os []int64
+	for _, i := range []int64{0, 14, 18, 33, 39, 52, 58, 71} {
+		os = append(os, p.next(i))
+	}
+	assert.Equal(t, []int64{0, 10, 20, 30, 40, 50, 60, 70}, os)
+
+	// Discontinuities reset the loop
+	assert.Equal(t, int64(1000), p.next(1000))
+	assert.Equal(t, int64(1010), p.next(1011))
+}
+
+func TestTimestampSmoother(t *testing.T) {
+	// Create
+	c := astikit.NewCloser()
+	defer c.Close()
+	eh := astiencoder.NewEventHandler()
+	s, err := NewTimestampSmoother(TimestampSmootherOptions{FrameDuration: 10}, eh, c, nil)
+	require.NoError(t, err)
+
+	// Connect handler
+	p := newFramePool(c)
+	p.setBufferCtx(Context{
+		CodecType:   avutil.AVMEDIA_TYPE_VIDEO,
+		Height:      2,
+		PixelFormat: avutil.AV_PIX_FMT_YUV420P,
+		Width:       2,
+	})
+	h := newTestFrameHandler("test", eh, p)
+	var ptss []int64
+	h.fn = func(p FrameHandlerPayload) { ptss = append(ptss, p.Frame.Pts()) }
+	s.Connect(h)
+
+	// Start
+	w := astikit.NewWorker(astikit.WorkerOptions{})
+	defer w.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx, w.NewTask)
+
+	// Handle frames
+	for _, pts := range []int64{
+		0,
+		14,
+		// Frame without pts is passed through
+		avutil.AV_NOPTS_VALUE,
+		18,
+		33,
+	} {
+		f, err := p.getWithBuffer()
+		require.NoError(t, err)
+		f.SetPts(pts)
+		s.HandleFrame(FrameHandlerPayload{
+			Descriptor: testDescriptor{timeBase: avutil.NewRational(1, 1000)},
+			Frame:      f,
+		})
+		p.put(f)
+	}
+	require.NoError(t, s.Drain(context.Background()))
+	assert.Equal(t, []int64{0, 10, avutil.AV_NOPTS_VALUE, 20, 30}, ptss)
+}
@@ -115,6 +115,10 @@ func DisconnectNodes(parent, child Node) {
 type NodeOptions struct {
 	Metadata       NodeMetadata
 	NoIndirectStop bool
+	// Quota shared with other nodes of the workflow that nodes consult before processing an item
+	Quota *Quota
+	// Number of quota units needed to process an item. Default is 1
+	QuotaWeight int64
 }
 
 // BaseNode represents a base node
@@ -278,6 +282,12 @@ func (n *BaseNode) Start(ctx context.Context, tc CreateTaskFunc, execFunc BaseNo
 					s.Handler.Start()
 				}
 				n.m.Unlock()
+
+				// Handle quota stats
+				if n.o.Quota != nil {
+					n.o.Quota.addStats(n.s)
+					defer n.o.Quota.delStats(n.s)
+				}
 			}
 
 			// Exec func
@@ -301,6 +311,7 @@ func (n *BaseNode) Stop() {
 }
 
 // Pause implements the Starter interface
+// It can be used to pause a single node while the rest of the workflow keeps running
 func (n *BaseNode) Pause() {
 	n.pauseFunc(func() {
 		n.ctxPause, n.cancelPause = context.WithCancel(n.ctx)
@@ -485,6 +496,33 @@ func (n *BaseNode) Metadata() NodeMetadata {
 	return n.o.Metadata
 }
 
+// AcquireQuota blocks until the node quota, if any, has room for the node weight or the node context is done.
+// The returned func releases it
+func (n *BaseNode) AcquireQuota() (release func(), err error) {
+	// No quota
+	if n.o.Quota == nil {
+		return func() {}, nil
+	}
+
+	// Get weight
+	w := n.o.QuotaWeight
+	if w <= 0 {
+		w = 1
+	}
+
+	// Get context
+	ctx := n.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Acquire
+	if err = n.o.Quota.Acquire(ctx, w); err != nil {
+		return
+	}
+	return func() { n.o.Quota.Release(w) }, nil
+}
+
 // AddStats adds stats
 func (n *BaseNode) AddStats(ss ...astikit.StatOptions) {
 	n.m.Lock()
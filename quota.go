@@ -0,0 +1,203 @@
+package astiencoder
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/asticode/go-astikit"
+)
+
+// Stat names
+const (
+	StatNameQuotaUsage   = "astiencoder.quota.usage"
+	StatNameQuotaWaiters = "astiencoder.quota.waiters"
+)
+
+// Quota represents a weighted semaphore shared by the nodes of a workflow so that a heavy workflow can't
+// starve others. Nodes whose options contain a quota acquire their weight before processing an item
+type Quota struct {
+	inUse int64
+	m     *sync.Mutex // Locks inUse, sts and ws
+	size  int64
+	ss    []astikit.StatOptions
+	sts   map[*Stater]int // Number of started nodes indexed by stater
+	ws    []*quotaWaiter
+}
+
+type quotaWaiter struct {
+	c chan struct{}
+	n int64
+}
+
+// NewQuota creates a new quota
+func NewQuota(size int64) (q *Quota) {
+	q = &Quota{
+		m:    &sync.Mutex{},
+		size: size,
+		sts:  make(map[*Stater]int),
+	}
+	q.ss = q.newStats()
+	return
+}
+
+// Acquire blocks until n units are available or the context is done.
+// Waiters are served in order so that heavy ones are not starved
+func (q *Quota) Acquire(ctx context.Context, n int64) error {
+	// Check weight
+	if n > q.size {
+		return fmt.Errorf("astiencoder: weight %d is bigger than quota size %d", n, q.size)
+	}
+
+	// Lock
+	q.m.Lock()
+
+	// Units are available and nobody is waiting
+	if q.size-q.inUse >= n && len(q.ws) == 0 {
+		q.inUse += n
+		q.m.Unlock()
+		return nil
+	}
+
+	// Add waiter
+	w := &quotaWaiter{
+		c: make(chan struct{}),
+		n: n,
+	}
+	q.ws = append(q.ws, w)
+	q.m.Unlock()
+
+	// Wait
+	select {
+	case <-w.c:
+		return nil
+	case <-ctx.Done():
+		q.m.Lock()
+		defer q.m.Unlock()
+		select {
+		case <-w.c:
+			// Units have been acquired in the meantime
+			q.inUse -= n
+			q.notify()
+		default:
+			// Delete waiter
+			for idx, v := range q.ws {
+				if v == w {
+					q.ws = append(q.ws[:idx], q.ws[idx+1:]...)
+					break
+				}
+			}
+			q.notify()
+		}
+		return ctx.Err()
+	}
+}
+
+// Release releases n units
+func (q *Quota) Release(n int64) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.inUse -= n
+	q.notify()
+}
+
+// notify must be called while holding the lock
+func (q *Quota) notify() {
+	for len(q.ws) > 0 {
+		w := q.ws[0]
+		if q.size-q.inUse < w.n {
+			return
+		}
+		q.inUse += w.n
+		q.ws = q.ws[1:]
+		close(w.c)
+	}
+}
+
+// InUse returns the number of units in use
+func (q *Quota) InUse() int64 {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.inUse
+}
+
+// Stats returns the quota stats. They're added to the stater of the nodes using the quota, with the quota as
+// target, as long as one of them is started
+func (q *Quota) Stats() []astikit.StatOptions {
+	return q.ss
+}
+
+// addStats adds the stats to the stater when the first node using it starts
+func (q *Quota) addStats(s *Stater) {
+	q.m.Lock()
+	q.sts[s]++
+	first := q.sts[s] == 1
+	q.m.Unlock()
+	if first {
+		s.AddStats(q, q.ss...)
+	}
+}
+
+// delStats deletes the stats from the stater when the last node using it stops
+func (q *Quota) delStats(s *Stater) {
+	q.m.Lock()
+	q.sts[s]--
+	last := q.sts[s] == 0
+	if last {
+		delete(q.sts, s)
+	}
+	q.m.Unlock()
+	if last {
+		s.DelStats(q, q.ss...)
+	}
+}
+
+func (q *Quota) newStats() []astikit.StatOptions {
+	return []astikit.StatOptions{
+		{
+			Handler: newQuotaStat(func() interface{} {
+				q.m.Lock()
+				defer q.m.Unlock()
+				return float64(q.inUse) / float64(q.size) * 100
+			}),
+			Metadata: &astikit.StatMetadata{
+				Description: "Percentage of the quota in use",
+				Label:       "Quota usage",
+				Name:        StatNameQuotaUsage,
+				Unit:        "%",
+			},
+		},
+		{
+			Handler: newQuotaStat(func() interface{} {
+				q.m.Lock()
+				defer q.m.Unlock()
+				return len(q.ws)
+			}),
+			Metadata: &astikit.StatMetadata{
+				Description: "Number of nodes waiting for the quota",
+				Label:       "Quota waiters",
+				Name:        StatNameQuotaWaiters,
+			},
+		},
+	}
+}
+
+type quotaStat struct {
+	fn func() interface{}
+}
+
+func newQuotaStat(fn func() interface{}) *quotaStat {
+	return &quotaStat{fn: fn}
+}
+
+// Start implements the astikit.StatHandler interface
+func (s *quotaStat) Start() {}
+
+// Stop implements the astikit.StatHandler interface
+func (s *quotaStat) Stop() {}
+
+// Value implements the astikit.StatHandler interface
+func (s *quotaStat) Value(_ time.Duration) interface{} {
+	return s.fn()
+}
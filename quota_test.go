@@ -0,0 +1,111 @@
+package astiencoder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astikit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuota(t *testing.T) {
+	q := NewQuota(3)
+
+	// Weight is too big
+	assert.Error(t, q.Acquire(context.Background(), 4))
+
+	// Units are available
+	require.NoError(t, q.Acquire(context.Background(), 2))
+	assert.Equal(t, int64(2), q.InUse())
+
+	// Context is done while waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, q.Acquire(ctx, 2))
+	assert.Equal(t, int64(2), q.InUse())
+
+	// Waiters are served in order
+	var order []int64
+	errs := make(chan error, 1)
+	go func() {
+		err := q.Acquire(context.Background(), 3)
+		if err == nil {
+			order = append(order, 3)
+			q.Release(3)
+		}
+		errs <- err
+	}()
+	for {
+		q.m.Lock()
+		l := len(q.ws)
+		q.m.Unlock()
+		if l > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go q.Release(2)
+	require.NoError(t, q.Acquire(ctx, 1))
+	require.NoError(t, <-errs)
+	order = append(order, 1)
+	assert.Equal(t, []int64{3, 1}, order)
+	assert.Equal(t, int64(1), q.InUse())
+}
+
+func TestBaseNodeQuota(t *testing.T) {
+	// Create
+	q := NewQuota(3)
+	eh := NewEventHandler()
+	s := NewStater(time.Hour, eh)
+	n := NewBaseNode(NodeOptions{Quota: q, QuotaWeight: 2}, eh, s, nil, EventTypeToNodeEventName)
+	hasStats := func() bool {
+		s.m.Lock()
+		defer s.m.Unlock()
+		_, ok := s.ts[q.Stats()[0].Metadata]
+		return ok
+	}
+
+	// Start
+	w := astikit.NewWorker(astikit.WorkerOptions{})
+	defer w.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{})
+	n.Start(ctx, w.NewTask, func(t *astikit.Task) {
+		close(started)
+		<-n.Context().Done()
+	})
+	<-started
+
+	// Stats are added while the node is started
+	assert.True(t, hasStats())
+
+	// Units are available
+	release, err := n.AcquireQuota()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), q.InUse())
+	release()
+	assert.Equal(t, int64(0), q.InUse())
+
+	// Node context is taken into account while waiting
+	require.NoError(t, q.Acquire(context.Background(), 2))
+	errs := make(chan error, 1)
+	go func() {
+		_, err := n.AcquireQuota()
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	n.Stop()
+	assert.Equal(t, context.Canceled, <-errs)
+	assert.Equal(t, int64(2), q.InUse())
+
+	// Stats are deleted once the node is stopped
+	for n.Status() != StatusStopped {
+		time.Sleep(time.Millisecond)
+	}
+	assert.False(t, hasStats())
+}
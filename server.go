@@ -1,11 +1,19 @@
 package astiencoder
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/asticode/go-astikit"
 	"github.com/asticode/go-astiws"
@@ -14,28 +22,83 @@ import (
 )
 
 type Server struct {
-	cs map[*astiws.Client]bool
-	l  astikit.SeverityLogger
-	m  *sync.Mutex // Locks cs
-	w  *Workflow
-	ws *astiws.Manager
+	cs  map[*astiws.Client]bool
+	eb  int
+	es  map[string][]ServerEvent // Last events indexed by workflow name
+	l   astikit.SeverityLogger
+	lc  ServerLogController
+	m   *sync.Mutex            // Locks cs
+	ms  *sync.Mutex            // Locks es, ss, wfs and wns
+	ss  map[string][]EventStat // Last stats indexed by workflow name
+	w   *Workflow
+	wfs map[string]*Workflow
+	wns map[Node]string // Workflow names indexed by node
+	ws  *astiws.Manager
 }
 
 type ServerOptions struct {
-	Logger astikit.StdLogger
+	// Number of events kept per workflow. Default is 100
+	EventsBufferSize int
+	// If set, log levels can be updated at runtime through /logs/levels
+	LogController ServerLogController
+	Logger        astikit.StdLogger
+}
+
+// ServerLogController represents an object capable of exposing and updating log levels at runtime
+// Levels are indexed by logger name
+type ServerLogController interface {
+	LogLevels() map[string]string
+	RecentLogs() []string
+	SetLogLevels(ls map[string]string) error
 }
 
 func NewServer(o ServerOptions) *Server {
+	if o.EventsBufferSize <= 0 {
+		o.EventsBufferSize = 100
+	}
 	return &Server{
-		cs: make(map[*astiws.Client]bool),
-		l:  astikit.AdaptStdLogger(o.Logger),
-		m:  &sync.Mutex{},
-		ws: astiws.NewManager(astiws.ManagerConfiguration{MaxMessageSize: 1e6}, o.Logger),
+		cs:  make(map[*astiws.Client]bool),
+		eb:  o.EventsBufferSize,
+		es:  make(map[string][]ServerEvent),
+		l:   astikit.AdaptStdLogger(o.Logger),
+		lc:  o.LogController,
+		m:   &sync.Mutex{},
+		ms:  &sync.Mutex{},
+		ss:  make(map[string][]EventStat),
+		wfs: make(map[string]*Workflow),
+		wns: make(map[Node]string),
+		ws:  astiws.NewManager(astiws.ManagerConfiguration{MaxMessageSize: 1e6}, o.Logger),
 	}
 }
 
+// SetWorkflow sets the workflow displayed by the web UI and adds it to the server workflows
 func (s *Server) SetWorkflow(w *Workflow) {
 	s.w = w
+	s.AddWorkflow(w)
+}
+
+// AddWorkflow adds a workflow whose events and stats are scoped under /workflows/:name
+func (s *Server) AddWorkflow(w *Workflow) {
+	s.ms.Lock()
+	defer s.ms.Unlock()
+	s.wfs[w.Name()] = w
+	for _, n := range w.indexedNodes() {
+		s.wns[n] = w.Name()
+	}
+}
+
+// DelWorkflow deletes a workflow as well as its events and stats
+func (s *Server) DelWorkflow(w *Workflow) {
+	s.ms.Lock()
+	defer s.ms.Unlock()
+	delete(s.es, w.Name())
+	delete(s.ss, w.Name())
+	delete(s.wfs, w.Name())
+	for n, wn := range s.wns {
+		if wn == w.Name() {
+			delete(s.wns, n)
+		}
+	}
 }
 
 func (s *Server) Handler() http.Handler {
@@ -44,9 +107,18 @@ func (s *Server) Handler() http.Handler {
 
 	// Add routes
 	r.Handler(http.MethodGet, "/", s.serveHomepage())
+	r.Handler(http.MethodGet, "/logs", s.serveRecentLogs())
+	r.Handler(http.MethodGet, "/logs/levels", s.serveLogLevels())
+	r.Handler(http.MethodPut, "/logs/levels", s.serveSetLogLevels())
+	r.Handler(http.MethodGet, "/metrics", s.serveMetrics())
 	r.Handler(http.MethodGet, "/ok", s.serveOK())
+	r.Handler(http.MethodPost, "/nodes/:name/continue", s.serveNodeAction(func(n Node) { n.Continue() }))
+	r.Handler(http.MethodPost, "/nodes/:name/pause", s.serveNodeAction(func(n Node) { n.Pause() }))
 	r.Handler(http.MethodGet, "/websocket", s.serveWebSocket())
 	r.Handler(http.MethodGet, "/welcome", s.serveWelcome())
+	r.Handler(http.MethodGet, "/workflows/:name/edges", s.serveWorkflowEdges())
+	r.Handler(http.MethodGet, "/workflows/:name/events", s.serveWorkflowEvents())
+	r.Handler(http.MethodGet, "/workflows/:name/stats", s.serveWorkflowStats())
 	return r
 }
 
@@ -54,6 +126,95 @@ func (s *Server) serveOK() http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
 }
 
+func (s *Server) serveRecentLogs() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// No log controller
+		if s.lc == nil {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Write
+		s.writeJSON(rw, s.lc.RecentLogs())
+	})
+}
+
+func (s *Server) serveLogLevels() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// No log controller
+		if s.lc == nil {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Write
+		s.writeJSON(rw, s.lc.LogLevels())
+	})
+}
+
+func (s *Server) serveSetLogLevels() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// No log controller
+		if s.lc == nil {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Unmarshal
+		var ls map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&ls); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Set log levels
+		if err := s.lc.SetLogLevels(ls); err != nil {
+			s.l.Error(fmt.Errorf("astiencoder: setting log levels failed: %w", err))
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Write
+		s.writeJSON(rw, s.lc.LogLevels())
+	})
+}
+
+func (s *Server) writeJSON(rw http.ResponseWriter, v interface{}) {
+	// Marshal first so that an error status can still be written
+	buf := &bytes.Buffer{}
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		s.l.Error(fmt.Errorf("astiencoder: marshaling failed: %w", err))
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Write
+	if _, err := buf.WriteTo(rw); err != nil {
+		s.l.Error(fmt.Errorf("astiencoder: writing failed: %w", err))
+		return
+	}
+}
+
+func (s *Server) serveNodeAction(fn func(n Node)) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// No workflow
+		if s.w == nil {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Get node
+		n, ok := s.w.Node(httprouter.ParamsFromContext(r.Context()).ByName("name"))
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Execute action
+		fn(n)
+	})
+}
+
 func (s *Server) serveWebSocket() http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		if err := s.ws.ServeHTTP(rw, r, s.adaptWebSocketClient); err != nil {
@@ -114,29 +275,368 @@ func (s *Server) sendWebSocket(eventName string, payload interface{}) {
 func serverEventHandlerAdapter(eh *EventHandler, fn func(name string, payload interface{})) {
 	// Register catch all handler
 	eh.AddForAll(func(e Event) bool {
-		// Get payload
-		var p interface{}
-		switch e.Name {
-		case EventNameError:
-			p = astikit.ErrorCause(e.Payload.(error))
-		case EventNameNodeContinued, EventNameNodePaused, EventNameNodeStopped:
-			p = e.Target.(Node).Metadata().Name
-		case EventNameNodeStarted:
-			p = newServerNode(e.Target.(Node))
-		case EventNameStats:
-			p = newServerStats(e)
-		}
-
-		// Custom
-		fn(e.Name, p)
+		fn(e.Name, newServerEventPayload(e))
 		return false
 	})
 }
 
+func newServerEventPayload(e Event) (p interface{}) {
+	switch e.Name {
+	case EventNameError:
+		p = astikit.ErrorCause(e.Payload.(error))
+	case EventNameNodeContinued, EventNameNodePaused, EventNameNodeStopped:
+		p = e.Target.(Node).Metadata().Name
+	case EventNameNodeStarted:
+		p = newServerNode(e.Target.(Node))
+	case EventNameStats:
+		p = newServerStats(e)
+	}
+	return
+}
+
 func (s *Server) EventHandlerAdapter(eh *EventHandler) {
+	// Scope events and stats by workflow
+	eh.AddForAll(func(e Event) bool {
+		s.handleWorkflowEvent(e)
+		return false
+	})
+
+	// Send events to websocket clients
 	serverEventHandlerAdapter(eh, s.sendWebSocket)
 }
 
+type ServerEvent struct {
+	Name    string      `json:"name"`
+	Payload interface{} `json:"payload,omitempty"`
+	Target  string      `json:"target,omitempty"`
+}
+
+func (s *Server) handleWorkflowEvent(e Event) {
+	// Lock
+	s.ms.Lock()
+	defer s.ms.Unlock()
+
+	// No workflows
+	if len(s.wfs) == 0 {
+		return
+	}
+
+	// Nodes may be added to the workflow after it has been added to the server, therefore they're indexed
+	// as well when the workflow starts them
+	if e.Name == EventNameWorkflowNodeStarting {
+		if w, ok := e.Target.(*Workflow); ok && s.wfs[w.Name()] == w {
+			if n, ok := e.Payload.(Node); ok {
+				s.indexWorkflowNode(w.Name(), n)
+			}
+		}
+	}
+
+	// Stats
+	if e.Name == EventNameStats {
+		ss := make(map[string][]EventStat)
+		for _, st := range e.Payload.([]EventStat) {
+			if wn, ok := serverWorkflowName(st.Target, s.wns); ok {
+				ss[wn] = append(ss[wn], st)
+			}
+		}
+		for wn, v := range ss {
+			s.ss[wn] = v
+		}
+		return
+	}
+
+	// Get workflow name
+	wn, ok := serverWorkflowName(e.Target, s.wns)
+	if !ok {
+		return
+	}
+
+	// Create event
+	se := ServerEvent{
+		Name:    e.Name,
+		Payload: newServerEventPayload(e),
+	}
+	if n, ok := e.Target.(Node); ok {
+		se.Target = n.Metadata().Name
+	}
+
+	// Append event
+	s.es[wn] = append(s.es[wn], se)
+	if len(s.es[wn]) > s.eb {
+		s.es[wn] = s.es[wn][len(s.es[wn])-s.eb:]
+	}
+}
+
+// indexWorkflowNode must be called with ms locked
+func (s *Server) indexWorkflowNode(wn string, n Node) {
+	s.wns[n] = wn
+	for _, c := range n.Children() {
+		s.indexWorkflowNode(wn, c)
+	}
+}
+
+func serverWorkflowName(target interface{}, wns map[Node]string) (string, bool) {
+	switch v := target.(type) {
+	case *Workflow:
+		return v.Name(), true
+	case Node:
+		wn, ok := wns[v]
+		return wn, ok
+	}
+	return "", false
+}
+
+func (s *Server) serveWorkflowEvents() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// Get events
+		name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+		s.ms.Lock()
+		_, ok := s.wfs[name]
+		es := append([]ServerEvent{}, s.es[name]...)
+		s.ms.Unlock()
+
+		// No workflow
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Write
+		s.writeJSON(rw, es)
+	})
+}
+
+func (s *Server) serveWorkflowStats() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// Get stats
+		name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+		s.ms.Lock()
+		_, ok := s.wfs[name]
+		ss := []ServerStat{}
+		for _, st := range s.ss[name] {
+			ss = append(ss, newServerStat(st))
+		}
+		s.ms.Unlock()
+
+		// No workflow
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Write
+		s.writeJSON(rw, ss)
+	})
+}
+
+func (s *Server) serveWorkflowEdges() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// Get workflow and stats
+		name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+		s.ms.Lock()
+		w, ok := s.wfs[name]
+		ss := append([]EventStat{}, s.ss[name]...)
+		s.ms.Unlock()
+
+		// No workflow
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Write
+		s.writeJSON(rw, newServerEdges(w.nodes(), ss))
+	})
+}
+
+// ServerEdge represents a parent -> child edge annotated with backpressure
+type ServerEdge struct {
+	// Normalized (between 0 and 1) backpressure applied to the parent through this edge. It is the highest work ratio
+	// among the child and its descendants, since a saturated consumer eventually slows down every node upstream
+	Backpressure float64 `json:"backpressure"`
+	From         string  `json:"from"`
+	// Name of the node the backpressure originates from
+	Origin string `json:"origin"`
+	To     string `json:"to"`
+	// Normalized (between 0 and 1) work ratio of the child
+	WorkRatio float64 `json:"work_ratio"`
+}
+
+// newServerEdges expects nodes to be sorted parents first
+func newServerEdges(ns []Node, ss []EventStat) (es []ServerEdge) {
+	// Index work ratios by node name
+	wrs := make(map[string]float64)
+	for _, st := range ss {
+		if st.Name != astikit.StatNameWorkRatio {
+			continue
+		}
+		n, ok := st.Target.(Node)
+		if !ok {
+			continue
+		}
+		v, ok := prometheusValue(st.Value)
+		if !ok {
+			continue
+		}
+		wrs[n.Metadata().Name] = math.Max(0, math.Min(1, v/100))
+	}
+
+	// Propagate backpressure upstream by looping through nodes children first
+	type pressure struct {
+		origin string
+		value  float64
+	}
+	ps := make(map[string]pressure)
+	for idx := len(ns) - 1; idx >= 0; idx-- {
+		n := ns[idx]
+		p := pressure{origin: n.Metadata().Name, value: wrs[n.Metadata().Name]}
+		for _, c := range n.Children() {
+			if cp, ok := ps[c.Metadata().Name]; ok && cp.value > p.value {
+				p = cp
+			}
+		}
+		ps[n.Metadata().Name] = p
+	}
+
+	// Create edges
+	es = []ServerEdge{}
+	for _, n := range ns {
+		// Get children
+		cs := n.Children()
+		sort.Slice(cs, func(i, j int) bool { return cs[i].Metadata().Name < cs[j].Metadata().Name })
+
+		// Loop through children
+		for _, c := range cs {
+			p := ps[c.Metadata().Name]
+			es = append(es, ServerEdge{
+				Backpressure: p.value,
+				From:         n.Metadata().Name,
+				Origin:       p.origin,
+				To:           c.Metadata().Name,
+				WorkRatio:    wrs[c.Metadata().Name],
+			})
+		}
+	}
+	return
+}
+
+func (s *Server) serveMetrics() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// Get stats
+		s.ms.Lock()
+		ss := make(map[string][]EventStat)
+		for wn, v := range s.ss {
+			ss[wn] = v
+		}
+		s.ms.Unlock()
+
+		// Write
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := writePrometheusStats(rw, ss); err != nil {
+			s.l.Error(fmt.Errorf("astiencoder: writing prometheus stats failed: %w", err))
+			return
+		}
+	})
+}
+
+var prometheusInvalidNameChars = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+type prometheusStat struct {
+	EventStat
+	workflow string
+}
+
+// writePrometheusStats writes stats indexed by workflow name in the Prometheus text exposition format.
+// Stats are grouped by name, only numeric values are written and, since Prometheus rejects duplicate series,
+// only the first stat is written for a given set of labels
+func writePrometheusStats(w io.Writer, ss map[string][]EventStat) (err error) {
+	// Sort workflow names
+	var wns []string
+	for wn := range ss {
+		wns = append(wns, wn)
+	}
+	sort.Strings(wns)
+
+	// Index stats by metric name
+	var names []string
+	ms := make(map[string][]prometheusStat)
+	for _, wn := range wns {
+		for _, st := range ss[wn] {
+			// Only numeric values are supported
+			if _, ok := prometheusValue(st.Value); !ok {
+				continue
+			}
+
+			// Index
+			n := "astiencoder_" + prometheusInvalidNameChars.ReplaceAllString(st.Name, "_")
+			if _, ok := ms[n]; !ok {
+				names = append(names, n)
+			}
+			ms[n] = append(ms[n], prometheusStat{EventStat: st, workflow: wn})
+		}
+	}
+	sort.Strings(names)
+
+	// Loop through metrics
+	for _, n := range names {
+		// Write HELP and TYPE
+		if _, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", n, prometheusEscaper.Replace(ms[n][0].Description), n); err != nil {
+			err = fmt.Errorf("astiencoder: writing failed: %w", err)
+			return
+		}
+
+		// Loop through stats
+		ls := make(map[string]bool)
+		for _, st := range ms[n] {
+			// Get node
+			var node string
+			if v, ok := st.Target.(Node); ok {
+				node = v.Metadata().Name
+			}
+
+			// Get labels
+			l := fmt.Sprintf("workflow=\"%s\",node=\"%s\",stat=\"%s\"", prometheusLabelEscaper.Replace(st.workflow), prometheusLabelEscaper.Replace(node), prometheusLabelEscaper.Replace(st.Label))
+
+			// Series has already been written
+			if ls[l] {
+				continue
+			}
+			ls[l] = true
+
+			// Write
+			v, _ := prometheusValue(st.Value)
+			if _, err = fmt.Fprintf(w, "%s{%s} %s\n", n, l, strconv.FormatFloat(v, 'g', -1, 64)); err != nil {
+				err = fmt.Errorf("astiencoder: writing failed: %w", err)
+				return
+			}
+		}
+	}
+	return
+}
+
+var (
+	prometheusEscaper      = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+	prometheusLabelEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\"", "\\\"")
+)
+
+func prometheusValue(i interface{}) (float64, bool) {
+	switch v := i.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case time.Duration:
+		return v.Seconds(), true
+	}
+	return 0, false
+}
+
 type ServerWorkflow struct {
 	Name   string       `json:"name"`
 	Nodes  []ServerNode `json:"nodes"`
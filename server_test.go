@@ -0,0 +1,141 @@
+package astiencoder
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/asticode/go-astikit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritePrometheusStats(t *testing.T) {
+	w := &bytes.Buffer{}
+	err := writePrometheusStats(w, map[string][]EventStat{
+		"w\"1": {
+			{Description: "d2", Label: "l2", Name: "n.2", Value: 2},
+			{Description: "d1", Label: "l1", Name: "n.1", Value: 1.5},
+			{Description: "d3", Label: "l3", Name: "n.3", Value: "invalid"},
+		},
+		"w2": {
+			{Description: "d2", Label: "l2", Name: "n.2", Value: int64(3)},
+			{Description: "d2", Label: "l2", Name: "n.2", Value: int64(4)},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP astiencoder_n_1 d1
+# TYPE astiencoder_n_1 gauge
+astiencoder_n_1{workflow="w\"1",node="",stat="l1"} 1.5
+# HELP astiencoder_n_2 d2
+# TYPE astiencoder_n_2 gauge
+astiencoder_n_2{workflow="w\"1",node="",stat="l2"} 2
+astiencoder_n_2{workflow="w2",node="",stat="l2"} 3
+`, w.String())
+}
+
+type testServerNode struct {
+	*BaseNode
+}
+
+func newTestServerNode(name string) *testServerNode {
+	n := &testServerNode{}
+	n.BaseNode = NewBaseNode(NodeOptions{Metadata: NodeMetadata{Name: name}}, NewEventHandler(), nil, n, EventTypeToNodeEventName)
+	return n
+}
+
+func (n *testServerNode) Start(ctx context.Context, tc CreateTaskFunc) {}
+
+func TestNewServerEdges(t *testing.T) {
+	// Demuxer -> decoder -> encoder -> muxer
+	//         -> muxer
+	dm, dc, e, m := newTestServerNode("demuxer"), newTestServerNode("decoder"), newTestServerNode("encoder"), newTestServerNode("muxer")
+	ConnectNodes(dm, dc)
+	ConnectNodes(dc, e)
+	ConnectNodes(e, m)
+	ConnectNodes(dm, m)
+
+	es := newServerEdges([]Node{dm, dc, e, m}, []EventStat{
+		{Name: astikit.StatNameWorkRatio, Target: dc, Value: 20.0},
+		{Name: astikit.StatNameWorkRatio, Target: e, Value: 90.0},
+		{Name: astikit.StatNameWorkRatio, Target: m, Value: 10.0},
+		{Name: "other", Target: dm, Value: 100.0},
+	})
+	assert.Equal(t, []ServerEdge{
+		{Backpressure: 0.9, From: "demuxer", Origin: "encoder", To: "decoder", WorkRatio: 0.2},
+		{Backpressure: 0.1, From: "demuxer", Origin: "muxer", To: "muxer", WorkRatio: 0.1},
+		{Backpressure: 0.9, From: "decoder", Origin: "encoder", To: "encoder", WorkRatio: 0.9},
+		{Backpressure: 0.1, From: "encoder", Origin: "muxer", To: "muxer", WorkRatio: 0.1},
+	}, es)
+}
+
+func TestServerWorkflowEvents(t *testing.T) {
+	// Create server
+	eh := NewEventHandler()
+	s := NewServer(ServerOptions{})
+	s.EventHandlerAdapter(eh)
+
+	// Create workflow
+	c := astikit.NewCloser()
+	defer c.Close()
+	w := NewWorkflow(context.Background(), "w", eh, astikit.NewWorker(astikit.WorkerOptions{}).NewTask, c)
+	n1, n2, n3 := newTestServerNode("1"), newTestServerNode("2"), newTestServerNode("3")
+	w.AddChild(n1)
+	s.AddWorkflow(w)
+
+	// Nodes added afterwards are indexed when the workflow starts them
+	w.AddChild(n2)
+	eh.Emit(Event{Name: EventNameWorkflowNodeStarting, Payload: n2, Target: w})
+
+	// Emit
+	for _, n := range []Node{n1, n2, n3} {
+		eh.Emit(Event{Name: "test", Target: n})
+	}
+	var ts []string
+	for _, e := range s.es["w"] {
+		if e.Name == "test" {
+			ts = append(ts, e.Target)
+		}
+	}
+	assert.Equal(t, []string{"1", "2"}, ts)
+
+	// Serve
+	h := s.Handler()
+	for _, v := range []struct {
+		code int
+		path string
+	}{
+		{code: http.StatusOK, path: "/workflows/w/edges"},
+		{code: http.StatusOK, path: "/workflows/w/events"},
+		{code: http.StatusOK, path: "/workflows/w/stats"},
+		{code: http.StatusNotFound, path: "/workflows/invalid/edges"},
+		{code: http.StatusNotFound, path: "/workflows/invalid/events"},
+		{code: http.StatusNotFound, path: "/workflows/invalid/stats"},
+	} {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, v.path, nil))
+		assert.Equal(t, v.code, rw.Code, v.path)
+	}
+
+	// Delete workflow
+	s.DelWorkflow(w)
+	assert.Empty(t, s.es)
+	assert.Empty(t, s.wns)
+}
+
+func TestServerWriteJSON(t *testing.T) {
+	s := NewServer(ServerOptions{})
+
+	// Success
+	rw := httptest.NewRecorder()
+	s.writeJSON(rw, map[string]int{"a": 1})
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "{\"a\":1}\n", rw.Body.String())
+
+	// Marshaling error
+	rw = httptest.NewRecorder()
+	s.writeJSON(rw, make(chan bool))
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+	assert.Empty(t, rw.Body.String())
+}
@@ -3,29 +3,35 @@ package astiencoder
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/asticode/go-astikit"
 )
 
 // Workflow represents a workflow
 type Workflow struct {
-	bn   *BaseNode
-	c    *astikit.Closer
-	ctx  context.Context
-	eh   *EventHandler
-	name string
-	t    *astikit.Task
-	tf   CreateTaskFunc
+	bn          *BaseNode
+	c           *astikit.Closer
+	ctx         context.Context
+	eh          *EventHandler
+	m           *sync.Mutex // Locks pausedNodes
+	name        string
+	pausedNodes map[Node]bool // Nodes paused by the workflow
+	t           *astikit.Task
+	tf          CreateTaskFunc
 }
 
 // NewWorkflow creates a new workflow
 func NewWorkflow(ctx context.Context, name string, eh *EventHandler, tf CreateTaskFunc, c *astikit.Closer) (w *Workflow) {
 	w = &Workflow{
-		c:    c,
-		ctx:  ctx,
-		eh:   eh,
-		name: name,
-		tf:   tf,
+		c:           c,
+		ctx:         ctx,
+		eh:          eh,
+		m:           &sync.Mutex{},
+		name:        name,
+		pausedNodes: make(map[Node]bool),
+		tf:          tf,
 	}
 	w.bn = NewBaseNode(NodeOptions{Metadata: NodeMetadata{
 		Description: "root",
@@ -40,10 +46,44 @@ func (w *Workflow) Name() string {
 	return w.name
 }
 
+// nodes returns the workflow nodes in a deterministic topological order: parents are always before their children
 func (w *Workflow) nodes() (ns []Node) {
-	for _, n := range w.indexedNodes() {
+	// Index nodes
+	ins := w.indexedNodes()
+
+	// Sort names
+	var names []string
+	for name := range ins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Visit parents before appending nodes
+	visited := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		// Node has already been visited
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+
+		// Loop through parents
+		n := ins[name]
+		for _, p := range n.Parents() {
+			if _, ok := ins[p.Metadata().Name]; ok {
+				visit(p.Metadata().Name)
+			}
+		}
+
+		// Append
 		ns = append(ns, n)
 	}
+
+	// Loop through names
+	for _, name := range names {
+		visit(name)
+	}
 	return
 }
 
@@ -53,6 +93,12 @@ func (w *Workflow) indexedNodes() (ns map[string]Node) {
 	return
 }
 
+// Node returns the node with the specified name
+func (w *Workflow) Node(name string) (n Node, ok bool) {
+	n, ok = w.indexedNodes()[name]
+	return
+}
+
 func (w *Workflow) indexedNodesFunc(ns map[string]Node, children []Node) {
 	for _, n := range children {
 		ns[n.Metadata().Name] = n
@@ -63,7 +109,7 @@ func (w *Workflow) indexedNodesFunc(ns map[string]Node, children []Node) {
 // StartNodes starts nodes
 func (w *Workflow) StartNodes(ns ...Node) {
 	for _, n := range ns {
-		n.Start(w.bn.Context(), w.t.NewSubTask)
+		w.startNode(n, w.t.NewSubTask)
 	}
 }
 
@@ -71,14 +117,30 @@ func (w *Workflow) StartNodes(ns ...Node) {
 func (w *Workflow) StartNodesInSubTask(ns ...Node) (t *astikit.Task) {
 	t = w.t.NewSubTask()
 	for _, n := range ns {
-		n.Start(w.bn.Context(), t.NewSubTask)
+		w.startNode(n, t.NewSubTask)
 	}
 	return
 }
 
+func (w *Workflow) startNode(n Node, tf CreateTaskFunc) {
+	// Send starting event
+	w.eh.Emit(Event{
+		Name:    EventNameWorkflowNodeStarting,
+		Payload: n,
+		Target:  w,
+	})
+
+	// Start
+	n.Start(w.bn.Context(), tf)
+}
+
 // WorkflowStartOptions represents workflow start options
 type WorkflowStartOptions struct {
-	Groups []WorkflowStartGroup
+	// If true, nodes are started in reverse topological order: children (e.g. muxers) are started
+	// before their parents (e.g. encoders)
+	// Otherwise parents are started before their children
+	ChildrenFirst bool
+	Groups        []WorkflowStartGroup
 }
 
 // WorkflowStartGroup represents a workflow start group
@@ -108,6 +170,13 @@ func (w *Workflow) start(ns []Node, o WorkflowStartOptions) {
 		// Store task
 		w.t = t
 
+		// Reverse order
+		if o.ChildrenFirst {
+			for i, j := 0, len(ns)-1; i < j; i, j = i+1, j-1 {
+				ns[i], ns[j] = ns[j], ns[i]
+			}
+		}
+
 		// Index groups
 		var gs []*workflowStartGroup
 		ngs := make(map[Node]*workflowStartGroup)
@@ -156,10 +225,18 @@ func (w *Workflow) Stop() {
 }
 
 // Pause pauses the workflow
+// Only running nodes are paused so that nodes that have been paused individually
+// are not continued when the workflow is continued
 func (w *Workflow) Pause() {
 	w.bn.pauseFunc(func() {
+		w.m.Lock()
+		defer w.m.Unlock()
 		for _, n := range w.nodes() {
+			if n.Status() != StatusRunning {
+				continue
+			}
 			n.Pause()
+			w.pausedNodes[n] = true
 		}
 	})
 }
@@ -167,9 +244,12 @@ func (w *Workflow) Pause() {
 // Continue continues the workflow
 func (w *Workflow) Continue() {
 	w.bn.continueFunc(func() {
-		for _, n := range w.nodes() {
+		w.m.Lock()
+		defer w.m.Unlock()
+		for n := range w.pausedNodes {
 			n.Continue()
 		}
+		w.pausedNodes = make(map[Node]bool)
 	})
 }
 